@@ -0,0 +1,315 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"github.com/larrabee/s3sync/storage"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// completionShells are the shells supported by completion command.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+const (
+	// completeMaxResults limits the number of listed buckets and prefixes of S3 URL completion.
+	completeMaxResults = 100
+	// completeTimeout limits S3 requests of S3 URL completion, the shell is blocked while they are running.
+	completeTimeout = 2 * time.Second
+)
+
+var (
+	// possibleValuesRe match enum values in option help, like "Possible values: error, warn, info".
+	possibleValuesRe = regexp.MustCompile(`Possible values: (.*)$`)
+	// valueCommentRe match value comments in option help, like " (rename to key~N)".
+	valueCommentRe = regexp.MustCompile(`\s*\([^)]*\)`)
+)
+
+// completionFlag is CLI flag of args struct field.
+type completionFlag struct {
+	field string
+	names []string
+	// value is set if the flag takes value.
+	value bool
+	enum  []string
+}
+
+// completionFlags return flags of args struct with enum values taken from "Possible values" of options help,
+// so completion is always in sync with the options.
+func completionFlags() []completionFlag {
+	t := reflect.TypeOf(args{})
+	flags := make([]completionFlag, 0, t.NumField()+2)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		long, short := "--"+strings.ToLower(f.Name), ""
+		positional := false
+		for _, opt := range strings.Split(f.Tag.Get("arg"), ",") {
+			switch {
+			case opt == "positional":
+				positional = true
+			case strings.HasPrefix(opt, "--"):
+				long = opt
+			case strings.HasPrefix(opt, "-"):
+				short = opt
+			}
+		}
+		if positional {
+			continue
+		}
+		flag := completionFlag{field: f.Name, names: []string{long}, value: f.Type.Kind() != reflect.Bool}
+		if short != "" {
+			flag.names = append(flag.names, short)
+		}
+		if m := possibleValuesRe.FindStringSubmatch(f.Tag.Get("help")); m != nil {
+			for _, val := range strings.Split(valueCommentRe.ReplaceAllString(m[1], ""), ",") {
+				flag.enum = append(flag.enum, strings.TrimSpace(val))
+			}
+		}
+		flags = append(flags, flag)
+	}
+	return append(flags, completionFlag{names: []string{"--help", "-h"}}, completionFlag{names: []string{"--version"}})
+}
+
+// findCompletionFlag return flag with given name.
+func findCompletionFlag(flags []completionFlag, name string) (completionFlag, bool) {
+	for _, flag := range flags {
+		for _, n := range flag.names {
+			if n == name {
+				return flag, true
+			}
+		}
+	}
+	return completionFlag{}, false
+}
+
+// completionCmd print completion script of given shell, it is "s3sync completion SHELL" command.
+func completionCmd(argv []string, out io.Writer) error {
+	if len(argv) != 1 {
+		return fmt.Errorf("usage: %s completion %s", completionProg(), strings.Join(completionShells, "|"))
+	}
+	var script string
+	switch argv[0] {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return fmt.Errorf("unsupported shell %q, supported shells: %s", argv[0], strings.Join(completionShells, ", "))
+	}
+	_, err := io.WriteString(out, strings.Replace(script, "{{prog}}", completionProg(), -1))
+	return err
+}
+
+// completionProg return program name used in completion scripts.
+func completionProg() string {
+	return filepath.Base(os.Args[0])
+}
+
+// complete print completion candidates of the last word, one per line, it is hidden "__complete" command
+// called by completion scripts with words of the command line after the program name.
+// Nothing is printed if there are no candidates or S3 can't be reached, so the shell falls back to file names.
+func complete(words []string, out io.Writer) {
+	if len(words) == 0 {
+		return
+	}
+	cur, prev := words[len(words)-1], words[:len(words)-1]
+	if (len(prev) == 1) && (prev[0] == "completion") {
+		printCandidates(out, cur, completionShells)
+		return
+	}
+
+	flags := completionFlags()
+	if len(prev) > 0 {
+		if flag, ok := findCompletionFlag(flags, prev[len(prev)-1]); ok && flag.value {
+			printCandidates(out, cur, flag.enum)
+			return
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(cur, "-"):
+		if i := strings.Index(cur, "="); i > 0 {
+			if flag, ok := findCompletionFlag(flags, cur[:i]); ok {
+				values := make([]string, 0, len(flag.enum))
+				for _, val := range flag.enum {
+					values = append(values, cur[:i+1]+val)
+				}
+				printCandidates(out, cur, values)
+			}
+			return
+		}
+		names := make([]string, 0, len(flags))
+		for _, flag := range flags {
+			names = append(names, flag.names...)
+		}
+		printCandidates(out, cur, names)
+	case strings.HasPrefix(cur, "s3://"):
+		side := "Source"
+		if n := completionPositionals(flags, prev); n == 1 {
+			side = "Target"
+		} else if n > 1 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), completeTimeout)
+		defer cancel()
+		candidates, err := completeS3(ctx, side, cur, prev)
+		if err != nil {
+			return
+		}
+		printCandidates(out, cur, candidates)
+	case len(prev) == 0:
+		printCandidates(out, cur, []string{"completion"})
+	}
+}
+
+// printCandidates print candidates starting with cur.
+func printCandidates(out io.Writer, cur string, candidates []string) {
+	for _, c := range candidates {
+		if strings.HasPrefix(c, cur) {
+			fmt.Fprintln(out, c)
+		}
+	}
+}
+
+// completionPositionals return the number of positional args (SOURCE and TARGET) in words.
+func completionPositionals(flags []completionFlag, words []string) int {
+	n := 0
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if w == "--" {
+			return n + len(words) - i - 1
+		}
+		if !strings.HasPrefix(w, "-") || (w == "-") {
+			n++
+			continue
+		}
+		if flag, ok := findCompletionFlag(flags, w); ok && flag.value {
+			i++
+		}
+	}
+	return n
+}
+
+// completeS3 return s3:// URLs of buckets or prefixes matching partial S3 URL cur.
+// Connection options of side ("Source" or "Target") are loaded like for the sync:
+// from config file, environment variables and flags given before the word.
+func completeS3(ctx context.Context, side, cur string, prev []string) ([]string, error) {
+	rawCli := defaultArgs()
+	configPath := findArg(prev, "--config")
+	if configPath == "" {
+		configPath = os.Getenv(envName("Config"))
+	}
+	if configPath != "" {
+		if cfgFile, err := readConfigFile(configPath); err == nil {
+			_, _ = cfgFile.apply(cfgFile.Values, &rawCli, nil)
+		}
+	}
+	_, _ = loadEnv(&rawCli)
+	if p, err := arg.NewParser(arg.Config{}, &rawCli); err == nil {
+		// Incomplete command line is usually invalid, the options parsed before the error are used.
+		_ = p.Parse(prev)
+	}
+
+	// Bucket is empty for bucket name completion, s3://buc.
+	rest := strings.TrimPrefix(cur, "s3://")
+	var bucket, prefix string
+	if i := strings.Index(rest, "/"); i >= 0 {
+		bucket, prefix = rest[:i], rest[i+1:]
+	}
+
+	v := reflect.ValueOf(rawCli)
+	st := storage.NewS3Storage(v.FieldByName(side+"Key").String(), v.FieldByName(side+"Secret").String(),
+		v.FieldByName(side+"Region").String(), v.FieldByName(side+"Endpoint").String(),
+		bucket, "", completeMaxResults, 0, 0,
+	)
+	if file := v.FieldByName(side + "AWSConfigFile").String(); (file != "") && (v.FieldByName(side+"Key").String() == "") {
+		if err := st.WithAWSConfigFile(file); err != nil {
+			return nil, err
+		}
+	}
+	st.WithAnonymous(v.FieldByName(side + "Anonymous").Bool())
+	if proxy := v.FieldByName(side + "Proxy").String(); proxy != "" {
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, err
+		}
+		st.WithProxy(u)
+	}
+
+	if bucket == "" {
+		buckets, err := st.ListBuckets(ctx, rest, completeMaxResults)
+		if err != nil {
+			return nil, err
+		}
+		candidates := make([]string, 0, len(buckets))
+		for _, name := range buckets {
+			candidates = append(candidates, "s3://"+name+"/")
+		}
+		return candidates, nil
+	}
+
+	items, err := st.ListCommonPrefixes(ctx, prefix, completeMaxResults)
+	if err != nil {
+		return nil, err
+	}
+	candidates := make([]string, 0, len(items))
+	for _, item := range items {
+		candidates = append(candidates, "s3://"+bucket+"/"+item)
+	}
+	return candidates, nil
+}
+
+// bashCompletion is bash completion script. Words are split from the line itself instead of COMP_WORDS,
+// since COMP_WORDBREAKS splits s3:// URLs and --flag=value words, the same prefix is removed from candidates.
+const bashCompletion = `# bash completion for {{prog}}, load it with: source <({{prog}} completion bash)
+_{{prog}}_complete() {
+    local line="${COMP_LINE:0:COMP_POINT}" words
+    read -ra words <<< "$line"
+    [[ "$line" =~ [[:space:]]$ ]] && words+=("")
+    local cur="${words[${#words[@]}-1]}" IFS=$'\n'
+    COMPREPLY=($({{prog}} __complete -- "${words[@]:1}" 2>/dev/null))
+    local trim="${cur%"${cur##*[:=]}"}"
+    COMPREPLY=("${COMPREPLY[@]#"$trim"}")
+    if [[ ${#COMPREPLY[@]} -eq 1 && "${COMPREPLY[0]}" == *[/=] ]]; then
+        compopt -o nospace
+    fi
+}
+complete -o default -F _{{prog}}_complete {{prog}}
+`
+
+// zshCompletion is zsh completion script.
+const zshCompletion = `#compdef {{prog}}
+# zsh completion for {{prog}}, load it with: source <({{prog}} completion zsh)
+_{{prog}}() {
+    local -a candidates dirs
+    candidates=("${(@f)$({{prog}} __complete -- "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ${#candidates} == 0 )); then
+        _files
+        return
+    fi
+    dirs=(${(M)candidates:#*[/=]})
+    candidates=(${candidates:#*[/=]})
+    (( ${#dirs} )) && compadd -S '' -- $dirs
+    (( ${#candidates} )) && compadd -- $candidates
+}
+compdef _{{prog}} {{prog}}
+`
+
+// fishCompletion is fish completion script.
+const fishCompletion = `# fish completion for {{prog}}, load it with: {{prog}} completion fish | source
+function __{{prog}}_complete
+    set -l words (commandline -opc) (commandline -ct)
+    {{prog}} __complete -- $words[2..-1] 2>/dev/null
+end
+complete -c {{prog}} -a '(__{{prog}}_complete)'
+`
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// configSkipFields contain args fields which can't be set in the config file.
+var configSkipFields = map[string]bool{"Config": true, "DumpConfig": true, "ImportRun": true}
+
+// jobSkipFields contain args fields which are shared by all jobs and can't be set for a single job.
+var jobSkipFields = map[string]bool{
+	"LogLevel": true, "Debug": true, "Quiet": true, "LogFormat": true, "ShowProgress": true, "ReportInterval": true, "DisableHTTP2": true,
+	"ProgressJSON": true, "ProgressJSONInterval": true, "ExportRun": true, "ExportRunBloomSize": true,
+	"SQSQueueURL": true, "SQSVisibilityTimeout": true,
+	"RateLimitObjPerSec": true, "RateLimitBandwidth": true, "SourceBandwidthLimit": true, "TargetBandwidthLimit": true, "MaxBytes": true,
+	"OtelEndpoint": true, "OtelSampleRatio": true,
+	"ParallelJobs": true, "JobsFilter": true, "ControlSocket": true, "Serve": true, "ServeToken": true,
+}
+
+// configKey return config file key of args struct field, like "source_key" for "SourceKey".
+func configKey(field string) string {
+	var b strings.Builder
+	runes := []rune(field)
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 && (unicode.IsLower(runes[i-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+			b.WriteRune('_')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// normalizeKey allows to write config keys in snake_case, kebab-case or as Go field names.
+func normalizeKey(key string) string {
+	return strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+}
+
+// flagName return CLI flag of args struct field, like "--sk" for "SourceKey".
+func flagName(field string) string {
+	f, ok := reflect.TypeOf(args{}).FieldByName(field)
+	if !ok {
+		return field
+	}
+	for _, opt := range strings.Split(f.Tag.Get("arg"), ",") {
+		if strings.HasPrefix(opt, "--") {
+			return opt
+		}
+		if opt == "positional" {
+			return strings.ToUpper(field)
+		}
+	}
+	return "--" + strings.ToLower(field)
+}
+
+// findArg return value of given flag, like --config, from raw command line args.
+// Config file and imported run should be loaded before the args parsing, so flags can override its values.
+func findArg(argv []string, flag string) string {
+	for i, a := range argv {
+		if a == "--" {
+			break
+		}
+		if a == flag && i+1 < len(argv) {
+			return argv[i+1]
+		}
+		if strings.HasPrefix(a, flag+"=") {
+			return strings.TrimPrefix(a, flag+"=")
+		}
+	}
+	return ""
+}
+
+// clearFlagLists clear list fields of raw args, which are given with flags in raw command line args.
+// go-arg appends flag values to the list, so lists loaded from config file, environment or imported run
+// would be extended by flags. Flags replace the loaded lists like other values.
+func clearFlagLists(argv []string, rawCli *args) {
+	t := reflect.TypeOf(*rawCli)
+	v := reflect.ValueOf(rawCli).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type.Kind() != reflect.Slice {
+			continue
+		}
+		flag := flagName(t.Field(i).Name)
+		for _, a := range argv {
+			if a == "--" {
+				break
+			}
+			if (a == flag) || strings.HasPrefix(a, flag+"=") {
+				v.Field(i).Set(reflect.Zero(t.Field(i).Type))
+				break
+			}
+		}
+	}
+}
+
+// ConfigError raises when config file contain invalid key or value.
+type ConfigError struct {
+	Key string
+	Err string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config key %q: %s", e.Key, e.Err)
+}
+
+// configJob is a sync job defined in "jobs" section of config file.
+type configJob struct {
+	Name   string
+	Values map[string]interface{}
+}
+
+// configCredentials is a named key pair defined in "credentials" section of config file.
+// It can be referenced with source_credentials and target_credentials keys.
+type configCredentials struct {
+	Key    string
+	Secret string
+}
+
+// configConnection is a named connection profile defined in "connections" section of config file.
+// It can be referenced in SOURCE and TARGET as profile://name/bucket/path for S3 or profile://name/path for FS.
+type configConnection struct {
+	Type     storage.Type
+	Endpoint string
+	Region   string
+	// Credentials is the name of credentials from "credentials" section.
+	Credentials       string
+	Anonymous         bool
+	EndpointDiscovery bool
+	// PathStyle is nil if path_style key is not set, path-style requests are used by default.
+	PathStyle             *bool
+	TLSInsecureSkipVerify bool
+	TLSCAFile             string
+	Proxy                 string
+	// Path is the base directory of FS profile.
+	Path string
+}
+
+// configFile is a parsed config file.
+type configFile struct {
+	Path        string
+	Values      map[string]interface{}
+	Jobs        []configJob
+	Credentials map[string]configCredentials
+	Connections map[string]configConnection
+}
+
+// readConfigFile read and parse YAML config file.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &configFile{Path: path, Values: make(map[string]interface{}), Credentials: make(map[string]configCredentials), Connections: make(map[string]configConnection)}
+	if err := yaml.Unmarshal(data, &cfg.Values); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %s", path, err)
+	}
+
+	for key, val := range cfg.Values {
+		switch normalizeKey(key) {
+		case "credentials":
+			creds, err := toStringMap(val)
+			if err != nil {
+				return nil, &ConfigError{Key: key, Err: err.Error()}
+			}
+			for name, c := range creds {
+				values, err := toStringMap(c)
+				if err != nil {
+					return nil, &ConfigError{Key: key + "." + name, Err: err.Error()}
+				}
+				var cred configCredentials
+				for k, v := range values {
+					s, ok := v.(string)
+					if !ok {
+						return nil, &ConfigError{Key: key + "." + name + "." + k, Err: fmt.Sprintf("expected string, got: %v", v)}
+					}
+					switch normalizeKey(k) {
+					case "key":
+						cred.Key = s
+					case "secret":
+						cred.Secret = s
+					default:
+						return nil, &ConfigError{Key: key + "." + name + "." + k, Err: "unknown key"}
+					}
+				}
+				cfg.Credentials[name] = cred
+			}
+			delete(cfg.Values, key)
+		case "connections":
+			conns, err := toStringMap(val)
+			if err != nil {
+				return nil, &ConfigError{Key: key, Err: err.Error()}
+			}
+			for name, c := range conns {
+				values, err := toStringMap(c)
+				if err != nil {
+					return nil, &ConfigError{Key: key + "." + name, Err: err.Error()}
+				}
+				conn, err := parseConfigConnection(key+"."+name, values)
+				if err != nil {
+					return nil, err
+				}
+				cfg.Connections[name] = conn
+			}
+			delete(cfg.Values, key)
+		case "jobs":
+			jobs, ok := val.([]interface{})
+			if !ok {
+				return nil, &ConfigError{Key: key, Err: fmt.Sprintf("expected list of jobs, got: %v", val)}
+			}
+			names := make(map[string]bool, len(jobs))
+			for i, j := range jobs {
+				values, err := toStringMap(j)
+				if err != nil {
+					return nil, &ConfigError{Key: fmt.Sprintf("%s[%d]", key, i), Err: err.Error()}
+				}
+				name, _ := values["name"].(string)
+				if name == "" {
+					return nil, &ConfigError{Key: fmt.Sprintf("%s[%d]", key, i), Err: "job name is required"}
+				}
+				if names[name] {
+					return nil, &ConfigError{Key: fmt.Sprintf("%s[%d]", key, i), Err: fmt.Sprintf("duplicate job name %q", name)}
+				}
+				names[name] = true
+				delete(values, "name")
+				cfg.Jobs = append(cfg.Jobs, configJob{Name: name, Values: values})
+			}
+			delete(cfg.Values, key)
+		}
+	}
+
+	for name, conn := range cfg.Connections {
+		if _, ok := cfg.Credentials[conn.Credentials]; (conn.Credentials != "") && !ok {
+			return nil, &ConfigError{Key: "connections." + name + ".credentials", Err: fmt.Sprintf("unknown credentials %q", conn.Credentials)}
+		}
+	}
+	return cfg, nil
+}
+
+// parseConfigConnection parse connection profile values, key is the profile config key used in errors.
+func parseConfigConnection(key string, values map[string]interface{}) (configConnection, error) {
+	conn := configConnection{Type: storage.TypeS3}
+	for k, v := range values {
+		var err error
+		switch normalizeKey(k) {
+		case "type":
+			var s string
+			if s, err = configString(v); err == nil {
+				switch s {
+				case "s3":
+					conn.Type = storage.TypeS3
+				case "fs":
+					conn.Type = storage.TypeFS
+				default:
+					err = fmt.Errorf("expected one of \"s3, fs\", got: %s", s)
+				}
+			}
+		case "endpoint":
+			conn.Endpoint, err = configString(v)
+		case "region":
+			conn.Region, err = configString(v)
+		case "credentials":
+			conn.Credentials, err = configString(v)
+		case "anonymous":
+			conn.Anonymous, err = configBool(v)
+		case "endpointdiscovery":
+			conn.EndpointDiscovery, err = configBool(v)
+		case "pathstyle":
+			var b bool
+			b, err = configBool(v)
+			conn.PathStyle = &b
+		case "tlsinsecureskipverify":
+			conn.TLSInsecureSkipVerify, err = configBool(v)
+		case "tlscafile":
+			conn.TLSCAFile, err = configString(v)
+		case "proxy":
+			if conn.Proxy, err = configString(v); err == nil {
+				_, err = syncer.ParseProxyURL(conn.Proxy)
+			}
+		case "path":
+			conn.Path, err = configString(v)
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+		if err != nil {
+			return conn, &ConfigError{Key: key + "." + k, Err: err.Error()}
+		}
+	}
+	if (conn.Type == storage.TypeFS) && (conn.Path == "") {
+		return conn, &ConfigError{Key: key, Err: "path is required for fs connection"}
+	}
+	if (conn.Type == storage.TypeFS) && (conn.Proxy != "") {
+		return conn, &ConfigError{Key: key, Err: "proxy can be set only for s3 connection"}
+	}
+	if (conn.Type == storage.TypeS3) && (conn.Path != "") {
+		return conn, &ConfigError{Key: key, Err: "path can be set only for fs connection, bucket and prefix of s3 connection are given in URL"}
+	}
+	return conn, nil
+}
+
+func configString(val interface{}) (string, error) {
+	s, ok := val.(string)
+	if !ok {
+		return "", fmt.Errorf("expected string, got: %v", val)
+	}
+	return s, nil
+}
+
+func configBool(val interface{}) (bool, error) {
+	b, ok := val.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected boolean, got: %v", val)
+	}
+	return b, nil
+}
+
+// toStringMap convert YAML mapping to map with string keys.
+func toStringMap(val interface{}) (map[string]interface{}, error) {
+	m, ok := val.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected mapping, got: %v", val)
+	}
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		res[fmt.Sprint(k)] = v
+	}
+	return res, nil
+}
+
+// apply set values of raw args from config values, fields from skip can't be set.
+// It return config keys of loaded fields.
+func (cfg *configFile) apply(values map[string]interface{}, rawCli *args, skip map[string]bool) (map[string]string, error) {
+	fields := make(map[string]string)
+	t := reflect.TypeOf(*rawCli)
+	for i := 0; i < t.NumField(); i++ {
+		if !configSkipFields[t.Field(i).Name] {
+			fields[normalizeKey(t.Field(i).Name)] = t.Field(i).Name
+		}
+	}
+
+	loaded := make(map[string]string, len(values))
+	v := reflect.ValueOf(rawCli).Elem()
+	// Credentials references are applied first, so explicit keys can override them.
+	for key, val := range values {
+		var prefix string
+		switch normalizeKey(key) {
+		case "sourcecredentials":
+			prefix = "Source"
+		case "targetcredentials":
+			prefix = "Target"
+		default:
+			continue
+		}
+		name, _ := val.(string)
+		cred, ok := cfg.Credentials[name]
+		if !ok {
+			return nil, &ConfigError{Key: key, Err: fmt.Sprintf("unknown credentials %q", name)}
+		}
+		v.FieldByName(prefix + "Key").SetString(cred.Key)
+		v.FieldByName(prefix + "Secret").SetString(cred.Secret)
+		loaded[prefix+"Key"] = key
+		loaded[prefix+"Secret"] = key
+	}
+	for key, val := range values {
+		if k := normalizeKey(key); k == "sourcecredentials" || k == "targetcredentials" {
+			continue
+		}
+		field, ok := fields[normalizeKey(key)]
+		if !ok {
+			return nil, &ConfigError{Key: key, Err: "unknown key"}
+		}
+		if skip[field] {
+			return nil, &ConfigError{Key: key, Err: "can be set only globally"}
+		}
+		if err := setConfigValue(v.FieldByName(field), val); err != nil {
+			return nil, &ConfigError{Key: key, Err: err.Error()}
+		}
+		loaded[field] = key
+	}
+	return loaded, nil
+}
+
+func setConfigValue(f reflect.Value, val interface{}) error {
+	switch f.Kind() {
+	case reflect.String:
+		s, ok := val.(string)
+		if !ok {
+			return fmt.Errorf("expected string, got: %v", val)
+		}
+		f.SetString(s)
+	case reflect.Bool:
+		b, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf("expected boolean, got: %v", val)
+		}
+		f.SetBool(b)
+	case reflect.Uint, reflect.Uint64:
+		n, ok := val.(int)
+		if !ok || n < 0 {
+			return fmt.Errorf("expected non-negative integer, got: %v", val)
+		}
+		f.SetUint(uint64(n))
+	case reflect.Int, reflect.Int64:
+		n, ok := val.(int)
+		if !ok {
+			return fmt.Errorf("expected integer, got: %v", val)
+		}
+		f.SetInt(int64(n))
+	case reflect.Float64:
+		switch n := val.(type) {
+		case int:
+			f.SetFloat(float64(n))
+		case float64:
+			f.SetFloat(n)
+		default:
+			return fmt.Errorf("expected number, got: %v", val)
+		}
+	case reflect.Slice:
+		var list []string
+		switch l := val.(type) {
+		case string:
+			list = []string{l}
+		case []interface{}:
+			for _, item := range l {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("expected list of strings, got item: %v", item)
+				}
+				list = append(list, s)
+			}
+		default:
+			return fmt.Errorf("expected list of strings, got: %v", val)
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type: %s", f.Kind())
+	}
+	return nil
+}
+
+// Sources of option values.
+const (
+	sourceDefault = "default"
+	sourceConfig  = "config"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+	sourceJob     = "job"
+	sourceProfile = "profile"
+	sourceImport  = "import"
+)
+
+// optSource return the source of option value.
+// Flags are detected by comparing the value with the value loaded from config file and environment.
+func (cli *argsParsed) optSource(field string) string {
+	if _, ok := cli.profileKeys[field]; ok {
+		return sourceProfile
+	}
+	if _, ok := cli.jobKeys[field]; ok {
+		return sourceJob
+	}
+	cur := reflect.ValueOf(cli.args).FieldByName(field).Interface()
+	loaded := reflect.ValueOf(cli.loadedArgs).FieldByName(field).Interface()
+	if !reflect.DeepEqual(cur, loaded) {
+		return sourceFlag
+	}
+	if _, ok := cli.envKeys[field]; ok {
+		return sourceEnv
+	}
+	if _, ok := cli.importKeys[field]; ok {
+		return sourceImport
+	}
+	if _, ok := cli.configKeys[field]; ok {
+		return sourceConfig
+	}
+	return sourceDefault
+}
+
+// optName return the name of option to use in error messages.
+// If the value was loaded from config file or environment and not overridden by flag, config key or variable name is returned.
+func (cli *argsParsed) optName(field string) string {
+	switch cli.optSource(field) {
+	case sourceJob:
+		return fmt.Sprintf("job %q config key %q", cli.JobName, cli.jobKeys[field])
+	case sourceProfile:
+		return fmt.Sprintf("connection profile %q", cli.profileKeys[field])
+	case sourceEnv:
+		return fmt.Sprintf("environment variable %q", cli.envKeys[field])
+	case sourceImport:
+		return fmt.Sprintf("imported run key %q", cli.importKeys[field])
+	case sourceConfig:
+		return fmt.Sprintf("config key %q", cli.configKeys[field])
+	}
+	return flagName(field)
+}
+
+// dumpConfig write effective configuration in config file format. Secrets are redacted, including values of
+// secrets like tracing headers found in other options.
+// Every global option is commented with the source of its value, jobs contain only the options set for the job.
+func dumpConfig(w io.Writer, cli argsParsed) error {
+	secrets := &redactor{}
+	secrets.addJob(cli)
+	var buf bytes.Buffer
+	if err := writeConfig(&buf, cli); err != nil {
+		return err
+	}
+	_, err := w.Write(secrets.redact(buf.Bytes()))
+	return err
+}
+
+// writeConfig write configuration of dumpConfig with fields secrets redacted.
+func writeConfig(w io.Writer, cli argsParsed) error {
+	for _, item := range dumpFields(cli.args, nil) {
+		data, err := yaml.Marshal(yaml.MapSlice{item.MapItem})
+		if err != nil {
+			return err
+		}
+		lines := strings.SplitN(string(data), "\n", 2)
+		if _, err := fmt.Fprintf(w, "%s # %s\n%s", lines[0], cli.optSource(item.field), lines[1]); err != nil {
+			return err
+		}
+	}
+	if len(cli.Jobs) == 0 {
+		return nil
+	}
+	var jobsOut []yaml.MapSlice
+	for _, job := range cli.Jobs {
+		jobOut := yaml.MapSlice{{Key: "name", Value: job.JobName}}
+		for _, item := range dumpFields(job.args, job.jobKeys) {
+			jobOut = append(jobOut, item.MapItem)
+		}
+		jobsOut = append(jobsOut, jobOut)
+	}
+	data, err := yaml.Marshal(yaml.MapSlice{{Key: "jobs", Value: jobsOut}})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// dumpItem is a config file item of args field.
+type dumpItem struct {
+	yaml.MapItem
+	field string
+}
+
+// redactURL replace password in URL user info, like in proxy URL.
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "REDACTED")
+	}
+	return u.String()
+}
+
+// dumpFields return args fields as config file items, if only is not nil only given fields are returned.
+func dumpFields(rawCli args, only map[string]string) []dumpItem {
+	var out []dumpItem
+	t := reflect.TypeOf(rawCli)
+	v := reflect.ValueOf(rawCli)
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if configSkipFields[name] {
+			continue
+		}
+		if _, ok := only[name]; only != nil && !ok {
+			continue
+		}
+		val := v.Field(i).Interface()
+		if (strings.Contains(name, "Secret") || strings.HasSuffix(name, "Token")) && v.Field(i).String() != "" {
+			val = "REDACTED"
+		}
+		if strings.HasSuffix(name, "Proxy") || (name == "OtelEndpoint") {
+			val = redactURL(v.Field(i).String())
+		}
+		out = append(out, dumpItem{MapItem: yaml.MapItem{Key: configKey(name), Value: val}, field: name})
+	}
+	return out
+}
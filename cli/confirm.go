@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"os"
+	"strings"
+)
+
+// confirmSync print planned destructive actions and ask user for confirmation.
+// It return true without asking if the target is empty, so no destructive actions are planned, or --yes is given.
+func confirmSync(ctx context.Context, job argsParsed, target storage.Storage) (bool, error) {
+	empty, err := storage.IsEmpty(ctx, target)
+	if err != nil {
+		return false, err
+	}
+	if empty {
+		return true, nil
+	}
+
+	if job.JobName != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Job: %s\n", job.JobName)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Source: %s\nTarget: %s\n", job.args.Source, job.args.Target)
+	if job.SyncACLOnly {
+		_, _ = fmt.Fprintln(os.Stderr, "ACL of not modified objects in the target will be replaced with ACL of source objects.")
+	} else if job.FilterModified || job.CompareListing {
+		_, _ = fmt.Fprintln(os.Stderr, "Target is not empty, modified objects in the target will be overwritten.")
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, "Target is not empty, existing objects in the target will be overwritten.")
+	}
+	if job.ACLFixAll {
+		_, _ = fmt.Fprintln(os.Stderr, "ACL of all objects in the target will be set to private.")
+	}
+	if job.InventoryOld != "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Objects removed from the source since the older inventory will be deleted in the target, their number is confirmed after the sync.")
+	} else if job.SQSQueueURL != "" {
+		_, _ = fmt.Fprintln(os.Stderr, "Objects removed from the source will be deleted in the target.")
+	}
+
+	if job.Yes {
+		return true, nil
+	}
+	return askConfirmation()
+}
+
+// confirmDelete print the number of target objects to delete and ask user for confirmation.
+// It return true without asking if --yes is given.
+func confirmDelete(job argsParsed, count int) (bool, error) {
+	if job.JobName != "" {
+		_, _ = fmt.Fprintf(os.Stderr, "Job: %s\n", job.JobName)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "Target: %s\n%d objects removed from the source will be deleted in the target.\n", job.args.Target, count)
+	if job.Yes {
+		return true, nil
+	}
+	return askConfirmation()
+}
+
+// askConfirmation ask user to proceed and read the answer from stdin.
+func askConfirmation() (bool, error) {
+	_, _ = fmt.Fprint(os.Stderr, "Proceed? [y/N]: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
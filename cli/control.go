@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// controller serves commands from control socket and applies them to running jobs.
+// All methods are safe to call on nil controller.
+type controller struct {
+	mu       sync.Mutex
+	listener net.Listener
+	paused   bool
+	jobs     map[*controlJob]bool
+	source   *storage.AdjustableBucket
+	target   *storage.AdjustableBucket
+	objects  *storage.AdjustableBucket
+}
+
+// controlJob is a running job registered in controller.
+type controlJob struct {
+	name string
+	job  *syncer.Job
+}
+
+// controlStatus is the response of status command.
+type controlStatus struct {
+	Paused               bool               `json:"paused"`
+	SourceBandwidthLimit int                `json:"source_bandwidth_limit"`
+	TargetBandwidthLimit int                `json:"target_bandwidth_limit"`
+	ObjectsLimit         uint               `json:"objects_limit"`
+	Jobs                 []controlJobStatus `json:"jobs"`
+}
+
+type controlJobStatus struct {
+	Name     string `json:"name,omitempty"`
+	InFlight uint   `json:"in_flight"`
+	Synced   uint64 `json:"synced"`
+	Errors   uint64 `json:"errors"`
+}
+
+// newController listen given unix socket path and return new controller.
+// Stale socket file left by killed process is removed.
+func newController(path string, limits sharedLimits) (*controller, error) {
+	if stat, err := os.Lstat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("control socket %s is used by another process", path)
+		}
+		_ = os.Remove(path)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	ctl := &controller{
+		listener: ln,
+		jobs:     make(map[*controlJob]bool),
+		source:   limits.sourceBandwidth,
+		target:   limits.targetBandwidth,
+		objects:  limits.objects,
+	}
+	go ctl.serve()
+	return ctl, nil
+}
+
+// Close stop serving the control socket and remove it.
+func (ctl *controller) Close() {
+	if ctl == nil {
+		return
+	}
+	_ = ctl.listener.Close()
+}
+
+// addJob register running job.
+// It return function to unregister the job.
+func (ctl *controller) addJob(name string, syncJob *syncer.Job) func() {
+	if ctl == nil {
+		return func() {}
+	}
+	job := &controlJob{name: name, job: syncJob}
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	if ctl.paused {
+		syncJob.Pause()
+	}
+	ctl.jobs[job] = true
+	return func() {
+		ctl.mu.Lock()
+		delete(ctl.jobs, job)
+		ctl.mu.Unlock()
+	}
+}
+
+func (ctl *controller) serve() {
+	for {
+		conn, err := ctl.listener.Accept()
+		if err != nil {
+			return
+		}
+		go ctl.handle(conn)
+	}
+}
+
+func (ctl *controller) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		resp, err := ctl.exec(strings.ToLower(fields[0]), fields[1:])
+		if err != nil {
+			resp = "ERR " + err.Error()
+		}
+		if _, err := fmt.Fprintln(conn, resp); err != nil {
+			return
+		}
+	}
+}
+
+// exec run control command and return its response.
+func (ctl *controller) exec(cmd string, params []string) (string, error) {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	switch cmd {
+	case "pause":
+		ctl.paused = true
+		for job := range ctl.jobs {
+			job.job.Pause()
+		}
+		log.Warnf("Sync paused with control socket")
+		return "OK", nil
+	case "resume":
+		ctl.paused = false
+		for job := range ctl.jobs {
+			job.job.Resume()
+		}
+		log.Warnf("Sync resumed with control socket")
+		return "OK", nil
+	case "status":
+		status := controlStatus{
+			Paused:               ctl.paused,
+			SourceBandwidthLimit: int(ctl.source.Limit()),
+			TargetBandwidthLimit: int(ctl.target.Limit()),
+			ObjectsLimit:         uint(ctl.objects.Limit()),
+			Jobs:                 []controlJobStatus{},
+		}
+		for job := range ctl.jobs {
+			jobStatus := controlJobStatus{Name: job.name, InFlight: job.job.InFlight()}
+			for _, val := range job.job.Stats() {
+				jobStatus.Errors += val.Stats.Error
+				if val.Name == "Terminator" {
+					jobStatus.Synced = val.Stats.Input
+				}
+			}
+			status.Jobs = append(status.Jobs, jobStatus)
+		}
+		data, err := json.Marshal(status)
+		return string(data), err
+	case "set-rate":
+		if len(params) != 2 {
+			return "", fmt.Errorf("usage: set-rate bandwidth|source|target|objects VALUE")
+		}
+		switch params[0] {
+		case "bandwidth", "source", "target":
+			rate, err := parseBandwith(params[1])
+			if err != nil {
+				return "", err
+			}
+			if params[0] != "target" {
+				if err := ctl.source.SetRate(float64(rate), int64(rate)); err != nil {
+					return "", err
+				}
+			}
+			if params[0] != "source" {
+				if err := ctl.target.SetRate(float64(rate), int64(rate)); err != nil {
+					return "", err
+				}
+			}
+			log.Warnf("Bandwidth limit (%s) changed with control socket to: %d byte/s", params[0], rate)
+		case "objects":
+			rate, err := strconv.ParseUint(params[1], 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid objects rate: %s", params[1])
+			}
+			if err := ctl.objects.SetRate(float64(rate), int64(rate*2)); err != nil {
+				return "", err
+			}
+			log.Warnf("Objects rate limit changed with control socket to: %d obj/sec", rate)
+		default:
+			return "", fmt.Errorf("unknown rate: %s", params[0])
+		}
+		return "OK", nil
+	default:
+		return "", fmt.Errorf("unknown command: %s", cmd)
+	}
+}
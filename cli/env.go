@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables with option values.
+const envPrefix = "S3SYNC_"
+
+// envName return environment variable name of args struct field, like "S3SYNC_SOURCE_KEY" for "SourceKey".
+func envName(field string) string {
+	return envPrefix + strings.ToUpper(configKey(field))
+}
+
+// EnvError raises when environment variable contain invalid value.
+type EnvError struct {
+	Name string
+	Err  string
+}
+
+func (e *EnvError) Error() string {
+	return fmt.Sprintf("environment variable %q: %s", e.Name, e.Err)
+}
+
+// loadEnv set values of raw args from S3SYNC_ environment variables.
+// It return environment variable names of loaded fields.
+func loadEnv(rawCli *args) (map[string]string, error) {
+	loaded := make(map[string]string)
+	t := reflect.TypeOf(*rawCli)
+	v := reflect.ValueOf(rawCli).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		name := envName(t.Field(i).Name)
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setEnvValue(v.Field(i), val); err != nil {
+			return nil, &EnvError{Name: name, Err: err.Error()}
+		}
+		loaded[t.Field(i).Name] = name
+	}
+	return loaded, nil
+}
+
+func setEnvValue(f reflect.Value, val string) error {
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(val)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return fmt.Errorf("expected boolean, got: %s", val)
+		}
+		f.SetBool(b)
+	case reflect.Uint, reflect.Uint64:
+		n, err := strconv.ParseUint(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected non-negative integer, got: %s", val)
+		}
+		f.SetUint(n)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected integer, got: %s", val)
+		}
+		f.SetInt(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return fmt.Errorf("expected number, got: %s", val)
+		}
+		f.SetFloat(n)
+	case reflect.Slice:
+		// Only comma separates values, colon is a part of values like owner maps and URLs.
+		list := strings.FieldsFunc(val, func(r rune) bool { return r == ',' })
+		for i := range list {
+			list[i] = strings.TrimSpace(list[i])
+		}
+		f.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported type: %s", f.Kind())
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"time"
+)
+
+// runSummary is the sync summary passed to --hook-post-run command.
+type runSummary struct {
+	Job      string  `json:"job,omitempty"`
+	Source   string  `json:"source"`
+	Target   string  `json:"target"`
+	Status   int     `json:"status"`
+	Objects  uint64  `json:"objects"`
+	Errors   uint64  `json:"errors"`
+	Bytes    uint64  `json:"bytes"`
+	Duration float64 `json:"duration_sec"`
+}
+
+// runPostRunHook run --hook-post-run command with summary of finished job on stdin.
+// The hook is executed even if the sync was interrupted, so it is not limited with sync context.
+func runPostRunHook(job argsParsed, res jobResult, size uint64) error {
+	data, err := json.Marshal(runSummary{
+		Job:      job.JobName,
+		Source:   job.args.Source,
+		Target:   job.args.Target,
+		Status:   res.status,
+		Objects:  res.synced,
+		Errors:   res.errors,
+		Bytes:    size,
+		Duration: res.duration.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	if job.HookTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(job.HookTimeout)*time.Second)
+		defer cancel()
+	}
+	env := []string{"S3SYNC_HOOK=post-run", "S3SYNC_JOB=" + job.JobName}
+	return collection.RunHook(ctx, job.HookPostRun, env, bytes.NewReader(data), "post-run")
+}
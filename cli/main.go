@@ -4,16 +4,17 @@ package main
 import (
 	"context"
 	"fmt"
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/gosuri/uilive"
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/pipeline/collection"
 	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"github.com/larrabee/s3sync/tracing"
 	"github.com/sirupsen/logrus"
 	"os"
 	"os/signal"
 	"runtime"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -21,17 +22,22 @@ import (
 var cli argsParsed
 var log = logrus.New()
 var live *uilive.Writer
+var progressJSON *progressWriter
 
 const (
-	fsListBufSize   = 32 * 1024 * 1024
 	goThreadsPerCPU = 8
 )
 
-// init program runtime: parse cli args and set logger
-func init() {
+// setup program runtime: parse cli args and set logger.
+// It is called from main instead of init, so the package tests don't parse test binary args.
+// Log redaction is set up first, secrets of environment and args are added as soon as they are known.
+func setup() {
 	runtime.GOMAXPROCS(runtime.NumCPU() * goThreadsPerCPU)
+	setLogFormatter(&logrus.TextFormatter{})
+	logRedactor.add(os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
 	var err error
 	cli, err = GetCliArgs()
+	logRedactor.addJob(cli)
 	if err != nil {
 		log.Fatalf("cli args parsing failed with error: %s", err)
 	}
@@ -39,191 +45,341 @@ func init() {
 		live = uilive.New()
 		live.Start()
 		log.SetOutput(live.Bypass())
-		log.SetFormatter(&logrus.TextFormatter{ForceColors: true})
+		setLogFormatter(&logrus.TextFormatter{ForceColors: true})
 	}
-	if cli.Debug {
-		log.SetLevel(logrus.DebugLevel)
+	if cli.LogFormat == "json" {
+		setLogFormatter(&logrus.JSONFormatter{})
 	}
+	log.SetLevel(cli.LogLevel)
 	pipeline.Log = log
 	storage.Log = log
+	tracing.Log = log
+}
+
+// sharedLimits contain rate limits and byte budget shared by all jobs.
+type sharedLimits struct {
+	sourceBandwidth *storage.AdjustableBucket
+	targetBandwidth *storage.AdjustableBucket
+	objects         *storage.AdjustableBucket
+	bytes           *collection.ByteBudget
+}
+
+// jobResult contain results of finished job.
+type jobResult struct {
+	status   int
+	synced   uint64
+	errors   uint64
+	duration time.Duration
+}
+
+// summaryOnly check if info messages are not logged (--quiet or --log-level warn, error).
+// Step stats are hidden in this case and the final summary is printed to stderr as single line.
+func (cli *argsParsed) summaryOnly() bool {
+	return cli.LogLevel < logrus.InfoLevel
 }
 
 func main() {
+	defer logPanic()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "completion":
+			if err := completionCmd(os.Args[2:], os.Stdout); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(2)
+			}
+			return
+		case "__complete":
+			words := os.Args[2:]
+			if (len(words) > 0) && (words[0] == "--") {
+				words = words[1:]
+			}
+			complete(words, os.Stdout)
+			return
+		}
+	}
+	setup()
 	ctx, cancel := context.WithCancel(context.Background())
 
-	syncGroup := pipeline.NewGroup()
-	syncGroup.WithContext(ctx)
+	var tracer *tracing.Tracer
+	if cli.OtelTracesEndpoint != "" {
+		serviceName := os.Getenv("OTEL_SERVICE_NAME")
+		if serviceName == "" {
+			serviceName = "s3sync"
+		}
+		tracer = tracing.NewTracer(tracing.Config{
+			Endpoint:    cli.OtelTracesEndpoint,
+			Headers:     cli.OtelHeaders,
+			ServiceName: serviceName,
+			SampleRatio: cli.OtelSampleRatio,
+			TraceParent: os.Getenv("TRACEPARENT"),
+		})
+	}
 
+	// Scheduled runs are stopped gracefully: the first signal stops the schedule and waits for running runs,
+	// the second one interrupts them.
+	stopCtx, stopSchedule := context.WithCancel(ctx)
 	sysStopChan := make(chan os.Signal, 1)
 	signal.Notify(sysStopChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
-
-	var sourceStorage, targetStorage storage.Storage
-	switch cli.Source.Type {
-	case storage.TypeS3:
-		sourceStorage = storage.NewS3Storage(cli.SourceKey, cli.SourceSecret, cli.SourceRegion, cli.SourceEndpoint,
-			cli.Source.Bucket, cli.Source.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
-		)
-	case storage.TypeFS:
-		sourceStorage = storage.NewFSStorage(cli.Source.Path, cli.FSFilePerm, cli.FSDirPerm, fsListBufSize, !cli.FSDisableXattr)
-	}
-
-	switch cli.Target.Type {
-	case storage.TypeS3:
-		targetStorage = storage.NewS3Storage(cli.TargetKey, cli.TargetSecret, cli.TargetRegion, cli.TargetEndpoint,
-			cli.Target.Bucket, cli.Target.Path, cli.S3KeysPerReq, cli.S3Retry, cli.S3RetryInterval,
-		)
-	case storage.TypeFS:
-		targetStorage = storage.NewFSStorage(cli.Target.Path, cli.FSFilePerm, cli.FSDirPerm, 0, !cli.FSDisableXattr)
-	}
-
-	sourceStorage.WithContext(ctx)
-	targetStorage.WithContext(ctx)
-	if cli.RateLimitBandwidth > 0 {
-		err := sourceStorage.WithRateLimit(cli.RateLimitBandwidth)
-		if err != nil {
-			log.Fatalf("Bandwidth limit error: %s", err)
+	go func() {
+		recSignal := <-sysStopChan
+		if scheduled(cli) {
+			log.Warnf("Receive signal: %s, waiting for running syncs to finish, send it again to terminate", recSignal.String())
+			stopSchedule()
+			recSignal = <-sysStopChan
+		}
+		log.Warnf("Receive signal: %s, terminating", recSignal.String())
+		cancel()
+	}()
+
+	// Limits can be changed with control socket, so they are created even without initial value.
+	var limits sharedLimits
+	if (cli.SourceBandwidth > 0) || (cli.ControlSocket != "") {
+		limits.sourceBandwidth = storage.NewAdjustableBucket()
+		if err := limits.sourceBandwidth.SetRate(float64(cli.SourceBandwidth), int64(cli.SourceBandwidth)); err != nil {
+			log.Fatalf("Source bandwidth limit error: %s", err)
+		}
+	}
+	if (cli.TargetBandwidth > 0) || (cli.ControlSocket != "") {
+		limits.targetBandwidth = storage.NewAdjustableBucket()
+		if err := limits.targetBandwidth.SetRate(float64(cli.TargetBandwidth), int64(cli.TargetBandwidth)); err != nil {
+			log.Fatalf("Target bandwidth limit error: %s", err)
+		}
+	}
+	if (cli.RateLimitObjPerSec > 0) || (cli.ControlSocket != "") {
+		limits.objects = storage.NewAdjustableBucket()
+		if err := limits.objects.SetRate(float64(cli.RateLimitObjPerSec), int64(cli.RateLimitObjPerSec*2)); err != nil {
+			log.Fatalf("Objects rate limit error: %s", err)
 		}
 	}
 
-	syncGroup.SetSource(sourceStorage)
-	syncGroup.SetTarget(targetStorage)
-
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:     "ListSource",
-		Fn:       collection.ListSourceStorage,
-		ChanSize: cli.ListBuffer,
-	})
+	if cli.MaxBytes > 0 {
+		limits.bytes = collection.NewByteBudget(uint64(cli.MaxBytes))
+	}
 
-	if len(cli.FilterExt) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByExt",
-			Fn:     collection.FilterObjectsByExt,
-			Config: cli.FilterExt,
-		})
+	var ctl *controller
+	if cli.ControlSocket != "" {
+		var err error
+		if ctl, err = newController(cli.ControlSocket, limits); err != nil {
+			log.Fatalf("Control socket error: %s", err)
+		}
 	}
 
-	if len(cli.FilterExtNot) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByExtNot",
-			Fn:     collection.FilterObjectsByExtNot,
-			Config: cli.FilterExtNot,
-		})
+	if cli.ProgressJSON != "" {
+		var err error
+		if progressJSON, err = openProgressWriter(cli.ProgressJSON); err != nil {
+			log.Fatalf("Progress JSON (%s) error: %s", cli.optName("ProgressJSON"), err)
+		}
 	}
 
-	loadObjMetaStep := pipeline.Step{
-		Name:       "LoadObjMeta",
-		Fn:         collection.LoadObjectMeta,
-		AddWorkers: cli.Workers,
+	if cli.Serve != "" {
+		status := 0
+		if err := serve(ctx, cli, limits, tracer, ctl); err != nil {
+			log.Errorf("API server error: %s", err)
+			status = 1
+		}
+		ctl.Close()
+		progressJSON.Close()
+		tracer.Shutdown()
+		log.Exit(status)
 	}
-	if (cli.Source.Type == storage.TypeFS) && ((cli.FilterMtimeAfter > 0) || (cli.FilterMtimeBefore > 0) || cli.FilterModified) {
-		syncGroup.AddPipeStep(loadObjMetaStep)
-	} else if (len(cli.FilterCT) > 0) || (len(cli.FilterCTNot) > 0) {
-		syncGroup.AddPipeStep(loadObjMetaStep)
+
+	jobs := cli.Jobs
+	if len(jobs) == 0 {
+		jobs = []argsParsed{cli}
 	}
 
-	if cli.FilterMtimeAfter > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjectsByMtimeAfter",
-			Fn:     collection.FilterObjectsByMtimeAfter,
-			Config: cli.FilterMtimeAfter,
-		})
+	if scheduled(cli) {
+		status := runScheduled(ctx, stopCtx, jobs, limits, tracer, ctl)
+		ctl.Close()
+		progressJSON.Close()
+		tracer.Shutdown()
+		log.Exit(status)
 	}
 
-	if cli.FilterMtimeBefore > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjectsByMtimeBefore",
-			Fn:     collection.FilterObjectsByMtimeBefore,
-			Config: cli.FilterMtimeBefore,
-		})
+	results := make([]jobResult, len(jobs))
+	jobsChan := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := uint(0); w < cli.ParallelJobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobsChan {
+				if ctx.Err() != nil {
+					results[i].status = 2
+					continue
+				}
+				if limits.bytes != nil && limits.bytes.Exhausted() {
+					log.Warnf("Job %s is skipped, byte limit is reached", jobs[i].JobName)
+					continue
+				}
+				results[i] = runJob(ctx, jobs[i], limits, tracer, ctl)
+			}
+		}()
 	}
+	for i := range jobs {
+		jobsChan <- i
+	}
+	close(jobsChan)
+	wg.Wait()
+	ctl.Close()
+	progressJSON.Close()
+	tracer.Shutdown()
 
-	if len(cli.FilterCT) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByCT",
-			Fn:     collection.FilterObjectsByCT,
-			Config: cli.FilterCT,
-		})
+	syncStatus := 0
+	for _, res := range results {
+		if res.status > syncStatus {
+			syncStatus = res.status
+		}
 	}
 
-	if len(cli.FilterCTNot) > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "FilterObjByCTNot",
-			Fn:     collection.FilterObjectsByCTNot,
-			Config: cli.FilterCTNot,
-		})
+	if len(cli.Jobs) > 0 {
+		var failed, synced, errCnt uint64
+		var duration time.Duration
+		for i, res := range results {
+			if res.status != 0 {
+				failed++
+			}
+			synced += res.synced
+			errCnt += res.errors
+			if res.duration > duration {
+				duration = res.duration
+			}
+			if cli.summaryOnly() {
+				_, _ = fmt.Fprintf(os.Stderr, "Job %s finished: status: %d; Objects: %d; Errors: %d; Duration: %s\n", jobs[i].JobName, res.status, res.synced, res.errors, res.duration.String())
+			} else {
+				log.Infof("Job %s: status: %d; Objects: %d; Errors: %d; Duration: %s", jobs[i].JobName, res.status, res.synced, res.errors, res.duration.String())
+			}
+		}
+		if cli.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Jobs finished: status: %d; Jobs: %d; Failed: %d; Objects: %d; Errors: %d\n", syncStatus, len(jobs), failed, synced, errCnt)
+		} else {
+			log.Infof("Jobs finished: Jobs: %d; Failed: %d; Objects: %d; Errors: %d; Longest job duration: %s", len(jobs), failed, synced, errCnt, duration.String())
+		}
 	}
 
-	if cli.FilterModified {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name: "FilterObjectsModified",
-			Fn:   collection.FilterObjectsModified,
-		})
+	if limits.bytes != nil {
+		if cli.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Byte limit: Transferred: %d of %d bytes; Reached: %t\n", limits.bytes.Used(), limits.bytes.Limit(), limits.bytes.Exhausted())
+		} else if limits.bytes.Exhausted() {
+			log.Warnf("Byte limit (%s) reached: Transferred: %d of %d bytes, remaining objects are skipped", cli.optName("MaxBytes"), limits.bytes.Used(), limits.bytes.Limit())
+		} else {
+			log.Infof("Byte limit: Transferred: %d of %d bytes", limits.bytes.Used(), limits.bytes.Limit())
+		}
 	}
 
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:       "LoadObjData",
-		Fn:         collection.LoadObjectData,
-		AddWorkers: cli.Workers,
-	})
+	log.Exit(syncStatus)
+}
 
-	if (cli.Target.Type == storage.TypeS3) && (cli.S3Acl != "") {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "ACLUpdater",
-			Fn:     collection.ACLUpdater,
-			Config: cli.S3Acl,
-		})
+// runJob run single sync job and wait for its completion.
+// Job is cancelled on error, the parent context cancellation terminates it with status 2.
+func runJob(ctx context.Context, job argsParsed, limits sharedLimits, tracer *tracing.Tracer, ctl *controller) (res jobResult) {
+	defer logPanic()
+	logRedactor.addJob(job)
+	var jobLog logrus.FieldLogger = log
+	if job.JobName != "" {
+		jobLog = log.WithField("job", job.JobName)
+	}
+	if job.scheduledRun > 0 {
+		jobLog = jobLog.WithField("run", job.scheduledRun)
 	}
 
-	if (cli.Target.Type == storage.TypeS3) && (cli.S3StorageClass != "") {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "StorageClassUpdater",
-			Fn:     collection.StorageClassUpdater,
-			Config: cli.S3StorageClass,
-		})
+	if job.TargetAnonymous {
+		jobLog.Warnf("Anonymous target (%s) is only readable, unless the bucket allows anonymous uploads", job.optName("TargetAnonymous"))
+	}
+	if (job.S3RetryInterval > 0) && (job.S3Retry == 0) {
+		jobLog.Warnf("Retry sleep (%s) has no effect without %s", job.optName("S3RetryInterval"), job.optName("S3Retry"))
+	}
+	if job.S3KeysPerReq > s3MaxKeysPerReq {
+		jobLog.Warnf("%s is greater than S3 limit %d, it works only if the S3 endpoint supports larger List requests", job.optName("S3KeysPerReq"), s3MaxKeysPerReq)
 	}
 
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name:       "UploadObj",
-		Fn:         collection.UploadObjectData,
-		AddWorkers: cli.Workers,
-	})
+	opts := job.syncOptions(limits, tracer, jobLog)
+	completed, err := job.completedKeys()
+	if err != nil {
+		jobLog.Errorf("Failed to load imported run: %s", err)
+		res.status = 1
+		return
+	}
+	if job.imported != nil {
+		opts.Filters.Completed = completed
+		if job.ListStartAfter != "" {
+			jobLog.Infof("Resuming imported run: Completed objects: %d; Listing after: %s", job.imported.Completed.Keys, job.ListStartAfter)
+		} else {
+			jobLog.Infof("Resuming imported run: Completed objects: %d", job.imported.Completed.Keys)
+		}
+	}
+	if job.LatencyLogFile != "" {
+		latencyLog, err := openLatencyLog(job.LatencyLogFile)
+		if err != nil {
+			jobLog.Errorf("Failed to open latency log: %s", err)
+			res.status = 1
+			return
+		}
+		defer latencyLog.Close()
+		opts.LatencyLog = latencyLog
+	}
+	var exporter *runExporter
+	if job.ExportRun != "" {
+		opts.OnObject = func(obj *storage.Object) {
+			completed.Add(*obj.Key)
+		}
+		exporter = newRunExporter(job, completed)
+	}
 
-	if cli.SyncLog {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "Logger",
-			Fn:     collection.Logger,
-			Config: log,
-		})
+	if job.Confirm && (job.InventoryOld != "") {
+		opts.ConfirmDelete = func(count int) bool {
+			confirmed, err := confirmDelete(job, count)
+			if err != nil {
+				jobLog.Errorf("Confirmation of deletion failed with error: %s", err)
+			}
+			return confirmed
+		}
 	}
 
-	if cli.RateLimitObjPerSec > 0 {
-		syncGroup.AddPipeStep(pipeline.Step{
-			Name:   "RateLimit",
-			Fn:     collection.PipelineRateLimit,
-			Config: cli.RateLimitObjPerSec,
-		})
+	syncJob, err := syncer.New(opts)
+	if err != nil {
+		jobLog.Errorf("Sync configuration error: %s", err)
+		res.status = 1
+		return
+	}
+
+	if job.Confirm {
+		confirmed, err := confirmSync(ctx, job, syncJob.Target())
+		if err != nil {
+			jobLog.Errorf("Confirmation failed with error: %s", err)
+			res.status = 1
+			return
+		}
+		if !confirmed {
+			jobLog.Warnf("Sync is not confirmed, terminating")
+			res.status = 2
+			return
+		}
 	}
 
-	syncGroup.AddPipeStep(pipeline.Step{
-		Name: "Terminator",
-		Fn:   collection.Terminator,
-	})
+	removeCtlJob := ctl.addJob(job.JobName, syncJob)
+	defer removeCtlJob()
+	if job.onStart != nil {
+		job.onStart(syncJob)
+	}
 
-	log.Info("Starting sync")
 	syncStartTime := time.Now()
-	syncGroup.Run()
-
-	if cli.ShowProgress {
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	if job.ShowProgress {
 		go func() {
 			for {
 				select {
-				case <-ctx.Done():
+				case <-progressCtx.Done():
 					return
 				default:
 					dur := time.Since(syncStartTime).Seconds()
-					for _, val := range syncGroup.GetStepsInfo() {
+					for _, val := range syncJob.Stats() {
 						_, _ = fmt.Fprintf(live, "%d %s: Input: %d; Output: %d (%.f obj/sec); Errors: %d\n", val.Num, val.Name, val.Stats.Input, val.Stats.Output, float64(val.Stats.Output)/dur, val.Stats.Error)
 					}
+					if limits.bytes != nil {
+						_, _ = fmt.Fprintf(live, "Transferred: %d of %d bytes\n", limits.bytes.Used(), limits.bytes.Limit())
+					}
 					_, _ = fmt.Fprintf(live, "Duration: %s\n", time.Since(syncStartTime).String())
 					time.Sleep(time.Second)
 				}
@@ -231,45 +387,177 @@ func main() {
 		}()
 	}
 
-	syncStatus := 0
+	if job.ReportInterval > 0 {
+		go reportProgress(progressCtx, job.JobName, syncJob, job.ReportInterval)
+	}
+	progressJSONDone := make(chan struct{})
+	if progressJSON != nil {
+		go reportProgressJSON(progressCtx, progressJSON, job, syncJob, progressJSONDone)
+	} else {
+		close(progressJSONDone)
+	}
+	exportDone := make(chan struct{})
+	if exporter != nil {
+		go exporter.run(progressCtx, jobLog, exportDone)
+	} else {
+		close(exportDone)
+	}
 
-WaitLoop:
-	for {
-		select {
-		case recSignal := <-sysStopChan:
-			log.Warnf("Receive signal: %s, terminating", recSignal.String())
-			cancel()
-			syncStatus = 2
-			break WaitLoop
-		case err := <-syncGroup.ErrChan():
-			if err == nil {
-				log.Infof("Sync Done")
-				break WaitLoop
-			}
-			if cli.OnFail == onFailSkip && err.(*pipeline.PipelineError).Err != context.Canceled {
-				log.Errorf("Sync err: %s, skipping", err)
-				continue WaitLoop
+	syncRes, err := syncJob.Run(ctx)
+	stopProgress()
+	<-progressJSONDone
+	<-exportDone
+	if ctx.Err() != nil {
+		res.status = 2
+	} else if err == syncer.ErrMaxRuntime {
+		jobLog.Warnf("Sync is stopped by %s, run it again to continue", job.optName("MaxRuntime"))
+		res.status = 3
+	} else if err == syncer.ErrDeleteNotConfirmed {
+		res.status = 2
+	} else if err != nil {
+		res.status = 1
+	}
+
+	if exporter != nil {
+		var lastKey string
+		if (res.status == 0) && syncRes.ListTruncated {
+			lastKey = syncRes.ListLastKey
+		}
+		if err := exporter.finish((res.status == 0) && !syncRes.ListTruncated, lastKey); err != nil {
+			jobLog.Errorf("Failed to export run to %s: %s", job.ExportRun, err)
+			if res.status == 0 {
+				res.status = 1
 			}
-			aerr, ok := err.(*pipeline.PipelineError).Err.(awserr.Error)
-			if (cli.OnFail == onFailSkipMissing) && ok && ((aerr.Code() == s3.ErrCodeNoSuchKey) || (aerr.Code() == "NotFound")) {
-				log.Infof("Skip missing object, err: %s", aerr.Error())
-				continue WaitLoop
+		} else if rate := completed.FalsePositiveRate(); rate > 0.01 {
+			jobLog.Warnf("Run exported to %s, bloom filter is too small, false-positive skip rate is %.2f%%, increase %s", job.ExportRun, rate*100, job.optName("ExportRunBloomSize"))
+		} else {
+			jobLog.Infof("Run exported to %s: False-positive skip rate: %.4f%%", job.ExportRun, rate*100)
+		}
+	}
+	res.synced = syncRes.Synced
+	res.errors = syncRes.Errors
+	res.duration = syncRes.Duration
+
+	if job.HookPostRun != "" {
+		if err := runPostRunHook(job, res, syncRes.Bytes); err != nil {
+			jobLog.Errorf("Post-run hook failed with error: %s", err)
+			if (job.HookOnFail == "fail") && (res.status == 0) {
+				res.status = 1
 			}
+		}
+	}
 
-			log.Errorf("Sync error: %s, terminating", err)
-			syncStatus = 1
-			cancel()
-			break WaitLoop
+	if job.summaryOnly() {
+		if job.JobName == "" {
+			_, _ = fmt.Fprintf(os.Stderr, "Sync finished: status: %d; Objects: %d; Errors: %d; Duration: %s\n", res.status, res.synced, res.errors, res.duration.String())
+		}
+	} else {
+		dur := res.duration.Seconds()
+		for _, val := range syncRes.Steps {
+			jobLog.Infof("%d %s: Input: %d; Output: %d (%.f obj/sec); Errors: %d\n", val.Num, val.Name, val.Stats.Input, val.Stats.Output, float64(val.Stats.Output)/dur, val.Stats.Error)
 		}
+		jobLog.Infof("Duration: %s", res.duration.String())
 	}
 
-	{
-		dur := time.Since(syncStartTime).Seconds()
-		for _, val := range syncGroup.GetStepsInfo() {
-			log.Infof("%d %s: Input: %d; Output: %d (%.f obj/sec); Errors: %d\n", val.Num, val.Name, val.Stats.Input, val.Stats.Output, float64(val.Stats.Output)/dur, val.Stats.Error)
+	if syncRes.SpecialFiles > 0 {
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Special files skipped: %d\n", syncRes.SpecialFiles)
+		} else {
+			jobLog.Warnf("Special files skipped: %d", syncRes.SpecialFiles)
 		}
-		log.Infof("Duration: %s", time.Since(syncStartTime).String())
 	}
 
-	log.Exit(syncStatus)
+	if syncRes.Throughput != nil {
+		objects, size := syncRes.Throughput.Objects(), syncRes.Throughput.Bytes()
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Benchmark finished: Objects: %d; Bytes: %d; Throughput: %.f byte/s (%.f obj/sec)\n",
+				objects, size, collection.Throughput(size, res.duration), collection.Throughput(objects, res.duration))
+		} else {
+			jobLog.Infof("Benchmark: Objects: %d; Bytes: %d; Throughput: %.f byte/s (%.f obj/sec)",
+				objects, size, collection.Throughput(size, res.duration), collection.Throughput(objects, res.duration))
+		}
+	}
+
+	if job.InventoryOld != "" {
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Inventory diff: Objects: %d; Deleted: %d\n", syncRes.Synced, syncRes.Deleted)
+		} else {
+			jobLog.Infof("Inventory diff: Objects: %d; Deleted: %d", syncRes.Synced, syncRes.Deleted)
+		}
+	}
+
+	if job.SQSQueueURL != "" {
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "S3 events: Objects: %d; Deleted: %d\n", syncRes.Synced, syncRes.Deleted)
+		} else {
+			jobLog.Infof("S3 events: Objects: %d; Deleted: %d", syncRes.Synced, syncRes.Deleted)
+		}
+	}
+
+	if job.SourceListMaxPages > 0 {
+		switch {
+		case syncRes.ListTruncated && job.summaryOnly():
+			_, _ = fmt.Fprintf(os.Stderr, "Listing stopped: Pages: %d; Last key: %s\n", job.SourceListMaxPages, syncRes.ListLastKey)
+		case syncRes.ListTruncated:
+			jobLog.Infof("Listing stopped after %d pages, continue with %s %s", job.SourceListMaxPages, job.optName("ListStartAfter"), syncRes.ListLastKey)
+		case job.summaryOnly():
+			_, _ = fmt.Fprintf(os.Stderr, "Listing completed: Last key: %s\n", syncRes.ListLastKey)
+		default:
+			jobLog.Infof("Listing completed, last key: %s", syncRes.ListLastKey)
+		}
+	}
+
+	if syncRes.Renames != nil {
+		for _, r := range syncRes.Renames.List() {
+			jobLog.Warnf("Renamed: %s -> %s (conflicts with %s)", r.Key, r.TargetKey, r.ConflictKey)
+		}
+	}
+
+	if syncRes.FlattenCollisions != nil {
+		for _, r := range syncRes.FlattenCollisions.List() {
+			jobLog.Warnf("Flatten collision: %s -> %s (collides with %s)", r.Key, r.TargetKey, r.ConflictKey)
+		}
+	}
+
+	if syncRes.Timing != nil {
+		for _, phase := range collection.TimingPhases {
+			jobLog.Infof("Timing %s: p50: %s; p90: %s; p99: %s; max: %s", phase, syncRes.Timing.Percentile(phase, 50),
+				syncRes.Timing.Percentile(phase, 90), syncRes.Timing.Percentile(phase, 99), syncRes.Timing.Percentile(phase, 100))
+		}
+	}
+
+	if syncRes.Latency != nil {
+		l := syncRes.Latency
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Replication latency: Objects: %d; p50: %s; p95: %s; p99: %s; max: %s\n",
+				l.Count(), l.Percentile(50), l.Percentile(95), l.Percentile(99), l.Percentile(100))
+		} else {
+			jobLog.Infof("Replication latency: Objects: %d; p50: %s; p95: %s; p99: %s; max: %s",
+				l.Count(), l.Percentile(50), l.Percentile(95), l.Percentile(99), l.Percentile(100))
+		}
+	}
+
+	if syncRes.Prefixes != nil {
+		printPrefixes(job, jobLog, syncRes.Prefixes.List())
+	}
+
+	if syncRes.ContentDedup != nil {
+		objects, size := syncRes.ContentDedup.Copies()
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Dedup by content: Copied objects: %d; Copied bytes: %d\n", objects, size)
+		} else {
+			jobLog.Infof("Dedup by content: Copied objects: %d; Copied bytes: %d", objects, size)
+		}
+	}
+
+	if d := syncRes.Dedup; d != nil {
+		if job.summaryOnly() {
+			_, _ = fmt.Fprintf(os.Stderr, "Dedup: Chunks: %d; Bytes: %d; Uploaded chunks: %d; Uploaded bytes: %d\n",
+				d.Chunks, d.Bytes, d.NewChunks, d.NewBytes)
+		} else {
+			jobLog.Infof("Dedup: Chunks: %d; Bytes: %d; Uploaded chunks: %d; Uploaded bytes: %d", d.Chunks, d.Bytes, d.NewChunks, d.NewBytes)
+		}
+	}
+
+	return
 }
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/syncer"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// progressSnapshot is the progress of the job at some moment.
+// Rates are measured since the previous snapshot, ETA is estimated from pending objects and object rate.
+type progressSnapshot struct {
+	Time     time.Time `json:"time"`
+	Job      string    `json:"job,omitempty"`
+	Run      uint64    `json:"run,omitempty"`
+	Objects  uint64    `json:"objects"`
+	Listed   uint64    `json:"listed"`
+	Pending  uint64    `json:"pending"`
+	Errors   uint64    `json:"errors"`
+	Bytes    uint64    `json:"bytes"`
+	ObjRate  float64   `json:"objects_per_sec"`
+	ByteRate float64   `json:"bytes_per_sec"`
+	// ETA is nil if it is unknown, since no objects were synced in the last interval.
+	ETA      *float64 `json:"eta_seconds"`
+	Duration float64  `json:"duration_seconds"`
+	// Final is set for the last snapshot written after the job is finished.
+	Final bool `json:"final,omitempty"`
+}
+
+// progressTracker take progress snapshots of the job.
+type progressTracker struct {
+	syncJob     *syncer.Job
+	startTime   time.Time
+	lastTime    time.Time
+	lastObjects uint64
+	lastBytes   uint64
+}
+
+func newProgressTracker(syncJob *syncer.Job) *progressTracker {
+	now := time.Now()
+	return &progressTracker{syncJob: syncJob, startTime: now, lastTime: now}
+}
+
+// snapshot return progress of the job at now, rates are measured since the previous snapshot.
+func (t *progressTracker) snapshot(now time.Time) progressSnapshot {
+	snap := progressSnapshot{Time: now, Duration: now.Sub(t.startTime).Seconds()}
+	snap.Objects, snap.Bytes = t.syncJob.Transferred()
+	snap.Listed, snap.Errors, snap.Pending = progressCounters(t.syncJob.Stats())
+	if dur := now.Sub(t.lastTime).Seconds(); dur > 0 {
+		snap.ObjRate = float64(snap.Objects-t.lastObjects) / dur
+		snap.ByteRate = float64(snap.Bytes-t.lastBytes) / dur
+	}
+	if snap.ObjRate > 0 {
+		eta := math.Round(float64(snap.Pending) / snap.ObjRate)
+		snap.ETA = &eta
+	}
+	t.lastTime, t.lastObjects, t.lastBytes = now, snap.Objects, snap.Bytes
+	return snap
+}
+
+// reportProgress print progress snapshot of the job to stderr every interval until ctx is done.
+// Unlike --sync-progress it prints plain lines, so it is suitable for log files.
+func reportProgress(ctx context.Context, name string, syncJob *syncer.Job, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	prefix := "Progress"
+	if name != "" {
+		prefix = fmt.Sprintf("Job %s progress", name)
+	}
+	tracker := newProgressTracker(syncJob)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			snap := tracker.snapshot(now)
+			eta := "unknown"
+			if snap.ETA != nil {
+				eta = (time.Duration(*snap.ETA) * time.Second).String()
+			}
+			_, _ = fmt.Fprintf(os.Stderr, "%s: Objects: %d; Listed: %d; Errors: %d; Bytes: %d; Rate: %.f obj/sec, %.f byte/s; ETA: %s; Duration: %s\n",
+				prefix, snap.Objects, snap.Listed, snap.Errors, snap.Bytes, snap.ObjRate, snap.ByteRate, eta, now.Sub(tracker.startTime).Round(time.Second).String())
+		}
+	}
+}
+
+// progressWriter write progress snapshots of all jobs as JSON lines to the same output.
+type progressWriter struct {
+	mu  sync.Mutex
+	out io.WriteCloser
+	enc *json.Encoder
+}
+
+// openProgressWriter open output of --progress-json: "-" is stdout, "fd:N" is already open file descriptor N,
+// other values are file paths, snapshots are appended to existing file.
+func openProgressWriter(dst string) (*progressWriter, error) {
+	var out io.WriteCloser
+	switch {
+	case dst == "-":
+		out = os.Stdout
+	case strings.HasPrefix(dst, "fd:"):
+		fd, err := strconv.ParseUint(strings.TrimPrefix(dst, "fd:"), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor in %s", dst)
+		}
+		out = os.NewFile(uintptr(fd), dst)
+	default:
+		f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		out = f
+	}
+	return &progressWriter{out: out, enc: json.NewEncoder(out)}, nil
+}
+
+// write write snapshot as single JSON line. Write errors are logged, since progress output is not critical for sync.
+func (w *progressWriter) write(snap progressSnapshot) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.enc.Encode(snap); err != nil {
+		log.Warnf("Failed to write progress snapshot: %s", err)
+	}
+}
+
+// Close close the output, it is safe to call on nil writer.
+func (w *progressWriter) Close() {
+	if w == nil || w.out == os.Stdout {
+		return
+	}
+	_ = w.out.Close()
+}
+
+// openLatencyLog open replication latency CSV log for appending, the header is written to new or empty file.
+func openLatencyLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err == nil && stat.Size() == 0 {
+		w := csv.NewWriter(f)
+		_ = w.Write(collection.LatencyLogColumns)
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// reportProgressJSON write progress snapshot of the job to w every interval until ctx is done,
+// then it writes the final snapshot and closes done.
+func reportProgressJSON(ctx context.Context, w *progressWriter, job argsParsed, syncJob *syncer.Job, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(job.ProgressJSONInterval)
+	defer ticker.Stop()
+
+	tracker := newProgressTracker(syncJob)
+	for {
+		select {
+		case <-ctx.Done():
+			snap := tracker.snapshot(time.Now())
+			snap.Job, snap.Run, snap.Final = job.JobName, job.scheduledRun, true
+			w.write(snap)
+			return
+		case now := <-ticker.C:
+			snap := tracker.snapshot(now)
+			snap.Job, snap.Run = job.JobName, job.scheduledRun
+			w.write(snap)
+		}
+	}
+}
+
+// progressCounters return the number of listed and failed objects and the number of listed objects waiting for processing.
+// Objects skipped by filters and being processed by steps are not pending.
+// Listing can be not finished yet, so pending objects are counted from objects listed so far.
+func progressCounters(steps []pipeline.StepInfo) (listed, errors, pending uint64) {
+	if len(steps) == 0 {
+		return 0, 0, 0
+	}
+	listed = steps[0].Stats.Output
+	var done uint64
+	for i, step := range steps {
+		errors += step.Stats.Error
+		if i == 0 {
+			continue
+		}
+		if i == len(steps)-1 {
+			done += step.Stats.Input
+		} else if step.Stats.Input > step.Stats.Output+step.Stats.Error {
+			done += step.Stats.Input - step.Stats.Output - step.Stats.Error
+		}
+	}
+	done += errors
+	if listed > done {
+		pending = listed - done
+	}
+	return listed, errors, pending
+}
+
+// printPrefixes print the number and size of synced objects per key prefix as a table, it is logged
+// line by line unless info messages are hidden.
+func printPrefixes(job argsParsed, jobLog logrus.FieldLogger, prefixes []collection.PrefixCount) {
+	if !job.summaryOnly() {
+		for _, c := range prefixes {
+			jobLog.Infof("Prefix %s: Objects: %d; Bytes: %d", prefixName(c.Prefix), c.Objects, c.Bytes)
+		}
+		return
+	}
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PREFIX\tOBJECTS\tBYTES")
+	for _, c := range prefixes {
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\n", prefixName(c.Prefix), c.Objects, c.Bytes)
+	}
+	_ = w.Flush()
+}
+
+// prefixName return printed name of key prefix, objects without directory are shown under "/".
+func prefixName(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
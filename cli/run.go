@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// runDescriptorVersion is the version of run descriptor file format.
+const runDescriptorVersion = 1
+
+// runExportInterval is the interval of run descriptor updates while the sync is running.
+const runExportInterval = time.Minute
+
+// runSkipFields contain args fields which are not stored in run descriptor options.
+// Credentials are given again on import, SOURCE, TARGET and listing marker are stored separately.
+var runSkipFields = map[string]bool{
+	"Source": true, "Target": true, "SourceKey": true, "SourceSecret": true, "TargetKey": true, "TargetSecret": true,
+	"ListStartAfter": true, "ExportRun": true, "ExportRunBloomSize": true, "ImportRun": true,
+	"RequireEmptyTarget": true,
+}
+
+// storageTypeNames are the names of storage types in run descriptor.
+var storageTypeNames = map[storage.Type]string{
+	storage.TypeS3:          "s3",
+	storage.TypeS3Versioned: "s3-versioned",
+	storage.TypeFS:          "fs",
+	storage.TypeNull:        "null",
+}
+
+// runLocation is the source or target of exported run.
+type runLocation struct {
+	// URL is SOURCE or TARGET arg.
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint,omitempty"`
+	Bucket   string `json:"bucket,omitempty"`
+	Path     string `json:"path"`
+}
+
+func newRunLocation(url string, conn connect) runLocation {
+	return runLocation{URL: url, Type: storageTypeNames[conn.Type], Endpoint: conn.Endpoint, Bucket: conn.Bucket, Path: conn.Path}
+}
+
+// matches check if the location is the same as parsed connection.
+func (l runLocation) matches(conn connect) bool {
+	return (l.Type == storageTypeNames[conn.Type]) && (l.Endpoint == conn.Endpoint) && (l.Bucket == conn.Bucket) &&
+		(strings.TrimSuffix(l.Path, "/") == strings.TrimSuffix(conn.Path, "/"))
+}
+
+func (l runLocation) String() string {
+	if l.Bucket != "" {
+		return fmt.Sprintf("%s bucket %s path %s", l.Type, l.Bucket, l.Path)
+	}
+	return fmt.Sprintf("%s path %s", l.Type, l.Path)
+}
+
+// runBloom is the bloom filter of completed source keys.
+type runBloom struct {
+	Hashes uint   `json:"hashes"`
+	Keys   uint64 `json:"keys"`
+	Bits   []byte `json:"bits"`
+}
+
+// runDescriptor is portable state of sync run, it is written with --export-run and read with --import-run.
+type runDescriptor struct {
+	Version int       `json:"version"`
+	Updated time.Time `json:"updated"`
+	// Finished is set if the run synced all objects, importing it syncs only objects changed since.
+	Finished bool        `json:"finished"`
+	Source   runLocation `json:"source"`
+	Target   runLocation `json:"target"`
+	// Options are config file values of options set for the run, including filters, without credentials.
+	Options map[string]interface{} `json:"options"`
+	// ListStartAfter is S3 source listing marker, listing is resumed after this key.
+	ListStartAfter string   `json:"list_start_after,omitempty"`
+	Completed      runBloom `json:"completed"`
+}
+
+// readRunDescriptor read run descriptor written with --export-run.
+func readRunDescriptor(path string) (*runDescriptor, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	desc := &runDescriptor{}
+	dec := json.NewDecoder(f)
+	dec.UseNumber()
+	if err := dec.Decode(desc); err != nil {
+		return nil, fmt.Errorf("failed to parse run descriptor %s: %s", path, err)
+	}
+	if desc.Version != runDescriptorVersion {
+		return nil, fmt.Errorf("unsupported run descriptor %s version: %d", path, desc.Version)
+	}
+	for key, val := range desc.Options {
+		desc.Options[key] = jsonConfigValue(val)
+	}
+	return desc, nil
+}
+
+// apply set values of raw args from run descriptor, including SOURCE, TARGET and listing marker.
+// It return descriptor keys of loaded fields.
+func (desc *runDescriptor) apply(rawCli *args) (map[string]string, error) {
+	values := make(map[string]interface{}, len(desc.Options)+3)
+	for key, val := range desc.Options {
+		values[key] = val
+	}
+	values[configKey("Source")] = desc.Source.URL
+	values[configKey("Target")] = desc.Target.URL
+	if desc.ListStartAfter != "" {
+		values[configKey("ListStartAfter")] = desc.ListStartAfter
+	}
+	return (&configFile{}).apply(values, rawCli, configSkipFields)
+}
+
+// check reject imported run of other SOURCE or TARGET.
+func (desc *runDescriptor) check(cli *argsParsed) error {
+	if !desc.Source.matches(cli.Source) {
+		return fmt.Errorf("SOURCE doesn't match source of imported run: %s", desc.Source)
+	}
+	if !desc.Target.matches(cli.Target) {
+		return fmt.Errorf("TARGET doesn't match target of imported run: %s", desc.Target)
+	}
+	return nil
+}
+
+// completedKeys return bloom filter of completed source keys of imported run or empty filter for exported run.
+// It return nil if the run is neither imported nor exported.
+func (cli *argsParsed) completedKeys() (*collection.BloomFilter, error) {
+	if cli.imported != nil {
+		return collection.LoadBloomFilter(cli.imported.Completed.Bits, cli.imported.Completed.Hashes, cli.imported.Completed.Keys)
+	}
+	if cli.ExportRun != "" {
+		return collection.NewBloomFilter(cli.ExportRunBloomSize), nil
+	}
+	return nil, nil
+}
+
+// runExporter writes run descriptor of running job to --export-run file.
+type runExporter struct {
+	path      string
+	desc      runDescriptor
+	completed *collection.BloomFilter
+}
+
+// newRunExporter return exporter of job run, completed should be updated with synced source keys.
+// Options loaded from flags, environment, config file and imported run are exported,
+// options of the machine running the sync, like log and control options, are not.
+func newRunExporter(job argsParsed, completed *collection.BloomFilter) *runExporter {
+	desc := runDescriptor{
+		Version:        runDescriptorVersion,
+		Source:         newRunLocation(job.args.Source, job.Source),
+		Target:         newRunLocation(job.args.Target, job.Target),
+		Options:        make(map[string]interface{}),
+		ListStartAfter: job.ListStartAfter,
+	}
+	for _, item := range dumpFields(job.args, nil) {
+		if runSkipFields[item.field] || jobSkipFields[item.field] || (job.optSource(item.field) == sourceDefault) {
+			continue
+		}
+		// Proxy URLs with password are credentials as well.
+		if raw := reflect.ValueOf(job.args).FieldByName(item.field).String(); strings.HasSuffix(item.field, "Proxy") && (redactURL(raw) != raw) {
+			continue
+		}
+		desc.Options[item.Key.(string)] = item.Value
+	}
+	return &runExporter{path: job.ExportRun, desc: desc, completed: completed}
+}
+
+// write write run descriptor with current completed keys.
+// The file is replaced atomically, so it is never left partially written.
+func (e *runExporter) write() error {
+	e.desc.Updated = time.Now().UTC()
+	e.desc.Completed.Bits, e.desc.Completed.Hashes, e.desc.Completed.Keys = e.completed.Snapshot()
+	data, err := json.Marshal(e.desc)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(e.path), filepath.Base(e.path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), e.path)
+}
+
+// run write run descriptor every runExportInterval until ctx is done.
+func (e *runExporter) run(ctx context.Context, jobLog logrus.FieldLogger, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(runExportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := e.write(); err != nil {
+				jobLog.Errorf("Failed to export run to %s: %s", e.path, err)
+			}
+		}
+	}
+}
+
+// finish write final run descriptor. Listing is resumed after lastKey, if it is not empty.
+func (e *runExporter) finish(finished bool, lastKey string) error {
+	e.desc.Finished = finished
+	if lastKey != "" {
+		e.desc.ListStartAfter = lastKey
+	}
+	return e.write()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/larrabee/s3sync/tracing"
+	"github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cronSchedule is parsed cron expression with standard 5 fields: minute, hour, day of month, month and day of week.
+// Every field is a bit set of matching values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar are set if the field is "*", day matches if both day fields match in this case,
+	// otherwise it matches if any of them matches, like in Vixie cron.
+	domStar, dowStar bool
+}
+
+// cronMacros are the supported shortcuts of cron expressions.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var cronMonths = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+var cronWeekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// cronSearchLimit limits search of the next run time, expressions like "0 0 30 2 *" never match.
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// parseCron parse cron expression like "0 2 * * *" or macro like "@daily".
+// Fields can contain lists, ranges and steps, like "1-5", "*/15", "0,30", months and days of week can be given
+// with names, like "jan" or "mon-fri". Day of week 7 is Sunday as well as 0.
+func parseCron(spec string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[strings.ToLower(strings.TrimSpace(spec))]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields (minute hour day month weekday), got %d", len(fields))
+	}
+
+	sched := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+	var err error
+	if sched.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("minute: %s", err)
+	}
+	if sched.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("hour: %s", err)
+	}
+	if sched.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("day of month: %s", err)
+	}
+	if sched.month, err = parseCronField(fields[3], 1, 12, cronMonths); err != nil {
+		return nil, fmt.Errorf("month: %s", err)
+	}
+	if sched.dow, err = parseCronField(fields[4], 0, 7, cronWeekdays); err != nil {
+		return nil, fmt.Errorf("day of week: %s", err)
+	}
+	if sched.dow&(1<<7) != 0 {
+		sched.dow |= 1
+	}
+	if _, ok := sched.next(time.Now()); !ok {
+		return nil, fmt.Errorf("expression never matches")
+	}
+	return sched, nil
+}
+
+// parseCronField parse comma separated list of values, ranges and steps to bit set.
+// names are the names of values starting from min.
+func parseCronField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); (err != nil) || (step <= 0) {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			rng = part[:i]
+		}
+
+		var from, to int
+		var err error
+		switch {
+		case rng == "*":
+			from, to = min, max
+		case strings.Contains(rng, "-"):
+			bounds := strings.SplitN(rng, "-", 2)
+			if from, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			if to, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			if from, err = parseCronValue(rng, min, max, names); err != nil {
+				return 0, err
+			}
+			to = from
+			if step > 1 {
+				to = max
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parse single number or name of cron field value.
+func parseCronValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if (err != nil) || (v < min) || (v > max) {
+		return 0, fmt.Errorf("invalid value %q, expected number from %d to %d", s, min, max)
+	}
+	return v, nil
+}
+
+// next return the first time matching the schedule after t.
+// It return false if there is no matching time in cronSearchLimit.
+func (s *cronSchedule) next(t time.Time) (time.Time, bool) {
+	limit := t.Add(cronSearchLimit)
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// runScheduled run every job on its schedule until stop is done and running runs are finished, or ctx is done,
+// and return exit status. Runs are interrupted only by ctx.
+// Status is 2 if a run was interrupted, failed runs don't change the status, since the process keeps running after them.
+func runScheduled(ctx, stop context.Context, jobs []argsParsed, limits sharedLimits, tracer *tracing.Tracer, ctl *controller) int {
+	slots := make(chan struct{}, cli.ParallelJobs)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	status := 0
+	for _, job := range jobs {
+		wg.Add(1)
+		go func(job argsParsed) {
+			defer wg.Done()
+			jobStatus := scheduleJob(ctx, stop, job, slots, limits, tracer, ctl)
+			mu.Lock()
+			if jobStatus > status {
+				status = jobStatus
+			}
+			mu.Unlock()
+		}(job)
+	}
+	wg.Wait()
+	return status
+}
+
+// scheduleJob trigger job runs on its schedule until stop is done, then it waits for the running run.
+// If the schedule has no next run time, the running run is finished and status is at least 1.
+// Trigger is skipped if the previous run is still in progress, with --schedule-queue one trigger is queued instead.
+// Queued trigger is dropped on stop.
+func scheduleJob(ctx, stop context.Context, job argsParsed, slots chan struct{}, limits sharedLimits, tracer *tracing.Tracer, ctl *controller) int {
+	var jobLog logrus.FieldLogger = log
+	if job.JobName != "" {
+		jobLog = log.WithField("job", job.JobName)
+	}
+
+	var triggers chan time.Time
+	if job.ScheduleQueue {
+		triggers = make(chan time.Time, 1)
+	} else {
+		triggers = make(chan time.Time)
+	}
+
+	// The runner is stopped on stop or when the schedule has no next run time.
+	runnerStop, stopRunner := context.WithCancel(stop)
+	defer stopRunner()
+	done := make(chan int)
+	go func() {
+		status := 0
+		for run := uint64(1); ; run++ {
+			select {
+			case <-runnerStop.Done():
+				done <- status
+				return
+			case scheduled := <-triggers:
+				select {
+				case <-runnerStop.Done():
+					done <- status
+					return
+				case slots <- struct{}{}:
+				}
+				if runnerStop.Err() != nil {
+					<-slots
+					done <- status
+					return
+				}
+				jobLog.Infof("Starting run %d scheduled at %s", run, scheduled.Format(time.RFC3339))
+				job.scheduledRun = run
+				res := runJob(ctx, job, limits, tracer, ctl)
+				<-slots
+				if res.status == 2 {
+					status = 2
+				}
+				if job.summaryOnly() {
+					_, _ = fmt.Fprintf(os.Stderr, "Run %d of job %s finished: status: %d; Objects: %d; Errors: %d; Duration: %s\n", run, job.JobName, res.status, res.synced, res.errors, res.duration.String())
+				} else {
+					jobLog.WithField("run", run).Infof("Run finished: status: %d; Objects: %d; Errors: %d; Duration: %s", res.status, res.synced, res.errors, res.duration.String())
+				}
+			}
+		}
+	}()
+
+	for {
+		next, ok := job.schedule.next(time.Now())
+		if !ok {
+			jobLog.Errorf("Schedule (%s) has no next run time", job.optName("Schedule"))
+			stopRunner()
+			if status := <-done; status > 1 {
+				return status
+			}
+			return 1
+		}
+		jobLog.Infof("Next run at %s", next.Format(time.RFC3339))
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-stop.Done():
+			timer.Stop()
+			return <-done
+		case <-timer.C:
+		}
+		select {
+		case triggers <- next:
+		default:
+			jobLog.Warnf("Previous run is still in progress, run scheduled at %s is skipped", next.Format(time.RFC3339))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// 2021-01-01 is Friday.
+	from := time.Date(2021, 1, 1, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		spec     string
+		from     time.Time
+		expected time.Time
+	}{
+		{"0 2 * * *", from, time.Date(2021, 1, 2, 2, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2021, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"5-10/2 * * * *", from, time.Date(2021, 1, 1, 10, 9, 0, 0, time.UTC)},
+		{"0,30 9-17 * * mon-fri", from, time.Date(2021, 1, 1, 10, 30, 0, 0, time.UTC)},
+		// Day of month or day of week matches if both are restricted.
+		{"0 0 13 * fri", from, time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * fri", time.Date(2021, 1, 8, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 13, 0, 0, 0, 0, time.UTC)},
+		// Both must match if one of them is "*".
+		{"0 0 * * mon", from, time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * *", from, time.Date(2021, 1, 13, 0, 0, 0, 0, time.UTC)},
+		// Names and 7 as Sunday.
+		{"0 0 1 jan,JUL *", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", from, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Macros.
+		{"@hourly", from, time.Date(2021, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", from, time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", from, time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", from, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		sched, err := parseCron(test.spec)
+		if err != nil {
+			t.Errorf("%q: %s", test.spec, err)
+			continue
+		}
+		next, ok := sched.next(test.from)
+		if !ok || !next.Equal(test.expected) {
+			t.Errorf("%q after %s: expected %s, got %s (%v)", test.spec, test.from, test.expected, next, ok)
+		}
+	}
+}
+
+func TestCronParseError(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@never",
+		// Never matches.
+		"0 0 30 2 *",
+		"0 0 31 apr,jun,sep,nov *",
+	} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
+
+func TestScheduleJobNoNextRun(t *testing.T) {
+	// Empty schedule never matches, the job returns without waiting for stop.
+	job := argsParsed{schedule: &cronSchedule{}}
+	done := make(chan int)
+	go func() {
+		done <- scheduleJob(context.Background(), context.Background(), job, make(chan struct{}, 1), sharedLimits{}, nil, nil)
+	}()
+	select {
+	case status := <-done:
+		if status != 1 {
+			t.Errorf("expected status 1, got %d", status)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job without next run time is not finished")
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/larrabee/s3sync/syncer"
+	"github.com/larrabee/s3sync/tracing"
+	"github.com/sirupsen/logrus"
+	stdlog "log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// API server job states.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// apiServer runs sync jobs submitted with HTTP API.
+// Jobs are kept in memory until the server exits, at most --parallel-jobs jobs are running at the same time.
+type apiServer struct {
+	ctx    context.Context
+	base   argsParsed
+	limits sharedLimits
+	tracer *tracing.Tracer
+	ctl    *controller
+	slots  chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	seq  uint64
+	jobs map[string]*apiJob
+}
+
+// apiJob is a job submitted to API server.
+type apiJob struct {
+	ID       string        `json:"id"`
+	Name     string        `json:"name"`
+	Status   string        `json:"status"`
+	Created  time.Time     `json:"created"`
+	Started  *time.Time    `json:"started,omitempty"`
+	Finished *time.Time    `json:"finished,omitempty"`
+	Result   *apiJobResult `json:"result,omitempty"`
+	// Progress is the progress of running job, rates are measured since the previous request of the job status.
+	Progress *progressSnapshot `json:"progress,omitempty"`
+	cancel   context.CancelFunc
+	tracker  *progressTracker
+}
+
+// apiJobResult is the summary of finished job, status is the same as s3sync exit code.
+type apiJobResult struct {
+	Status   int    `json:"status"`
+	Objects  uint64 `json:"objects"`
+	Errors   uint64 `json:"errors"`
+	Duration string `json:"duration"`
+}
+
+// apiError is the body of error response.
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// serve run API server on cli.Serve address until ctx is done.
+// On shutdown running and queued jobs are cancelled, it return after all jobs are finished.
+func serve(ctx context.Context, cli argsParsed, limits sharedLimits, tracer *tracing.Tracer, ctl *controller) error {
+	srv := &apiServer{
+		ctx:    ctx,
+		base:   cli,
+		limits: limits,
+		tracer: tracer,
+		ctl:    ctl,
+		slots:  make(chan struct{}, cli.ParallelJobs),
+		jobs:   make(map[string]*apiJob),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jobs", srv.handleJobs)
+	mux.HandleFunc("/jobs/", srv.handleJob)
+	// Handler panics and connection errors are logged with redaction too.
+	errLog := log.WriterLevel(logrus.ErrorLevel)
+	defer errLog.Close()
+	httpSrv := &http.Server{Addr: cli.Serve, Handler: srv.auth(mux), ErrorLog: stdlog.New(errLog, "", 0)}
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- httpSrv.ListenAndServe()
+	}()
+	log.Infof("API server is listening on %s", cli.Serve)
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err = httpSrv.Shutdown(shutdownCtx)
+		cancel()
+	}
+	log.Infof("API server is stopped, waiting for jobs cancellation")
+	srv.wg.Wait()
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// auth check bearer token of requests if --serve-token is given.
+func (srv *apiServer) auth(next http.Handler) http.Handler {
+	if srv.base.ServeToken == "" {
+		return next
+	}
+	expected := []byte("Bearer " + srv.base.ServeToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleJobs serve job list (GET /jobs) and job submission (POST /jobs).
+func (srv *apiServer) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		srv.mu.Lock()
+		jobs := make([]apiJob, 0, len(srv.jobs))
+		now := time.Now()
+		for _, job := range srv.jobs {
+			jobs = append(jobs, job.snapshot(now))
+		}
+		srv.mu.Unlock()
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+		writeJSON(w, http.StatusOK, jobs)
+	case http.MethodPost:
+		job, err := srv.submit(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusCreated, job)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+	}
+}
+
+// handleJob serve job status (GET /jobs/<id>) and job cancellation (DELETE /jobs/<id>).
+func (srv *apiServer) handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	srv.mu.Lock()
+	job, ok := srv.jobs[id]
+	var snapshot apiJob
+	if ok {
+		if r.Method == http.MethodDelete {
+			job.cancel()
+		}
+		snapshot = job.snapshot(time.Now())
+	}
+	srv.mu.Unlock()
+	if !ok {
+		writeJSON(w, http.StatusNotFound, apiError{Error: fmt.Sprintf("job %q is not found", id)})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, snapshot)
+	case http.MethodDelete:
+		writeJSON(w, http.StatusAccepted, snapshot)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, apiError{Error: "method not allowed"})
+	}
+}
+
+// submit parse job spec from request body and start the job.
+func (srv *apiServer) submit(r *http.Request) (apiJob, error) {
+	var values map[string]interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&values); err != nil {
+		return apiJob{}, fmt.Errorf("invalid job spec: %s", err)
+	}
+	name, _ := values["name"].(string)
+	delete(values, "name")
+	for key, val := range values {
+		values[key] = jsonConfigValue(val)
+	}
+
+	srv.mu.Lock()
+	srv.seq++
+	id := strconv.FormatUint(srv.seq, 10)
+	srv.mu.Unlock()
+	if name == "" {
+		name = "job-" + id
+	}
+
+	spec, err := srv.base.parseJobSpec(name, values)
+	if err != nil {
+		return apiJob{}, err
+	}
+
+	ctx, cancel := context.WithCancel(srv.ctx)
+	job := &apiJob{ID: id, Name: name, Status: jobQueued, Created: time.Now(), cancel: cancel}
+	srv.mu.Lock()
+	srv.jobs[id] = job
+	snapshot := *job
+	srv.mu.Unlock()
+
+	srv.wg.Add(1)
+	go srv.run(ctx, job, spec)
+	return snapshot, nil
+}
+
+// run wait for free slot and run the job.
+func (srv *apiServer) run(ctx context.Context, job *apiJob, spec argsParsed) {
+	defer srv.wg.Done()
+	defer job.cancel()
+
+	var res jobResult
+	select {
+	case <-ctx.Done():
+		res.status = 2
+	case srv.slots <- struct{}{}:
+		now := time.Now()
+		srv.mu.Lock()
+		job.Status = jobRunning
+		job.Started = &now
+		srv.mu.Unlock()
+		spec.onStart = func(syncJob *syncer.Job) {
+			srv.mu.Lock()
+			job.tracker = newProgressTracker(syncJob)
+			srv.mu.Unlock()
+		}
+		res = runJob(ctx, spec, srv.limits, srv.tracer, srv.ctl)
+		<-srv.slots
+	}
+
+	now := time.Now()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	job.Finished = &now
+	job.tracker = nil
+	job.Result = &apiJobResult{Status: res.status, Objects: res.synced, Errors: res.errors, Duration: res.duration.String()}
+	switch res.status {
+	case 0:
+		job.Status = jobDone
+	case 2:
+		job.Status = jobCancelled
+	default:
+		job.Status = jobFailed
+	}
+}
+
+// snapshot return copy of the job with progress of running job, it must be called with srv.mu locked.
+func (job *apiJob) snapshot(now time.Time) apiJob {
+	snap := *job
+	if job.tracker != nil {
+		progress := job.tracker.snapshot(now)
+		snap.Progress = &progress
+	}
+	return snap
+}
+
+// jobSpecError raises from specFailer when job spec is invalid.
+type jobSpecError struct {
+	msg string
+}
+
+// specFailer reports invalid job spec options with panic, which is recovered by parseJobSpec.
+// Unlike arg.Parser it doesn't exit the process.
+type specFailer struct{}
+
+func (specFailer) Fail(msg string) {
+	panic(jobSpecError{msg: msg})
+}
+
+// apiSkipFields contain args fields which can't be set in API job specs: hook commands and local files written or
+// read with s3sync privileges besides SOURCE and TARGET, so API clients can't run commands on the server host.
+// They are inherited from the server options like other options not given in the spec.
+var apiSkipFields = map[string]bool{
+	"HookPreObject": true, "HookPostObject": true, "HookPostRun": true,
+	"LatencyLogFile": true, "SpillDir": true, "SourceAWSConfigFile": true, "TargetAWSConfigFile": true,
+}
+
+// isLoopbackAddr check if listen address is bound to loopback interface only, like localhost:8081 or 127.0.0.1:8081.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return (ip != nil) && ip.IsLoopback()
+}
+
+// parseJobSpec return job with options of cli overridden by given job spec values.
+// Job spec has the same format as config file job, credentials from config file can be referenced.
+func (cli argsParsed) parseJobSpec(name string, values map[string]interface{}) (job argsParsed, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			specErr, ok := r.(jobSpecError)
+			if !ok {
+				panic(r)
+			}
+			err = errors.New(specErr.msg)
+		}
+	}()
+
+	for key := range values {
+		for field := range apiSkipFields {
+			if normalizeKey(key) == normalizeKey(field) {
+				return job, fmt.Errorf("%s can't be set in API job spec", key)
+			}
+		}
+	}
+
+	job = cli
+	job.Jobs = nil
+	job.JobName = name
+	if job.jobKeys, err = cli.config.apply(values, &job.args, jobSkipFields); err != nil {
+		return job, err
+	}
+	if job.args.Source == "" || (job.args.Target == "" && !job.Benchmark) {
+		return job, fmt.Errorf("source and target are required")
+	}
+	if job.Confirm {
+		return job, fmt.Errorf("confirmation can't be used with API server")
+	}
+	job.resolveCron(specFailer{}, job.jobKeys)
+	if job.Schedule != "" {
+		return job, fmt.Errorf("schedule can't be used with API server")
+	}
+	err = job.parseJob(specFailer{})
+	return job, err
+}
+
+// jsonConfigValue convert decoded JSON value to the type used for the same value in YAML config file.
+func jsonConfigValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = jsonConfigValue(item)
+		}
+		return res
+	}
+	return val
+}
+
+// writeJSON write JSON response with given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		code = http.StatusInternalServerError
+		buf.Reset()
+		_, _ = fmt.Fprintf(&buf, "{\"error\": %q}\n", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -14,4 +14,5 @@ require (
 	github.com/sirupsen/logrus v1.4.2
 	golang.org/x/net v0.0.0-20190724013045-ca1201d0de80 // indirect
 	golang.org/x/tools v0.0.0-20190802003818-e9bb7d36c060 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
@@ -0,0 +1,86 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+)
+
+// WorkersTuner adjusts limits of WorkerGates to maximize objects throughput.
+//
+// It uses hill-climbing: every interval the throughput of the first gate is compared with the previous interval.
+// If throughput grows, the workers count keeps changing in the same direction, otherwise the direction is reversed.
+// Added workers are also reverted if the average transfer time grows as fast as throughput or faster:
+// the network or storage is saturated, and extra workers only wait in the same queue.
+type WorkersTuner struct {
+	Gates    []*WorkerGate
+	Min      uint
+	Max      uint
+	Interval time.Duration
+}
+
+// Run start tuning and blocks until context cancellation.
+// On exit all gates limits are set to Max to release waiting workers.
+func (tuner *WorkersTuner) Run(ctx context.Context) {
+	if len(tuner.Gates) == 0 {
+		return
+	}
+	defer tuner.setLimit(tuner.Max)
+
+	ticker := time.NewTicker(tuner.Interval)
+	defer ticker.Stop()
+
+	lastDone, lastBusy := tuner.Gates[0].Stats()
+	lastThroughput := 0.0
+	var lastAvgTime time.Duration
+	increase := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			done, busy := tuner.Gates[0].Stats()
+			if done == lastDone {
+				// Nothing processed, for example listing in progress.
+				continue
+			}
+			throughput := float64(done-lastDone) / tuner.Interval.Seconds()
+			avgTime := (busy - lastBusy) / time.Duration(done-lastDone)
+			lastDone, lastBusy = done, busy
+
+			if throughput < lastThroughput {
+				increase = !increase
+			} else if increase && (lastThroughput > 0) && (lastAvgTime > 0) &&
+				(float64(avgTime)/float64(lastAvgTime) >= throughput/lastThroughput) {
+				increase = false
+			}
+			lastThroughput, lastAvgTime = throughput, avgTime
+
+			limit := tuner.Gates[0].Limit()
+			step := limit / 4
+			if step == 0 {
+				step = 1
+			}
+			if increase {
+				limit += step
+			} else if limit > step {
+				limit -= step
+			} else {
+				limit = tuner.Min
+			}
+			if limit > tuner.Max {
+				limit = tuner.Max
+			}
+			if limit < tuner.Min {
+				limit = tuner.Min
+			}
+			tuner.setLimit(limit)
+			Log.Debugf("Workers tuner: throughput: %.f obj/sec, avg transfer time: %s, workers: %d", throughput, avgTime, limit)
+		}
+	}
+}
+
+func (tuner *WorkersTuner) setLimit(limit uint) {
+	for _, gate := range tuner.Gates {
+		gate.SetLimit(limit)
+	}
+}
@@ -0,0 +1,98 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"sort"
+)
+
+// SyncObjectACL read objects from input and copy ACL grants of source objects to target objects with the same
+// content, content is not transferred. Objects which are missing or modified in the target are skipped,
+// objects with equal grants are skipped too, so only objects with updated ACL are sent to next pipeline steps.
+// Owner of target objects is kept. Source and Target storages should implement storage.ACLCopier.
+//
+// This step read configuration from Step.Config and assert it type to string type, it is ETag compat strategy
+// used for objects with not comparable ETags, like in FilterObjectsModified.
+var SyncObjectACL pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	src, srcOk := group.Source.(storage.ACLCopier)
+	dst, dstOk := group.Target.(storage.ACLCopier)
+	if !srcOk || !dstOk {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			destObj := &storage.Object{
+				Key:       obj.Key,
+				VersionId: obj.VersionId,
+			}
+			ctx, cancel := group.ObjectContext()
+			err := group.Target.GetObjectMeta(ctx, destObj)
+			if (err != nil) || !objectsEqual(group, cfg, obj, destObj) {
+				cancel()
+				pipeline.Log.Debugf("Object %s is not synced to target, ACL is not copied", *obj.Key)
+				continue
+			}
+
+			srcACL, err := src.GetObjectGrants(ctx, obj)
+			if err != nil {
+				cancel()
+				errChan <- err
+				continue
+			}
+			dstACL, err := dst.GetObjectGrants(ctx, destObj)
+			if err != nil {
+				cancel()
+				errChan <- err
+				continue
+			}
+			if grantsEqual(srcACL.Grants, dstACL.Grants) {
+				cancel()
+				pipeline.Log.Debugf("Object %s ACL is not modified", *obj.Key)
+				continue
+			}
+			err = dst.PutObjectGrants(ctx, destObj, &storage.ObjectACL{Owner: dstACL.Owner, Grants: srcACL.Grants})
+			cancel()
+			if err != nil {
+				errChan <- err
+			} else {
+				output <- obj
+			}
+		}
+	}
+}
+
+// grantsEqual check if two lists have the same grants in any order, display names are not compared.
+func grantsEqual(a, b []storage.ObjectGrant) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(g storage.ObjectGrant) string {
+		return g.Type + "|" + g.ID + "|" + g.URI + "|" + g.Email + "|" + g.Permission
+	}
+	keys := make([]string, 0, len(a))
+	for _, g := range a {
+		keys = append(keys, key(g))
+	}
+	sort.Strings(keys)
+	other := make([]string, 0, len(b))
+	for _, g := range b {
+		other = append(other, key(g))
+	}
+	sort.Strings(other)
+	for i := range keys {
+		if keys[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
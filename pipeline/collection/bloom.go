@@ -0,0 +1,119 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"math"
+	"sync"
+)
+
+// DefaultBloomHashes is the number of hash functions of NewBloomFilter.
+// It gives the lowest false-positive rate with about 10 bits per key.
+const DefaultBloomHashes = 7
+
+// BloomFilter is a set of keys with fixed size. Test never misses added keys,
+// but it can report not added key as added with probability returned by FalsePositiveRate,
+// the probability grows with the number of added keys.
+type BloomFilter struct {
+	mu     sync.RWMutex
+	bits   []byte
+	hashes uint
+	keys   uint64
+}
+
+// NewBloomFilter return empty BloomFilter of given size in bytes.
+func NewBloomFilter(size int) *BloomFilter {
+	return &BloomFilter{bits: make([]byte, size), hashes: DefaultBloomHashes}
+}
+
+// LoadBloomFilter return BloomFilter with bits, hash count and key count saved from other filter with Snapshot.
+func LoadBloomFilter(bits []byte, hashes uint, keys uint64) (*BloomFilter, error) {
+	if (len(bits) == 0) || (hashes == 0) {
+		return nil, fmt.Errorf("invalid bloom filter: %d bytes, %d hashes", len(bits), hashes)
+	}
+	return &BloomFilter{bits: bits, hashes: hashes, keys: keys}, nil
+}
+
+// bloomHash return two hashes of key, bit positions are computed from them with double hashing.
+func bloomHash(key string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16]) | 1
+}
+
+// Add add key to the filter.
+func (f *BloomFilter) Add(key string) {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(f.bits)) * 8
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	added := false
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		pos := (h1 + i*h2) % m
+		if f.bits[pos/8]&(1<<(pos%8)) == 0 {
+			f.bits[pos/8] |= 1 << (pos % 8)
+			added = true
+		}
+	}
+	if added {
+		f.keys++
+	}
+}
+
+// Test check if key was added to the filter.
+func (f *BloomFilter) Test(key string) bool {
+	h1, h2 := bloomHash(key)
+	m := uint64(len(f.bits)) * 8
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		pos := (h1 + i*h2) % m
+		if f.bits[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot return copy of filter bits, hash count and the number of added keys.
+func (f *BloomFilter) Snapshot() ([]byte, uint, uint64) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	bits := make([]byte, len(f.bits))
+	copy(bits, f.bits)
+	return bits, f.hashes, f.keys
+}
+
+// FalsePositiveRate return estimated probability of Test to report not added key as added.
+func (f *BloomFilter) FalsePositiveRate() float64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	k := float64(f.hashes)
+	return math.Pow(1-math.Exp(-k*float64(f.keys)/float64(len(f.bits)*8)), k)
+}
+
+// FilterObjectsCompleted accepts an input object and checks if it matches the filter.
+// This filter skips objects with keys added to the bloom filter, like objects synced by previous run.
+// Rarely objects not added to the filter are skipped as well, see BloomFilter.FalsePositiveRate.
+//
+// This filter read configuration from Step.Config and assert it type to *BloomFilter type.
+var FilterObjectsCompleted pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*BloomFilter)
+	if !ok || cfg == nil {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if !cfg.Test(*obj.Key) {
+				output <- obj
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package collection
+
+import (
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Policies of ResolveKeyConflicts step for keys differing only by case.
+const (
+	// RenameConflictError fails the object with KeyConflictError.
+	RenameConflictError = "error"
+	// RenameConflictSkip skips the object, the object listed first is synced.
+	RenameConflictSkip = "skip"
+	// RenameConflictSuffix appends "~N" suffix before the extension of the key, like "dir/Photo~1.jpg".
+	// The key is replaced with ApplyKeyRenames step, since source objects are read with original keys.
+	RenameConflictSuffix = "suffix"
+)
+
+// RenameConflictConfig is the configuration of ResolveKeyConflicts step.
+type RenameConflictConfig struct {
+	// Policy is one of RenameConflict* constants.
+	Policy string
+	// Renames records objects renamed with RenameConflictSuffix policy, it is required by this policy.
+	Renames *RenameLog
+	// SpillDir keeps seen keys in temporary file in given directory instead of memory.
+	SpillDir string
+	// SpillCacheSize limits memory used by the index of spilled keys in bytes, DefaultSpillCacheSize if zero.
+	SpillCacheSize int
+}
+
+// Rename is the object renamed by ResolveKeyConflicts step.
+type Rename struct {
+	// Key is the original object key.
+	Key string
+	// TargetKey is the new object key.
+	TargetKey string
+	// ConflictKey is the key of other object, which differs from Key only by case.
+	ConflictKey string
+}
+
+// RenameLog collects renamed objects.
+type RenameLog struct {
+	mu      sync.Mutex
+	renames []Rename
+	targets map[string]string
+}
+
+// NewRenameLog return new empty RenameLog.
+func NewRenameLog() *RenameLog {
+	return &RenameLog{targets: make(map[string]string)}
+}
+
+// List return renamed objects in order of renaming.
+func (l *RenameLog) List() []Rename {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	res := make([]Rename, len(l.renames))
+	copy(res, l.renames)
+	return res
+}
+
+// Target return new key of renamed object with given original key.
+func (l *RenameLog) Target(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	target, ok := l.targets[key]
+	return target, ok
+}
+
+func (l *RenameLog) add(r Rename) {
+	l.mu.Lock()
+	l.renames = append(l.renames, r)
+	l.targets[r.Key] = r.TargetKey
+	l.mu.Unlock()
+}
+
+// KeyConflictError raises when object key differs from key of other object only by case
+// and RenameConflictError policy is used.
+type KeyConflictError struct {
+	Key      string
+	OtherKey string
+}
+
+func (e *KeyConflictError) Error() string {
+	return fmt.Sprintf("object: %s conflicts with object %s on case-insensitive filesystem", e.Key, e.OtherKey)
+}
+
+// ResolveKeyConflicts read objects from input, detect keys differing only by case from keys of previous objects
+// and handle them with RenameConflictConfig.Policy. Such keys are written to the same file on case-insensitive
+// filesystems (macOS, Windows), so one object silently overwrites the other.
+// Keys are compared with lower case, all objects are sent to next pipeline steps with unchanged keys,
+// new keys of renamed objects are recorded to RenameConflictConfig.Renames and applied with ApplyKeyRenames step.
+// The step should be placed before filters to see all listed keys.
+//
+// This step read configuration from Step.Config and assert it type to RenameConflictConfig type.
+var ResolveKeyConflicts pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(RenameConflictConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	switch cfg.Policy {
+	case RenameConflictError, RenameConflictSkip:
+	case RenameConflictSuffix:
+		if cfg.Renames == nil {
+			errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+			return
+		}
+	default:
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	seen, err := newKeyStore(cfg.SpillDir, cfg.SpillCacheSize)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	defer seen.Close()
+
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			other, ok, err := seen.Get(strings.ToLower(*obj.Key))
+			if err != nil {
+				errChan <- err
+				continue
+			}
+			if !ok {
+				if err := seen.Put(strings.ToLower(*obj.Key), *obj.Key); err != nil {
+					errChan <- err
+					continue
+				}
+				output <- obj
+				continue
+			}
+
+			switch cfg.Policy {
+			case RenameConflictError:
+				errChan <- &KeyConflictError{Key: *obj.Key, OtherKey: other}
+				continue
+			case RenameConflictSkip:
+				pipeline.Log.Warnf("Object %s conflicts with object %s on case-insensitive filesystem, skipping", *obj.Key, other)
+				continue
+			}
+
+			key, err := conflictFreeKey(seen, *obj.Key, strings.ToLower)
+			if err != nil {
+				errChan <- err
+				continue
+			}
+			if err := seen.Put(strings.ToLower(key), key); err != nil {
+				errChan <- err
+				continue
+			}
+			pipeline.Log.Warnf("Object %s conflicts with object %s on case-insensitive filesystem, renamed to %s", *obj.Key, other, key)
+			cfg.Renames.add(Rename{Key: *obj.Key, TargetKey: key, ConflictKey: other})
+			output <- obj
+		}
+	}
+}
+
+// ApplyKeyRenames read objects from input, replace keys of objects renamed by ResolveKeyConflicts step
+// and send object to next pipeline steps. It should be placed after object content is loaded.
+//
+// This step read configuration from Step.Config and assert it type to *RenameLog type.
+var ApplyKeyRenames pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	renames, ok := info.Config.(*RenameLog)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if key, ok := renames.Target(*obj.Key); ok {
+				obj.Key = &key
+			}
+			output <- obj
+		}
+	}
+}
+
+// conflictFreeKey return key with the first "~N" suffix, which is not used yet.
+// The suffix is added before the extension of the last key element, candidates are looked up with normalized keys.
+func conflictFreeKey(seen keyStore, key string, normalize func(string) string) (string, error) {
+	ext := path.Ext(key)
+	if ext == path.Base(key) {
+		ext = ""
+	}
+	base := strings.TrimSuffix(key, ext)
+	for i := 1; ; i++ {
+		candidate := base + "~" + strconv.Itoa(i) + ext
+		_, ok, err := seen.Get(normalize(candidate))
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return candidate, nil
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"sync"
+	"sync/atomic"
+)
+
+// contentDedupMaxSize is the maximal size of object written with server-side copy, S3 limits single CopyObject
+// request to 5 GiB. Larger objects are always uploaded.
+const contentDedupMaxSize int64 = 5 << 30
+
+// ContentIndex maps SHA-256 of object content to the target key of the first object written with this content.
+type ContentIndex struct {
+	copies     uint64
+	bytes      uint64
+	mu         sync.Mutex
+	spillDir   string
+	spillCache int
+	keys       keyStore
+}
+
+// NewContentIndex return new empty ContentIndex. If spillDir is not empty, the index is kept in temporary file
+// in given directory instead of memory, the file is created on first use. spillCacheSize limits memory used by
+// the index of the file in bytes, DefaultSpillCacheSize if zero.
+func NewContentIndex(spillDir string, spillCacheSize int) *ContentIndex {
+	return &ContentIndex{spillDir: spillDir, spillCache: spillCacheSize}
+}
+
+// lookup return target key of object with given content hash, if it was written before.
+func (idx *ContentIndex) lookup(hash string) (string, bool, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.keys == nil {
+		return "", false, nil
+	}
+	return idx.keys.Get(hash)
+}
+
+// add store target key of written object with given content hash, unless the hash is already known.
+func (idx *ContentIndex) add(hash, key string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.keys == nil {
+		keys, err := newKeyStore(idx.spillDir, idx.spillCache)
+		if err != nil {
+			return err
+		}
+		idx.keys = keys
+	}
+	if _, ok, err := idx.keys.Get(hash); err != nil || ok {
+		return err
+	}
+	return idx.keys.Put(hash, key)
+}
+
+// Copies return the number of objects written with server-side copy and their total size.
+func (idx *ContentIndex) Copies() (objects, bytes uint64) {
+	return atomic.LoadUint64(&idx.copies), atomic.LoadUint64(&idx.bytes)
+}
+
+// Close release the index storage, counters stay available.
+func (idx *ContentIndex) Close() error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if idx.keys == nil {
+		return nil
+	}
+	err := idx.keys.Close()
+	idx.keys = nil
+	return err
+}
+
+// ContentDedupConfig is the configuration of UploadObjectDedup step.
+type ContentDedupConfig struct {
+	Index *ContentIndex
+	// Gate limits the number of concurrent uploads if not nil.
+	Gate *pipeline.WorkerGate
+}
+
+// UploadObjectDedup works like UploadObjectData, but objects with content already written to Target storage by
+// this step are written with server-side copy of the first written object instead of content upload, the key of
+// the first object is set to Object.CopyOf. Empty objects and objects larger than 5 GiB are always uploaded.
+// Target storage should implement storage.CopyPutter.
+//
+// This step read configuration from Step.Config and assert it type to ContentDedupConfig type.
+var UploadObjectDedup pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(ContentDedupConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	copier, ok := group.Target.(storage.CopyPutter)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			var content []byte
+			if obj.Content != nil {
+				content = *obj.Content
+			}
+			var hash, srcKey string
+			var found bool
+			if (len(content) > 0) && (int64(len(content)) <= contentDedupMaxSize) {
+				sum := sha256.Sum256(content)
+				hash = hex.EncodeToString(sum[:])
+				var err error
+				if srcKey, found, err = cfg.Index.lookup(hash); err != nil {
+					errChan <- err
+					continue
+				}
+				found = found && (srcKey != *obj.Key)
+			}
+
+			start, ok := cfg.Gate.Acquire()
+			if !ok {
+				return
+			}
+			ctx, cancel := group.ObjectContext()
+			var err error
+			if found {
+				err = copier.PutObjectCopy(ctx, obj, srcKey)
+			} else {
+				err = group.Target.PutObject(ctx, obj)
+			}
+			cancel()
+			cfg.Gate.Release(start)
+			if err != nil {
+				traceObject(group, obj, err)
+				errChan <- err
+				continue
+			}
+
+			if found {
+				atomic.AddUint64(&cfg.Index.copies, 1)
+				atomic.AddUint64(&cfg.Index.bytes, uint64(len(content)))
+				obj.CopyOf = &srcKey
+				pipeline.Log.Debugf("Object %s has the same content as %s, copied on target", *obj.Key, srcKey)
+			} else if hash != "" {
+				if err := cfg.Index.add(hash, *obj.Key); err != nil {
+					errChan <- err
+					continue
+				}
+			}
+			output <- obj
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strings"
+)
+
+// OriginalKeyMeta is the metadata key of the object key before ContentHashKey step.
+// S3 stores it as "x-amz-meta-original-key" header.
+const OriginalKeyMeta = "Original-Key"
+
+// ContentHashKey read objects from input, add hex SHA-256 of the object content to its key
+// and send object to next pipeline steps. The hash is added before the extension of the last key element,
+// like "dir/cat-<hash>.jpg", or appended to keys without extension, like "dir/README-<hash>".
+// The original key is kept in OriginalKeyMeta metadata.
+// Object content should be loaded by previous steps, the step should be placed after other key transformations.
+var ContentHashKey pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			var content []byte
+			if obj.Content != nil {
+				content = *obj.Content
+			}
+			sum := sha256.Sum256(content)
+			original := *obj.Key
+			key := contentHashKey(original, hex.EncodeToString(sum[:]))
+			if obj.Metadata == nil {
+				obj.Metadata = make(map[string]*string)
+			}
+			obj.Metadata[OriginalKeyMeta] = &original
+			pipeline.Log.Debugf("Object %s target key: %s", original, key)
+			obj.Key = &key
+			output <- obj
+		}
+	}
+}
+
+// contentHashKey return key with "-<hash>" added before the extension of the last key element.
+func contentHashKey(key, hash string) string {
+	ext := path.Ext(key)
+	if ext == path.Base(key) {
+		ext = ""
+	}
+	return strings.TrimSuffix(key, ext) + "-" + hash + ext
+}
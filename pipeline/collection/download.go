@@ -4,6 +4,7 @@ package collection
 import (
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/storage"
+	"time"
 )
 
 // LoadObjectMeta accepts an input object and downloads its metadata.
@@ -13,8 +14,11 @@ var LoadObjectMeta pipeline.StepFn = func(group *pipeline.Group, stepNum int, in
 		case <-group.Ctx.Done():
 			return
 		default:
-			err := group.Source.GetObjectMeta(obj)
+			ctx, cancel := group.ObjectContext()
+			err := group.Source.GetObjectMeta(ctx, obj)
+			cancel()
 			if err != nil {
+				traceObject(group, obj, err)
 				errChan <- err
 			} else {
 				output <- obj
@@ -24,14 +28,32 @@ var LoadObjectMeta pipeline.StepFn = func(group *pipeline.Group, stepNum int, in
 }
 
 // LoadObjectData accepts an input object and downloads its content and metadata.
+//
+// This step read optional configuration from Step.Config and assert it type to *pipeline.WorkerGate type.
+// If gate is configured, it limits the number of concurrent downloads.
 var LoadObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	gate, ok := info.Config.(*pipeline.WorkerGate)
+	if !ok && info.Config != nil {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
 	for obj := range input {
 		select {
 		case <-group.Ctx.Done():
 			return
 		default:
-			err := group.Source.GetObjectContent(obj)
+			start, ok := gate.Acquire()
+			if !ok {
+				return
+			}
+			obj.Timings.QueueWait = time.Since(obj.Timings.Listed) - obj.Timings.Meta
+			ctx, cancel := group.ObjectContext()
+			err := group.Source.GetObjectContent(ctx, obj)
+			cancel()
+			gate.Release(start)
 			if err != nil {
+				traceObject(group, obj, err)
 				errChan <- err
 			} else {
 				output <- obj
@@ -39,3 +61,56 @@ var LoadObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, in
 		}
 	}
 }
+
+// SelectConfig is a configuration of SelectObjectData step.
+// Gate is optional, if configured it limits the number of concurrent requests.
+type SelectConfig struct {
+	Query storage.SelectQuery
+	Gate  *pipeline.WorkerGate
+}
+
+// SelectObjectData accepts an input object, loads its metadata and filters its content on the storage side.
+// Objects without matched content are skipped.
+// Source storage should implement storage.Selecter interface.
+//
+// This step read configuration from Step.Config and assert it type to SelectConfig type.
+var SelectObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(SelectConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	selecter, ok := group.Source.(storage.Selecter)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			start, ok := cfg.Gate.Acquire()
+			if !ok {
+				return
+			}
+			obj.Timings.QueueWait = time.Since(obj.Timings.Listed) - obj.Timings.Meta
+			ctx, cancel := group.ObjectContext()
+			err := group.Source.GetObjectMeta(ctx, obj)
+			if err == nil {
+				err = selecter.SelectObjectContent(ctx, obj, cfg.Query)
+			}
+			cancel()
+			cfg.Gate.Release(start)
+			if err != nil {
+				traceObject(group, obj, err)
+				errChan <- err
+			} else if len(*obj.Content) > 0 {
+				output <- obj
+			} else {
+				pipeline.Log.Debugf("Skip object %s without selected rows", *obj.Key)
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package collection
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"strconv"
+	"strings"
+)
+
+// ETag compatibility strategies of modified objects filters.
+// They define how objects are compared when its ETags are not comparable.
+const (
+	// ETagCompatStrict treats objects with not comparable ETags as modified.
+	ETagCompatStrict = "strict"
+	// ETagCompatSize treats objects with not comparable ETags as equal if its sizes are equal.
+	ETagCompatSize = "size"
+	// ETagCompatHash treats objects with not comparable ETags as equal if its sizes and MD5 of content are equal.
+	// Content of objects without plain MD5 ETag is downloaded to calculate the hash.
+	ETagCompatHash = "hash"
+)
+
+// ModifiedConfig is the configuration of FilterObjectsModified and FilterObjectsModifiedByListing steps.
+type ModifiedConfig struct {
+	// ETagCompat is the strategy used for objects with not comparable ETags, empty string means ETagCompatStrict.
+	ETagCompat string
+	// SpillDir keeps target listing in temporary file in given directory instead of memory.
+	// It is used by FilterObjectsModifiedByListing only.
+	SpillDir string
+	// SpillThreshold keeps target listing in memory until it has more than given number of keys, then it is moved
+	// to temporary file in SpillDir, or in default temporary dir if SpillDir is empty. Zero disables the threshold.
+	SpillThreshold int
+	// SpillCacheSize limits memory used by the index of spilled keys in bytes, DefaultSpillCacheSize if zero.
+	SpillCacheSize int
+}
+
+// modifiedConfig return ModifiedConfig of modified objects filter step config. Configs of pipelines built before
+// ModifiedConfig are accepted too: nil means default config and string is ModifiedConfig.SpillDir.
+func modifiedConfig(config interface{}) (ModifiedConfig, bool) {
+	switch cfg := config.(type) {
+	case ModifiedConfig:
+		return cfg, true
+	case nil:
+		return ModifiedConfig{}, true
+	case string:
+		return ModifiedConfig{SpillDir: cfg}, true
+	default:
+		return ModifiedConfig{}, false
+	}
+}
+
+// plainMD5 return lowercase hex MD5 if ETag is MD5 of object content, otherwise empty string.
+// AWS S3 returns MD5 ETags for single part uploads only, ETags of multipart uploads have "-<parts count>" suffix.
+// Other S3 implementations can return ETags in own format.
+func plainMD5(etag *string) string {
+	if etag == nil {
+		return ""
+	}
+	s := strings.ToLower(strings.Trim(*etag, `"`))
+	if len(s) != md5.Size*2 {
+		return ""
+	}
+	if _, err := hex.DecodeString(s); err != nil {
+		return ""
+	}
+	return s
+}
+
+// objectsEqual checks if source object is equal to target object.
+// If ETags of both objects are MD5 of content or ETags are equal, only ETags are compared.
+// Otherwise ETags are not comparable and objects are compared with given ETag compatibility strategy.
+func objectsEqual(group *pipeline.Group, strategy string, src, dst *storage.Object) bool {
+	if (src.ETag != nil) && (dst.ETag != nil) && (*src.ETag == *dst.ETag) {
+		return true
+	}
+	srcMD5, dstMD5 := plainMD5(src.ETag), plainMD5(dst.ETag)
+	if (srcMD5 != "") && (dstMD5 != "") {
+		return srcMD5 == dstMD5
+	}
+
+	switch strategy {
+	case ETagCompatSize, ETagCompatHash:
+	default:
+		return false
+	}
+	if (src.Size == nil) || (dst.Size == nil) || (*src.Size != *dst.Size) {
+		return false
+	}
+	if strategy == ETagCompatSize {
+		return true
+	}
+
+	var err error
+	if srcMD5 == "" {
+		if srcMD5, err = contentMD5(group, group.Source, src); err != nil {
+			pipeline.Log.Warnf("Failed to calculate source object %s hash, object considered modified: %s", *src.Key, err)
+			return false
+		}
+	}
+	if dstMD5 == "" {
+		if dstMD5, err = contentMD5(group, group.Target, dst); err != nil {
+			pipeline.Log.Warnf("Failed to calculate target object %s hash, object considered modified: %s", *dst.Key, err)
+			return false
+		}
+	}
+	return srcMD5 == dstMD5
+}
+
+// contentMD5 download object content from given storage and return hex MD5 of it.
+// Content is not kept in obj.
+func contentMD5(group *pipeline.Group, st storage.Storage, obj *storage.Object) (string, error) {
+	tmp := &storage.Object{Key: obj.Key, VersionId: obj.VersionId}
+	ctx, cancel := group.ObjectContext()
+	defer cancel()
+	if err := st.GetObjectContent(ctx, tmp); err != nil {
+		return "", err
+	}
+	sum := md5.Sum(*tmp.Content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// encodeListingEntry encode ETag and size of target object to keyStore value.
+func encodeListingEntry(obj *storage.Object) string {
+	size := ""
+	if obj.Size != nil {
+		size = strconv.FormatInt(*obj.Size, 10)
+	}
+	return size + "|" + aws.StringValue(obj.ETag)
+}
+
+// decodeListingEntry return target object with given key, ETag and size decoded from keyStore value.
+func decodeListingEntry(key *string, value string) *storage.Object {
+	obj := &storage.Object{Key: key}
+	parts := strings.SplitN(value, "|", 2)
+	if len(parts) != 2 {
+		return obj
+	}
+	if size, err := strconv.ParseInt(parts[0], 10, 64); err == nil {
+		obj.Size = &size
+	}
+	if parts[1] != "" {
+		obj.ETag = &parts[1]
+	}
+	return obj
+}
@@ -1,10 +1,11 @@
 package collection
 
 import (
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/storage"
-	"github.com/sirupsen/logrus"
 	"path/filepath"
+	"strings"
 )
 
 // FilterObjectsByExt accepts an input object and checks if it matches the filter.
@@ -129,6 +130,101 @@ var FilterObjectsByCTNot pipeline.StepFn = func(group *pipeline.Group, stepNum i
 	}
 }
 
+// S3 Intelligent-Tiering access tiers of FilterObjectsByTieringStatus.
+const (
+	TierFrequentAccess    = "FREQUENT_ACCESS"
+	TierInfrequentAccess  = "INFREQUENT_ACCESS"
+	TierArchiveAccess     = "ARCHIVE_ACCESS"
+	TierDeepArchiveAccess = "DEEP_ARCHIVE_ACCESS"
+)
+
+// TieringStatuses are all supported access tiers.
+var TieringStatuses = []string{TierFrequentAccess, TierInfrequentAccess, TierArchiveAccess, TierDeepArchiveAccess}
+
+// ValidTieringStatus check if s is one of TieringStatuses.
+func ValidTieringStatus(s string) bool {
+	for _, tier := range TieringStatuses {
+		if s == tier {
+			return true
+		}
+	}
+	return false
+}
+
+// FilterObjectsByTieringStatus accepts an input object and checks if it matches the filter.
+// This filter skips objects which are not in S3 Intelligent-Tiering access tiers specified in the config,
+// as well as objects of other storage classes. Object metadata should be loaded with HEAD request,
+// since listing doesn't return archive status.
+// S3 returns archive status of archived objects only, so FREQUENT_ACCESS and INFREQUENT_ACCESS tiers
+// are not distinguished: any of them matches all not archived Intelligent-Tiering objects.
+//
+// This filter read configuration from Step.Config and assert it type to []string type.
+var FilterObjectsByTieringStatus pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.([]string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			flag := false
+			if aws.StringValue(obj.StorageClass) == "INTELLIGENT_TIERING" {
+				status := aws.StringValue(obj.ArchiveStatus)
+				for _, tier := range cfg {
+					if (tier == status) || ((status == "") && ((tier == TierFrequentAccess) || (tier == TierInfrequentAccess))) {
+						flag = true
+						break
+					}
+				}
+			}
+			if flag {
+				output <- obj
+			} else {
+				pipeline.Log.Debugf("Skip object %s in not matched access tier", *obj.Key)
+			}
+		}
+	}
+}
+
+// FilterObjectsByMetaNot accepts an input object and checks if it matches the filter.
+// This filter skips objects with user metadata matching any of key=value pairs specified in the config.
+// Metadata keys are compared case-insensitively, values are compared exactly.
+//
+// This filter read configuration from Step.Config and assert it type to map[string]string type.
+var FilterObjectsByMetaNot pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(map[string]string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			flag := false
+			for metaKey, metaVal := range obj.Metadata {
+				for key, val := range cfg {
+					if strings.EqualFold(metaKey, key) && (metaVal != nil) && (*metaVal == val) {
+						flag = true
+						break
+					}
+				}
+			}
+			if !flag {
+				output <- obj
+			} else {
+				pipeline.Log.Debugf("Skip object %s with matched metadata", *obj.Key)
+			}
+		}
+	}
+}
+
 // FilterObjectsByMtimeAfter accepts an input object and checks if it matches the filter.
 // This filter accepts objects that modified after given unix timestamp.
 //
@@ -177,8 +273,18 @@ var FilterObjectsByMtimeBefore pipeline.StepFn = func(group *pipeline.Group, ste
 
 // FilterObjectsModified accepts an input object and checks if it matches the filter
 // This filter gets object meta from target storage and compare object ETags. If Etags are equal object will be skipped
-// For FS storage xattr support are required for proper work.
+// If ETags are not comparable, objects are compared with ModifiedConfig.ETagCompat strategy.
+// For FS storage xattr support are required for proper work with ETagCompatStrict strategy.
+//
+// This filter read configuration from Step.Config and assert it type to ModifiedConfig type,
+// nil config means default ModifiedConfig.
 var FilterObjectsModified pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := modifiedConfig(info.Config)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
 	for obj := range input {
 		select {
 		case <-group.Ctx.Done():
@@ -188,9 +294,86 @@ var FilterObjectsModified pipeline.StepFn = func(group *pipeline.Group, stepNum
 				Key:       obj.Key,
 				VersionId: obj.VersionId,
 			}
-			err := group.Target.GetObjectMeta(destObj)
-			if (err != nil) || (obj.ETag == nil || destObj.ETag == nil) || (*obj.ETag != *destObj.ETag) {
-				logrus.Infof("%s : %s", obj.ETag, destObj.ETag)
+			ctx, cancel := group.ObjectContext()
+			err := group.Target.GetObjectMeta(ctx, destObj)
+			cancel()
+			if (err != nil) || !objectsEqual(group, cfg.ETagCompat, obj, destObj) {
+				pipeline.Log.Debugf("Object %s modified, source ETag: %s, target ETag: %s", *obj.Key, aws.StringValue(obj.ETag), aws.StringValue(destObj.ETag))
+				output <- obj
+			}
+		}
+	}
+}
+
+// FilterObjectsModifiedByListing accepts an input object and checks if it matches the filter.
+// Unlike FilterObjectsModified this filter does not request object meta from target storage for every object.
+// Instead it lists target storage once and compare object ETags with stored target ETags.
+// If Etags are equal object will be skipped.
+// If ETags are not comparable, objects are compared with ModifiedConfig.ETagCompat strategy.
+//
+// This filter read configuration from Step.Config and assert it type to ModifiedConfig type,
+// string config is accepted as ModifiedConfig.SpillDir like in previous versions.
+// If ModifiedConfig.SpillDir is not empty string, target ETags are spilled to temporary file in given directory instead of memory.
+// With ModifiedConfig.SpillThreshold they are spilled only when the listing exceeds the threshold.
+var FilterObjectsModifiedByListing pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := modifiedConfig(info.Config)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	targetEtags, err := newSpillKeyStore(cfg.SpillDir, cfg.SpillThreshold, cfg.SpillCacheSize)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	defer targetEtags.Close()
+
+	// Listed keys of S3 target include the target prefix, source objects are looked up by keys given to PutObject.
+	mapper, _ := group.Target.(storage.KeyMapper)
+	listChan := make(chan *storage.Object, 1000)
+	listErrChan := make(chan error, 1)
+	go func() {
+		listErrChan <- group.Target.List(group.Ctx, listChan)
+		close(listChan)
+	}()
+	for obj := range listChan {
+		if (obj.ETag == nil) && (obj.Size == nil) {
+			continue
+		}
+		key := *obj.Key
+		if mapper != nil {
+			var ok bool
+			if key, ok = mapper.RelativeKey(key); !ok {
+				continue
+			}
+		}
+		if err := targetEtags.Put(key, encodeListingEntry(obj)); err != nil {
+			errChan <- err
+			for range listChan {
+			}
+			return
+		}
+	}
+	if err := <-listErrChan; err != nil {
+		errChan <- err
+		return
+	}
+	pipeline.Log.Debugf("Target listing finished, loaded %d ETags", targetEtags.Len())
+
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			entry, ok, err := targetEtags.Get(*obj.Key)
+			if err != nil {
+				errChan <- err
+				continue
+			}
+			destObj := decodeListingEntry(obj.Key, entry)
+			if !ok || !objectsEqual(group, cfg.ETagCompat, obj, destObj) {
+				pipeline.Log.Debugf("Object %s modified, source ETag: %s, target ETag: %s", *obj.Key, aws.StringValue(obj.ETag), aws.StringValue(destObj.ETag))
 				output <- obj
 			}
 		}
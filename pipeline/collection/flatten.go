@@ -0,0 +1,117 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strings"
+)
+
+// Policies of FlattenKeys step for objects with the same base name.
+const (
+	// FlattenCollisionError fails the object with KeyCollisionError.
+	FlattenCollisionError = "error"
+	// FlattenCollisionSuffix appends "~N" suffix before the extension of the key, like "Photo~1.jpg".
+	FlattenCollisionSuffix = "suffix"
+	// FlattenCollisionOverwrite syncs all objects to the same key, the object uploaded last wins.
+	FlattenCollisionOverwrite = "overwrite"
+)
+
+// FlattenConfig is the configuration of FlattenKeys step.
+type FlattenConfig struct {
+	// Policy is one of FlattenCollision* constants.
+	Policy string
+	// Prefix is the part of object keys which is kept before flattened key, like the S3 source path.
+	Prefix string
+	// Collisions records renamed and overwritten objects, it is optional.
+	Collisions *RenameLog
+	// SpillDir keeps flattened keys in temporary file in given directory instead of memory.
+	SpillDir string
+	// SpillCacheSize limits memory used by the index of spilled keys in bytes, DefaultSpillCacheSize if zero.
+	SpillCacheSize int
+	// Separator replaces "/" in keys instead of dropping directories, so "a/b/c.jpg" is synced to "a__b__c.jpg"
+	// with "__" separator. With Reverse the separator is replaced with "/", so "a__b__c.jpg" is synced to "a/b/c.jpg".
+	Separator string
+	Reverse   bool
+}
+
+// FlattenKeys read objects from input, replace its keys with the last key element and send object to next pipeline steps,
+// so "a/b/c.jpg" is synced to "c.jpg". Keys are flattened relative to FlattenConfig.Prefix, the prefix is kept.
+// If FlattenConfig.Separator is set, key elements are joined with it, or split by it with FlattenConfig.Reverse.
+// Objects flattened to the key already used by other object are handled with FlattenConfig.Policy.
+//
+// This step read configuration from Step.Config and assert it type to FlattenConfig type.
+var FlattenKeys pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(FlattenConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	switch cfg.Policy {
+	case FlattenCollisionError, FlattenCollisionSuffix, FlattenCollisionOverwrite:
+	default:
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	seen, err := newKeyStore(cfg.SpillDir, cfg.SpillCacheSize)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	defer seen.Close()
+
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			rel := strings.TrimPrefix(strings.TrimPrefix(*obj.Key, cfg.Prefix), "/")
+			head := (*obj.Key)[:len(*obj.Key)-len(rel)]
+			var key string
+			switch {
+			case cfg.Separator == "":
+				key = head + path.Base(rel)
+			case cfg.Reverse:
+				key = head + strings.Replace(rel, cfg.Separator, "/", -1)
+			default:
+				key = head + strings.Replace(rel, "/", cfg.Separator, -1)
+			}
+			other, ok, err := seen.Get(key)
+			if err != nil {
+				errChan <- err
+				continue
+			}
+			if !ok {
+				if err := seen.Put(key, *obj.Key); err != nil {
+					errChan <- err
+					continue
+				}
+			} else {
+				switch cfg.Policy {
+				case FlattenCollisionError:
+					errChan <- &KeyCollisionError{Key: *obj.Key, TargetKey: key, OtherKey: other}
+					continue
+				case FlattenCollisionSuffix:
+					if key, err = conflictFreeKey(seen, key, func(s string) string { return s }); err != nil {
+						errChan <- err
+						continue
+					}
+					if err := seen.Put(key, *obj.Key); err != nil {
+						errChan <- err
+						continue
+					}
+					pipeline.Log.Warnf("Object %s flattened to key used by object %s, renamed to %s", *obj.Key, other, key)
+				case FlattenCollisionOverwrite:
+					pipeline.Log.Warnf("Object %s flattened to key %s used by object %s, overwriting", *obj.Key, key, other)
+				}
+				if cfg.Collisions != nil {
+					cfg.Collisions.add(Rename{Key: *obj.Key, TargetKey: key, ConflictKey: other})
+				}
+			}
+			pipeline.Log.Debugf("Object %s target key: %s", *obj.Key, key)
+			obj.Key = &key
+			output <- obj
+		}
+	}
+}
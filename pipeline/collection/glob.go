@@ -0,0 +1,126 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strings"
+)
+
+// globMeta are special characters of key glob patterns, they and backslash are matched literally if escaped
+// with backslash.
+const globMeta = "*?["
+
+// globEscaped check if c is escaped by preceding backslash in key glob pattern.
+func globEscaped(c byte) bool {
+	return (c == '\\') || (strings.IndexByte(globMeta, c) >= 0)
+}
+
+// globMetaIndex return index of the first unescaped glob special character in s or -1.
+func globMetaIndex(s string) int {
+	for i := 0; i < len(s); i++ {
+		if (s[i] == '\\') && (i+1 < len(s)) && globEscaped(s[i+1]) {
+			i++
+		} else if strings.IndexByte(globMeta, s[i]) >= 0 {
+			return i
+		}
+	}
+	return -1
+}
+
+// unescapeGlob remove backslashes escaping glob special characters in literal part of path.
+func unescapeGlob(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if (s[i] == '\\') && (i+1 < len(s)) && globEscaped(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// SplitKeyGlob split S3 path with glob pattern, like "data/2023-*/", to the literal prefix ending with the last "/"
+// before the first special character ("data/") and the pattern of keys relative to the prefix ("2023-*/").
+// Pattern is empty if path has no special characters. Special characters escaped with backslash are literal,
+// they are unescaped in the prefix.
+func SplitKeyGlob(p string) (prefix, pattern string) {
+	i := globMetaIndex(p)
+	if i < 0 {
+		return unescapeGlob(p), ""
+	}
+	i = strings.LastIndex(p[:i], "/") + 1
+	return unescapeGlob(p[:i]), p[i:]
+}
+
+// KeyGlobListPrefix return the literal beginning of key glob pattern, like "2023-" of "2023-*/".
+// Only keys with this prefix can match the pattern, so it can be used as listing prefix.
+func KeyGlobListPrefix(pattern string) string {
+	if i := globMetaIndex(pattern); i >= 0 {
+		pattern = pattern[:i]
+	}
+	return unescapeGlob(pattern)
+}
+
+// ValidKeyGlob check syntax of key glob pattern.
+func ValidKeyGlob(pattern string) error {
+	for _, seg := range strings.Split(pattern, "/") {
+		if _, err := path.Match(seg, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MatchKeyGlob check if key matches glob pattern. Pattern is matched against the leading path elements of key
+// with path.Match, so "*" and "?" don't match "/", and keys under matched "dir" are matched too. Pattern with
+// trailing slash matches only keys under matched dirs.
+func MatchKeyGlob(pattern, key string) bool {
+	dir := strings.HasSuffix(pattern, "/")
+	segs := strings.Split(strings.TrimSuffix(pattern, "/"), "/")
+	parts := strings.SplitN(key, "/", len(segs)+1)
+	if (len(parts) < len(segs)) || (dir && (len(parts) == len(segs))) {
+		return false
+	}
+	for i, seg := range segs {
+		if ok, _ := path.Match(seg, parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// KeyGlobConfig is the configuration of FilterObjectsByKeyGlob step.
+type KeyGlobConfig struct {
+	// Pattern is the key glob pattern, see MatchKeyGlob.
+	Pattern string
+	// Prefix is the part of object keys which is not matched with the pattern, like the S3 source path.
+	Prefix string
+}
+
+// FilterObjectsByKeyGlob accepts an input object and checks if it matches the filter.
+// This filter skips objects with keys that don't match the glob pattern after KeyGlobConfig.Prefix,
+// see MatchKeyGlob.
+//
+// This filter read configuration from Step.Config and assert it type to KeyGlobConfig type.
+var FilterObjectsByKeyGlob pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(KeyGlobConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if MatchKeyGlob(cfg.Pattern, strings.TrimPrefix(*obj.Key, cfg.Prefix)) {
+				output <- obj
+			}
+		}
+	}
+}
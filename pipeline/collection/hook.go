@@ -0,0 +1,136 @@
+package collection
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Object hook names.
+const (
+	HookPreObject  = "pre-object"
+	HookPostObject = "post-object"
+)
+
+// HookConfig is the configuration of ObjectHook step.
+type HookConfig struct {
+	// Hook is the hook name passed to command, HookPreObject or HookPostObject.
+	Hook string
+	// Command is executed with system shell.
+	Command string
+	// Timeout limits command execution time, zero means no timeout.
+	Timeout time.Duration
+	// FailObject makes failed command fail the object, otherwise failure is only logged.
+	FailObject bool
+	// TargetURL return target URL of object with given key.
+	TargetURL func(key string) string
+}
+
+// HookError raises when hook command fails and HookConfig.FailObject is enabled.
+type HookError struct {
+	Hook string
+	Key  string
+	Err  error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("object: %s %s hook failed with error: %s", e.Key, e.Hook, e.Err)
+}
+
+// ObjectHook read objects from input, run hook command for every object and send object to next pipeline steps.
+// Object key, size, action, hook name and target URL are passed to command with S3SYNC_KEY, S3SYNC_SIZE,
+// S3SYNC_ACTION, S3SYNC_HOOK and S3SYNC_TARGET_URL environment variables. Size is empty if object content is not loaded yet.
+// Command output is logged with debug level.
+//
+// This step read configuration from Step.Config and assert it type to HookConfig type.
+var ObjectHook pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(HookConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			size := ""
+			if obj.Content != nil {
+				size = strconv.Itoa(len(*obj.Content))
+			}
+			env := []string{
+				"S3SYNC_HOOK=" + cfg.Hook,
+				"S3SYNC_ACTION=upload",
+				"S3SYNC_KEY=" + *obj.Key,
+				"S3SYNC_SIZE=" + size,
+				"S3SYNC_TARGET_URL=" + cfg.TargetURL(*obj.Key),
+			}
+			ctx, cancel := hookContext(group.Ctx, cfg.Timeout)
+			err := RunHook(ctx, cfg.Command, env, nil, *obj.Key)
+			cancel()
+			if err == nil {
+				output <- obj
+			} else if cfg.FailObject {
+				traceObject(group, obj, err)
+				errChan <- &HookError{Hook: cfg.Hook, Key: *obj.Key, Err: err}
+			} else {
+				pipeline.Log.Warnf("Object %s %s hook failed with error: %s", *obj.Key, cfg.Hook, err)
+				output <- obj
+			}
+		}
+	}
+}
+
+// RunHook execute command with system shell, given environment variables and stdin.
+// Command output is logged line by line with debug level, lines are prefixed with logPrefix.
+func RunHook(ctx context.Context, command string, env []string, stdin io.Reader, logPrefix string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdin = stdin
+
+	// Output is written to file instead of pipe, since with pipe Wait waits for all processes started by the shell
+	// to close the pipe, even after the shell is killed on timeout.
+	out, err := ioutil.TempFile("", "s3sync-hook-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+	cmd.Stdout = out
+	cmd.Stderr = out
+
+	err = cmd.Run()
+	if _, seekErr := out.Seek(0, io.SeekStart); seekErr == nil {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			pipeline.Log.Debugf("Hook %s: %s", logPrefix, scanner.Text())
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	return err
+}
+
+// hookContext return context for hook execution limited with timeout if it is not zero.
+func hookContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
@@ -0,0 +1,416 @@
+package collection
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"github.com/larrabee/s3sync/pipeline"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// keyStore stores key-value pairs for steps that need to keep the whole storage listing.
+type keyStore interface {
+	Put(key, value string) error
+	Get(key string) (string, bool, error)
+	Len() int
+	Close() error
+}
+
+// newKeyStore return in-memory keyStore if dir is empty, otherwise return keyStore spilled to temporary files in dir,
+// which keeps up to cacheSize bytes of its index in memory (DefaultSpillCacheSize if zero).
+func newKeyStore(dir string, cacheSize int) (keyStore, error) {
+	if dir == "" {
+		return memKeyStore{}, nil
+	}
+	return newDiskKeyStore(dir, cacheSize)
+}
+
+// newSpillKeyStore return keyStore kept in memory until it grows above threshold keys, then it is moved to temporary
+// files in dir, or in default temporary dir if dir is empty. Zero threshold means the same as newKeyStore.
+func newSpillKeyStore(dir string, threshold, cacheSize int) (keyStore, error) {
+	if threshold <= 0 {
+		return newKeyStore(dir, cacheSize)
+	}
+	return &spillKeyStore{keyStore: memKeyStore{}, dir: dir, threshold: threshold, cacheSize: cacheSize}, nil
+}
+
+// memKeyStore is keyStore backed by map.
+type memKeyStore map[string]string
+
+func (s memKeyStore) Put(key, value string) error {
+	s[key] = value
+	return nil
+}
+
+func (s memKeyStore) Get(key string) (string, bool, error) {
+	value, ok := s[key]
+	return value, ok, nil
+}
+
+func (s memKeyStore) Len() int {
+	return len(s)
+}
+
+func (s memKeyStore) Close() error {
+	return nil
+}
+
+// DefaultSpillCacheSize is the default memory limit of the index cache of key stores spilled to disk, in bytes.
+const DefaultSpillCacheSize = 32 << 20
+
+// diskKeyStore is keyStore backed by append-only file of records and on-disk hash index of record offsets.
+// Only pages of the index up to the cache size are kept in memory, so memory usage doesn't depend on the number
+// of keys.
+type diskKeyStore struct {
+	file   *os.File
+	writer *bufio.Writer
+	offset int64
+	dirty  bool
+	index  *diskIndex
+	dir    string
+	cache  int
+	count  int
+}
+
+func newDiskKeyStore(dir string, cacheSize int) (*diskKeyStore, error) {
+	if cacheSize <= 0 {
+		cacheSize = DefaultSpillCacheSize
+	}
+	f, err := ioutil.TempFile(dir, "keys-")
+	if err != nil {
+		return nil, err
+	}
+	index, err := newDiskIndex(dir, diskIndexMinSlots, cacheSize)
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	return &diskKeyStore{
+		file:   f,
+		writer: bufio.NewWriter(f),
+		index:  index,
+		dir:    dir,
+		cache:  cacheSize,
+	}, nil
+}
+
+// hashKey return 64-bit hash of key, it is never zero, since zero marks empty slots of diskIndex.
+func hashKey(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	if sum := h.Sum64(); sum != 0 {
+		return sum
+	}
+	return 1
+}
+
+// Put append record to the file and point the index slot of the key to it, so the last value of the key wins.
+// Record format: key length (4 bytes), value length (4 bytes), key, value.
+func (s *diskKeyStore) Put(key, value string) error {
+	h := hashKey(key)
+	slot, found, err := s.find(h, key)
+	if err != nil {
+		return err
+	}
+	if !found && (uint64(s.count+1) > s.index.slots/2) {
+		if err := s.grow(); err != nil {
+			return err
+		}
+		if slot, _, err = s.find(h, key); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(key)))
+	binary.BigEndian.PutUint32(header[4:], uint32(len(value)))
+	if _, err := s.writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.writer.WriteString(key); err != nil {
+		return err
+	}
+	if _, err := s.writer.WriteString(value); err != nil {
+		return err
+	}
+	if err := s.index.set(slot, h, s.offset); err != nil {
+		return err
+	}
+	s.offset += int64(len(header) + len(key) + len(value))
+	s.dirty = true
+	if !found {
+		s.count++
+	}
+	return nil
+}
+
+// Get return value of the key.
+func (s *diskKeyStore) Get(key string) (string, bool, error) {
+	slot, found, err := s.find(hashKey(key), key)
+	if (err != nil) || !found {
+		return "", false, err
+	}
+	_, offset, err := s.index.get(slot)
+	if err != nil {
+		return "", false, err
+	}
+	_, value, err := s.record(offset)
+	return value, true, err
+}
+
+// find return index slot of the key with hash h, or the empty slot for it if the key is not stored.
+// Slots are probed linearly, records of slots with the same hash are read to compare keys.
+func (s *diskKeyStore) find(h uint64, key string) (uint64, bool, error) {
+	mask := s.index.slots - 1
+	for slot := h & mask; ; slot = (slot + 1) & mask {
+		sh, offset, err := s.index.get(slot)
+		if (err != nil) || (sh == 0) {
+			return slot, false, err
+		}
+		if sh != h {
+			continue
+		}
+		k, _, err := s.record(offset)
+		if err != nil {
+			return 0, false, err
+		}
+		if k == key {
+			return slot, true, nil
+		}
+	}
+}
+
+// record read key and value of the record at offset.
+func (s *diskKeyStore) record(offset int64) (string, string, error) {
+	if s.dirty {
+		if err := s.writer.Flush(); err != nil {
+			return "", "", err
+		}
+		s.dirty = false
+	}
+	header := make([]byte, 8)
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return "", "", err
+	}
+	keyLen := binary.BigEndian.Uint32(header[:4])
+	valueLen := binary.BigEndian.Uint32(header[4:])
+	data := make([]byte, keyLen+valueLen)
+	if _, err := s.file.ReadAt(data, offset+int64(len(header))); err != nil {
+		return "", "", err
+	}
+	return string(data[:keyLen]), string(data[keyLen:]), nil
+}
+
+// grow move the index to new index with twice more slots. Slots of the old index are read sequentially
+// and inserted by their hashes, without reading records.
+func (s *diskKeyStore) grow() error {
+	old := s.index
+	index, err := newDiskIndex(s.dir, old.slots*2, s.cache)
+	if err != nil {
+		return err
+	}
+	err = old.scan(func(h uint64, offset int64) error {
+		mask := index.slots - 1
+		for slot := h & mask; ; slot = (slot + 1) & mask {
+			sh, _, err := index.get(slot)
+			if err != nil {
+				return err
+			}
+			if sh == 0 {
+				return index.set(slot, h, offset)
+			}
+		}
+	})
+	if err != nil {
+		_ = index.Close()
+		return err
+	}
+	s.index = index
+	return old.Close()
+}
+
+func (s *diskKeyStore) Len() int {
+	return s.count
+}
+
+// Close remove the store files.
+func (s *diskKeyStore) Close() error {
+	_ = s.file.Close()
+	err := os.Remove(s.file.Name())
+	if ierr := s.index.Close(); err == nil {
+		err = ierr
+	}
+	return err
+}
+
+const (
+	// diskIndexSlotSize is the size of diskIndex slot: key hash and record offset plus one, zero hash marks empty slot.
+	diskIndexSlotSize = 16
+	// diskIndexPageSize is the size of diskIndex pages read, cached and written at once.
+	diskIndexPageSize = 4096
+	diskIndexMinSlots = 1 << 16
+)
+
+// diskIndex is open addressing hash table of record offsets in temporary file. Pages of the file are cached
+// in memory up to the cache size, least recently used pages are written back and evicted.
+type diskIndex struct {
+	file     *os.File
+	slots    uint64
+	maxPages int
+	pages    map[int64]*list.Element
+	lru      *list.List
+}
+
+// diskIndexPage is cached page of diskIndex file.
+type diskIndexPage struct {
+	num   int64
+	data  []byte
+	dirty bool
+}
+
+// newDiskIndex create empty index with given number of slots, a power of 2, in temporary file in dir.
+func newDiskIndex(dir string, slots uint64, cacheSize int) (*diskIndex, error) {
+	f, err := ioutil.TempFile(dir, "keys-index-")
+	if err != nil {
+		return nil, err
+	}
+	// The file is sparse, not written pages are read as zeros, that is empty slots.
+	if err := f.Truncate(int64(slots * diskIndexSlotSize)); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return nil, err
+	}
+	maxPages := cacheSize / diskIndexPageSize
+	if maxPages < 16 {
+		maxPages = 16
+	}
+	return &diskIndex{file: f, slots: slots, maxPages: maxPages, pages: make(map[int64]*list.Element), lru: list.New()}, nil
+}
+
+// page return cached page with given number, it is read from the file on cache miss.
+func (idx *diskIndex) page(num int64) (*diskIndexPage, error) {
+	if el, ok := idx.pages[num]; ok {
+		idx.lru.MoveToFront(el)
+		return el.Value.(*diskIndexPage), nil
+	}
+	var p *diskIndexPage
+	if idx.lru.Len() >= idx.maxPages {
+		el := idx.lru.Back()
+		p = el.Value.(*diskIndexPage)
+		if err := idx.writePage(p); err != nil {
+			return nil, err
+		}
+		idx.lru.Remove(el)
+		delete(idx.pages, p.num)
+		p.num = num
+	} else {
+		p = &diskIndexPage{num: num, data: make([]byte, diskIndexPageSize)}
+	}
+	if _, err := idx.file.ReadAt(p.data, num*diskIndexPageSize); (err != nil) && (err != io.EOF) {
+		return nil, err
+	}
+	idx.pages[num] = idx.lru.PushFront(p)
+	return p, nil
+}
+
+// writePage write page to the file if it is changed.
+func (idx *diskIndex) writePage(p *diskIndexPage) error {
+	if !p.dirty {
+		return nil
+	}
+	if _, err := idx.file.WriteAt(p.data, p.num*diskIndexPageSize); err != nil {
+		return err
+	}
+	p.dirty = false
+	return nil
+}
+
+// get return hash and record offset of the slot, zero hash means empty slot.
+func (idx *diskIndex) get(slot uint64) (uint64, int64, error) {
+	p, err := idx.page(int64(slot * diskIndexSlotSize / diskIndexPageSize))
+	if err != nil {
+		return 0, 0, err
+	}
+	b := p.data[slot*diskIndexSlotSize%diskIndexPageSize:]
+	return binary.BigEndian.Uint64(b[:8]), int64(binary.BigEndian.Uint64(b[8:16])) - 1, nil
+}
+
+// set store hash and record offset to the slot.
+func (idx *diskIndex) set(slot uint64, h uint64, offset int64) error {
+	p, err := idx.page(int64(slot * diskIndexSlotSize / diskIndexPageSize))
+	if err != nil {
+		return err
+	}
+	b := p.data[slot*diskIndexSlotSize%diskIndexPageSize:]
+	binary.BigEndian.PutUint64(b[:8], h)
+	binary.BigEndian.PutUint64(b[8:16], uint64(offset+1))
+	p.dirty = true
+	return nil
+}
+
+// scan call fn for every not empty slot. Changed pages are written back first and the file is read sequentially.
+func (idx *diskIndex) scan(fn func(h uint64, offset int64) error) error {
+	for el := idx.lru.Front(); el != nil; el = el.Next() {
+		if err := idx.writePage(el.Value.(*diskIndexPage)); err != nil {
+			return err
+		}
+	}
+	if _, err := idx.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReaderSize(idx.file, 64*diskIndexPageSize)
+	slot := make([]byte, diskIndexSlotSize)
+	for i := uint64(0); i < idx.slots; i++ {
+		if _, err := io.ReadFull(r, slot); err != nil {
+			return err
+		}
+		if h := binary.BigEndian.Uint64(slot[:8]); h != 0 {
+			if err := fn(h, int64(binary.BigEndian.Uint64(slot[8:]))-1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close remove the index file.
+func (idx *diskIndex) Close() error {
+	_ = idx.file.Close()
+	return os.Remove(idx.file.Name())
+}
+
+// spillKeyStore is keyStore backed by memKeyStore that is replaced by diskKeyStore when threshold is exceeded.
+type spillKeyStore struct {
+	keyStore
+	dir       string
+	threshold int
+	cacheSize int
+	spilled   bool
+}
+
+func (s *spillKeyStore) Put(key, value string) error {
+	if err := s.keyStore.Put(key, value); err != nil {
+		return err
+	}
+	if s.spilled || (s.keyStore.Len() <= s.threshold) {
+		return nil
+	}
+	disk, err := newDiskKeyStore(s.dir, s.cacheSize)
+	if err != nil {
+		return err
+	}
+	for k, v := range s.keyStore.(memKeyStore) {
+		if err := disk.Put(k, v); err != nil {
+			_ = disk.Close()
+			return err
+		}
+	}
+	pipeline.Log.Infof("Key set exceeded %d keys, spilled to %s", s.threshold, disk.file.Name())
+	s.keyStore = disk
+	s.spilled = true
+	return nil
+}
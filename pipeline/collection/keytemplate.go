@@ -0,0 +1,187 @@
+package collection
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// KeyTemplateVars are the variables available in target key template.
+// Key, Dir, Base, Name and Ext are taken from the source key relative to the source root,
+// date and time parts are zero padded components of the object mtime in UTC.
+type KeyTemplateVars struct {
+	// Key is the source key, like "photos/2024/cat.jpg".
+	Key string
+	// Dir is the directory of the key, like "photos/2024", it is "." for keys in the root.
+	Dir string
+	// Base is the last element of the key, like "cat.jpg".
+	Base string
+	// Name is Base without extension, like "cat".
+	Name string
+	// Ext is the extension of the key including the dot, like ".jpg".
+	Ext    string
+	Size   int
+	Mtime  time.Time
+	Year   string
+	Month  string
+	Day    string
+	Hour   string
+	Minute string
+	Second string
+	// Hash is hex SHA-256 of the object content, use {{prefix 8 .Hash}} to get short hash prefix.
+	Hash string
+}
+
+// KeyTemplate renders target keys of objects from Go template.
+type KeyTemplate struct {
+	tmpl *template.Template
+}
+
+// keyTemplateFuncs are the functions available in target key template in addition to Go template builtins.
+var keyTemplateFuncs = template.FuncMap{
+	"prefix": func(n int, s string) string {
+		if n < len(s) {
+			return s[:n]
+		}
+		return s
+	},
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// NewKeyTemplate parse target key template and check it by rendering key of sample object.
+func NewKeyTemplate(text string) (*KeyTemplate, error) {
+	tmpl, err := template.New("key").Funcs(keyTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	kt := &KeyTemplate{tmpl: tmpl}
+
+	content := []byte("sample")
+	mtime := time.Now()
+	sample := &storage.Object{Content: &content, Mtime: &mtime}
+	if _, err := kt.Render("dir/sample.txt", sample); err != nil {
+		return nil, err
+	}
+	return kt, nil
+}
+
+// Render return target key of object with given source key.
+// Object content should be loaded, since the key can depend on object size and hash.
+func (kt *KeyTemplate) Render(key string, obj *storage.Object) (string, error) {
+	vars := KeyTemplateVars{
+		Key:  key,
+		Dir:  path.Dir(key),
+		Base: path.Base(key),
+		Ext:  path.Ext(key),
+	}
+	vars.Name = strings.TrimSuffix(vars.Base, vars.Ext)
+	if obj.Mtime != nil {
+		vars.Mtime = obj.Mtime.UTC()
+	}
+	vars.Year = fmt.Sprintf("%04d", vars.Mtime.Year())
+	vars.Month = fmt.Sprintf("%02d", vars.Mtime.Month())
+	vars.Day = fmt.Sprintf("%02d", vars.Mtime.Day())
+	vars.Hour = fmt.Sprintf("%02d", vars.Mtime.Hour())
+	vars.Minute = fmt.Sprintf("%02d", vars.Mtime.Minute())
+	vars.Second = fmt.Sprintf("%02d", vars.Mtime.Second())
+	if obj.Content != nil {
+		vars.Size = len(*obj.Content)
+		sum := sha256.Sum256(*obj.Content)
+		vars.Hash = hex.EncodeToString(sum[:])
+	}
+
+	var buf bytes.Buffer
+	if err := kt.tmpl.Execute(&buf, vars); err != nil {
+		return "", err
+	}
+	res := strings.TrimPrefix(buf.String(), "/")
+	if res == "" {
+		return "", fmt.Errorf("key template rendered empty key for %s", key)
+	}
+	return res, nil
+}
+
+// KeyTemplateConfig is the configuration of RenderTargetKey step.
+type KeyTemplateConfig struct {
+	Template *KeyTemplate
+	// Prefix is the part of object keys which is kept before rendered key, like the S3 source path.
+	Prefix string
+	// SpillDir keeps rendered keys used for collision detection in temporary file in given directory instead of memory.
+	SpillDir string
+	// SpillCacheSize limits memory used by the index of spilled keys in bytes, DefaultSpillCacheSize if zero.
+	SpillCacheSize int
+}
+
+// KeyCollisionError raises when two source objects are rendered or flattened to the same target key.
+type KeyCollisionError struct {
+	Key       string
+	TargetKey string
+	OtherKey  string
+}
+
+func (e *KeyCollisionError) Error() string {
+	return fmt.Sprintf("object: %s rendered to target key %s, which is already used by object %s", e.Key, e.TargetKey, e.OtherKey)
+}
+
+// RenderTargetKey read objects from input, replace its keys with keys rendered from template and send object to next pipeline steps.
+// The template is rendered with the key relative to KeyTemplateConfig.Prefix, the prefix is kept before rendered key.
+// Objects rendered to the key already used by other object raise KeyCollisionError.
+// Object content should be loaded by previous steps.
+//
+// This step read configuration from Step.Config and assert it type to KeyTemplateConfig type.
+var RenderTargetKey pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(KeyTemplateConfig)
+	if !ok || cfg.Template == nil {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	rendered, err := newKeyStore(cfg.SpillDir, cfg.SpillCacheSize)
+	if err != nil {
+		errChan <- err
+		return
+	}
+	defer rendered.Close()
+
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			rel := strings.TrimPrefix(strings.TrimPrefix(*obj.Key, cfg.Prefix), "/")
+			head := (*obj.Key)[:len(*obj.Key)-len(rel)]
+			key, err := cfg.Template.Render(rel, obj)
+			if err != nil {
+				errChan <- fmt.Errorf("object: %s key template error: %s", *obj.Key, err)
+				continue
+			}
+			key = head + key
+			other, ok, err := rendered.Get(key)
+			if err != nil {
+				errChan <- err
+				continue
+			}
+			if ok && (other != *obj.Key) {
+				errChan <- &KeyCollisionError{Key: *obj.Key, TargetKey: key, OtherKey: other}
+				continue
+			}
+			if !ok {
+				if err := rendered.Put(key, *obj.Key); err != nil {
+					errChan <- err
+					continue
+				}
+			}
+			pipeline.Log.Debugf("Object %s target key: %s", *obj.Key, key)
+			obj.Key = &key
+			output <- obj
+		}
+	}
+}
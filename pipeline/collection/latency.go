@@ -0,0 +1,104 @@
+package collection
+
+import (
+	"encoding/csv"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LatencyLogColumns are the columns of replication latency CSV log written by LatencyStats.
+var LatencyLogColumns = []string{"key", "source_mtime", "synced_at", "latency_seconds"}
+
+// LatencyStats collects replication latency of synced objects: the time between source object modification
+// and the end of its upload to the target.
+type LatencyStats struct {
+	mu      sync.Mutex
+	count   uint64
+	samples []time.Duration
+	log     *csv.Writer
+	logErr  error
+}
+
+// NewLatencyStats return new empty LatencyStats. If log is not nil, latency of every object is written to it
+// as CSV row with LatencyLogColumns, the header is not written.
+func NewLatencyStats(log io.Writer) *LatencyStats {
+	s := &LatencyStats{}
+	if log != nil {
+		s.log = csv.NewWriter(log)
+	}
+	return s
+}
+
+// Add store latency of object synced at given time, objects without modification time are skipped.
+func (s *LatencyStats) Add(obj *storage.Object, synced time.Time) {
+	if obj.Mtime == nil {
+		return
+	}
+	latency := synced.Sub(*obj.Mtime)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	if len(s.samples) < timingSamplesLimit {
+		s.samples = append(s.samples, latency)
+	} else if i := rand.Int63n(int64(s.count)); i < timingSamplesLimit {
+		s.samples[i] = latency
+	}
+
+	if (s.log == nil) || (s.logErr != nil) {
+		return
+	}
+	s.log.Write([]string{*obj.Key, obj.Mtime.UTC().Format(time.RFC3339Nano), synced.UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(latency.Seconds(), 'f', 3, 64)})
+	s.log.Flush()
+	if s.logErr = s.log.Error(); s.logErr != nil {
+		pipeline.Log.Warnf("Failed to write replication latency log, it is disabled: %s", s.logErr)
+	}
+}
+
+// Count return the number of objects with collected latency.
+func (s *LatencyStats) Count() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Percentile return the p-th (0-100) percentile of replication latency.
+func (s *LatencyStats) Percentile(p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(s.samples))
+	copy(sorted, s.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+// LatencyTracker read synced objects from input, collect its replication latency and send object to next pipeline steps.
+//
+// This filter read configuration from Step.Config and assert it type to *LatencyStats type.
+var LatencyTracker pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*LatencyStats)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			cfg.Add(obj, time.Now())
+			output <- obj
+		}
+	}
+}
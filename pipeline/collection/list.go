@@ -11,7 +11,7 @@ var ListSourceStorage pipeline.StepFn = func(group *pipeline.Group, stepNum int,
 	case <-group.Ctx.Done():
 		return
 	default:
-		err := group.Source.List(output)
+		err := group.Source.List(group.Ctx, output)
 		if err != nil {
 			errChan <- err
 		}
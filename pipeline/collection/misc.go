@@ -5,27 +5,71 @@ import (
 	"github.com/larrabee/s3sync/pipeline"
 	"github.com/larrabee/s3sync/storage"
 	"github.com/sirupsen/logrus"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // Terminator like a /dev/null
 //
 // It read objects from input and do not nothing.
 // Pipeline should end with Terminator.
+//
+// This step read optional configuration from Step.Config and assert it type to *ThroughputStats type.
+// If stats are configured, it counts terminated objects and their size.
 var Terminator pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
-	for range input {
+	info := group.GetStepInfo(stepNum)
+	stats, ok := info.Config.(*ThroughputStats)
+	if !ok && info.Config != nil {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
 		select {
 		case <-group.Ctx.Done():
 			return
 		default:
-			continue
+			if stats != nil {
+				stats.add(obj)
+			}
 		}
 	}
 }
 
 // Logger read objects from input, print object name with Log and send object no next pipeline steps.
+// Owner ID and display name of objects listed with owner are added as owner_id and owner_name log fields,
+// the key of the object copied on target by UploadObjectDedup is added as copy_of field.
+// This filter read configuration from Step.Config and assert it type to logrus.FieldLogger type.
 var Logger pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(*logrus.Logger)
+	cfg, ok := info.Config.(logrus.FieldLogger)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			log := cfg
+			if obj.Owner != nil {
+				log = log.WithFields(logrus.Fields{"owner_id": obj.Owner.ID, "owner_name": obj.Owner.DisplayName})
+			}
+			if obj.CopyOf != nil {
+				log = log.WithField("copy_of", *obj.CopyOf)
+			}
+			log.Infof("Key: %s", *obj.Key)
+			output <- obj
+		}
+	}
+}
+
+// ObjectCallback read objects from input, call configured function with every object and send object to next pipeline steps.
+// This filter read configuration from Step.Config and assert it type to func(*storage.Object) type.
+var ObjectCallback pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(func(*storage.Object))
 	if !ok {
 		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
 		return
@@ -35,7 +79,7 @@ var Logger pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-ch
 		case <-group.Ctx.Done():
 			return
 		default:
-			cfg.Infof("Key: %s", *obj.Key)
+			cfg(obj)
 			output <- obj
 		}
 	}
@@ -62,6 +106,39 @@ var ACLUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input
 	}
 }
 
+// PutSourceObjectACL read objects from input, set its ACL in Source storage and send object to next pipeline steps.
+// Unlike ACLUpdater it changes ACL of existing objects, so Source storage should implement storage.ACLSetter.
+// This step read configuration from Step.Config and assert it type to string type.
+var PutSourceObjectACL pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	setter, ok := group.Source.(storage.ACLSetter)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			ctx, cancel := group.ObjectContext()
+			err := setter.PutObjectACL(ctx, obj, cfg)
+			cancel()
+			if err != nil {
+				errChan <- err
+			} else {
+				obj.ACL = &cfg
+				output <- obj
+			}
+		}
+	}
+}
+
 // StorageClassUpdater read objects from input and update its Storage Class.
 // This filter read configuration from Step.Config and assert it type to string type.
 var StorageClassUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
@@ -82,19 +159,50 @@ var StorageClassUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum in
 	}
 }
 
-// PipelineRateLimit read objects from input and slow down pipeline processing speed to given rate (obj/sec).
+// ContentTypeUpdater read objects from input and override its Content-Type by file extension.
+// Extensions are compared case-insensitively, objects with other extensions are not changed.
 //
-// This filter read configuration from Step.Config and assert it type to uint type.
-var PipelineRateLimit pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+// This filter read configuration from Step.Config and assert it type to map[string]string type (extension -> Content-Type).
+var ContentTypeUpdater pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
 	info := group.GetStepInfo(stepNum)
-	cfg, ok := info.Config.(uint)
+	cfg, ok := info.Config.(map[string]string)
 	if !ok {
 		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
 		return
 	}
-	bucket, err := ratelimit.NewBucketWithRate(float64(cfg), int64(cfg*2))
-	if err != nil {
-		errChan <- err
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if ct, ok := cfg[strings.ToLower(filepath.Ext(*obj.Key))]; ok {
+				ct := ct
+				obj.ContentType = &ct
+			}
+			output <- obj
+		}
+	}
+}
+
+// PipelineRateLimit read objects from input and slow down pipeline processing speed to given rate (obj/sec).
+//
+// This filter read configuration from Step.Config and assert it type to uint type or ratelimit.Bucket type.
+// The bucket can be shared between pipelines to limit their total rate.
+var PipelineRateLimit pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	var bucket ratelimit.Bucket
+	switch cfg := info.Config.(type) {
+	case uint:
+		var err error
+		bucket, err = ratelimit.NewBucketWithRate(float64(cfg), int64(cfg*2))
+		if err != nil {
+			errChan <- err
+			return
+		}
+	case ratelimit.Bucket:
+		bucket = cfg
+	default:
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
 		return
 	}
 	for obj := range input {
@@ -102,7 +210,9 @@ var PipelineRateLimit pipeline.StepFn = func(group *pipeline.Group, stepNum int,
 		case <-group.Ctx.Done():
 			return
 		default:
+			start := time.Now()
 			bucket.Wait(1)
+			obj.Timings.LimiterWait += time.Since(start)
 			output <- obj
 		}
 	}
@@ -0,0 +1,97 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PrefixCount is the number and the total size of synced objects under a key prefix.
+type PrefixCount struct {
+	Prefix  string
+	Objects uint64
+	Bytes   uint64
+}
+
+// PrefixStats counts synced objects by key prefixes of given depth.
+type PrefixStats struct {
+	mu     sync.Mutex
+	depth  int
+	counts map[string]*PrefixCount
+}
+
+// NewPrefixStats return new empty PrefixStats, objects are grouped by the first depth components of keys.
+// Objects with fewer components are counted under their parent directory, objects without directory under
+// empty prefix.
+func NewPrefixStats(depth uint) *PrefixStats {
+	if depth == 0 {
+		depth = 1
+	}
+	return &PrefixStats{depth: int(depth), counts: make(map[string]*PrefixCount)}
+}
+
+// Add count object under its prefix.
+func (s *PrefixStats) Add(obj *storage.Object) {
+	prefix := keyPrefix(*obj.Key, s.depth)
+	var size uint64
+	if obj.Content != nil {
+		size = uint64(len(*obj.Content))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counts[prefix]
+	if !ok {
+		c = &PrefixCount{Prefix: prefix}
+		s.counts[prefix] = c
+	}
+	c.Objects++
+	c.Bytes += size
+}
+
+// List return counts of all prefixes sorted by prefix.
+func (s *PrefixStats) List() []PrefixCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]PrefixCount, 0, len(s.counts))
+	for _, c := range s.counts {
+		res = append(res, *c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Prefix < res[j].Prefix })
+	return res
+}
+
+// keyPrefix return the first depth components of key directory with trailing slash.
+func keyPrefix(key string, depth int) string {
+	parts := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	parts = parts[:len(parts)-1]
+	if len(parts) == 0 {
+		return ""
+	}
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/") + "/"
+}
+
+// PrefixCounter read objects from input, count them by key prefixes and send object to next pipeline steps.
+//
+// This filter read configuration from Step.Config and assert it type to *PrefixStats type.
+var PrefixCounter pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*PrefixStats)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			cfg.Add(obj)
+			output <- obj
+		}
+	}
+}
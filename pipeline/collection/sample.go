@@ -0,0 +1,49 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// SampleConfig is the configuration of FilterObjectsBySample step.
+type SampleConfig struct {
+	// Rate is the fraction of passed objects, from 0 to 1.
+	Rate float64
+	// Seed selects the sample, the same seed always selects the same keys.
+	Seed int64
+}
+
+// SampleKey return pseudo-random number in [0.0,1.0) for given key and seed, like rand.Float64.
+// The number is computed from SHA-256 hash of the seed and the key, so it doesn't depend on the order of objects.
+func SampleKey(key string, seed int64) float64 {
+	buf := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(buf, uint64(seed))
+	sum := sha256.Sum256(append(buf, key...))
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53)
+}
+
+// FilterObjectsBySample accepts an input object and checks if it matches the filter.
+// This filter passes random sample of objects: objects with SampleKey of the key less than SampleConfig.Rate.
+// Objects are selected by key, so the same seed selects the same objects regardless of listing order and workers.
+//
+// This filter read configuration from Step.Config and assert it type to SampleConfig type.
+var FilterObjectsBySample pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(SampleConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if SampleKey(*obj.Key, cfg.Seed) < cfg.Rate {
+				output <- obj
+			}
+		}
+	}
+}
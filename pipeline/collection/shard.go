@@ -0,0 +1,68 @@
+package collection
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"math/bits"
+	"strings"
+)
+
+// KeyShardConfig is the configuration of KeyHashShard step.
+type KeyShardConfig struct {
+	// Shards is the number of shard prefixes.
+	Shards uint
+	// Prefix is the part of object keys which is kept before shard prefix, like the S3 source path.
+	Prefix string
+	// Reverse removes shard prefixes instead of adding them.
+	Reverse bool
+}
+
+// ShardKey return the shard prefix of given key.
+// The key is assigned to one of shards by its SHA-256 hash, prefix is a zero padded hex shard number.
+// With 256 shards the prefix is equal to the first two hex characters of the key hash.
+func ShardKey(key string, shards uint) string {
+	sum := sha256.Sum256([]byte(key))
+	shard, _ := bits.Mul64(binary.BigEndian.Uint64(sum[:8]), uint64(shards))
+	width := len(fmt.Sprintf("%x", shards-1))
+	return fmt.Sprintf("%0*x", width, shard)
+}
+
+// KeyHashShard read objects from input, add (or remove in reverse mode) shard prefix to its keys and send object to next pipeline steps.
+// The shard prefix is added after KeyShardConfig.Prefix, the shard is computed from the rest of the key.
+// In reverse mode objects with keys not matching its shard prefix raise an error.
+//
+// This filter read configuration from Step.Config and assert it type to KeyShardConfig type.
+var KeyHashShard pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(KeyShardConfig)
+	if !ok || cfg.Shards == 0 {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			rel := strings.TrimPrefix(strings.TrimPrefix(*obj.Key, cfg.Prefix), "/")
+			head := (*obj.Key)[:len(*obj.Key)-len(rel)]
+			if !cfg.Reverse {
+				key := head + ShardKey(rel, cfg.Shards) + "/" + rel
+				obj.Key = &key
+				output <- obj
+				continue
+			}
+			parts := strings.SplitN(rel, "/", 2)
+			if (len(parts) != 2) || (parts[0] != ShardKey(parts[1], cfg.Shards)) {
+				errChan <- fmt.Errorf("key %s has no valid shard prefix for %d shards", *obj.Key, cfg.Shards)
+				continue
+			}
+			key := head + parts[1]
+			obj.Key = &key
+			output <- obj
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"path/filepath"
+	"strings"
+)
+
+// StagingConfig is the configuration of CommitStagedObject step.
+type StagingConfig struct {
+	// StagingPath is the key prefix of staged objects.
+	StagingPath string
+	// TargetPath is the key prefix staged objects are moved to.
+	TargetPath string
+	// ACL and StorageClass override the values of staged objects if not empty.
+	ACL          string
+	StorageClass string
+}
+
+// CommitStagedObject read staged objects from input, move it from staging path to target path within Source storage
+// and send object to next pipeline steps.
+// Objects are moved with server-side copy and delete, so Source storage should implement storage.Copier.
+// This step read configuration from Step.Config and assert it type to StagingConfig type.
+var CommitStagedObject pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(StagingConfig)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	copier, ok := group.Source.(storage.Copier)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if cfg.ACL != "" {
+				obj.ACL = &cfg.ACL
+			}
+			if cfg.StorageClass != "" {
+				obj.StorageClass = &cfg.StorageClass
+			}
+			dstKey := filepath.Join(cfg.TargetPath, strings.TrimPrefix(*obj.Key, cfg.StagingPath))
+			ctx, cancel := group.ObjectContext()
+			err := copier.CopyObject(ctx, obj, dstKey)
+			if err == nil {
+				err = group.Source.DeleteObject(ctx, obj)
+			}
+			cancel()
+			if err != nil {
+				errChan <- err
+			} else {
+				output <- obj
+			}
+		}
+	}
+}
+
+// DeleteSourceObject read objects from input, delete its from Source storage and send object to next pipeline steps.
+var DeleteSourceObject pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			ctx, cancel := group.ObjectContext()
+			err := group.Source.DeleteObject(ctx, obj)
+			cancel()
+			if err != nil {
+				errChan <- err
+			} else {
+				output <- obj
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// LoadObjectTags accepts an input object and loads its tags with separate request, like S3 GetObjectTagging.
+// Tags are loaded once per object, objects with already loaded tags are passed as is,
+// so several tag filters share the same request.
+// Source storage should implement storage.TagGetter interface.
+var LoadObjectTags pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	getter, ok := group.Source.(storage.TagGetter)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if obj.Tags != nil {
+				output <- obj
+				continue
+			}
+			ctx, cancel := group.ObjectContext()
+			err := getter.GetObjectTags(ctx, obj)
+			cancel()
+			if err != nil {
+				traceObject(group, obj, err)
+				errChan <- err
+			} else {
+				output <- obj
+			}
+		}
+	}
+}
+
+// FilterObjectsByTag accepts an input object and checks if it matches the filter.
+// This filter skips objects without any of tags specified in the config, config maps tag key to accepted values.
+// Tag keys and values are compared exactly, tags should be loaded by LoadObjectTags step.
+//
+// This filter read configuration from Step.Config and assert it type to map[string][]string type.
+var FilterObjectsByTag pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(map[string][]string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if matchTags(obj.Tags, cfg) {
+				output <- obj
+			}
+		}
+	}
+}
+
+// FilterObjectsByTagNot accepts an input object and checks if it matches the filter.
+// This filter skips objects with any of tags specified in the config, config maps tag key to values.
+// Tag keys and values are compared exactly, tags should be loaded by LoadObjectTags step.
+//
+// This filter read configuration from Step.Config and assert it type to map[string][]string type.
+var FilterObjectsByTagNot pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(map[string][]string)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if !matchTags(obj.Tags, cfg) {
+				output <- obj
+			} else {
+				pipeline.Log.Debugf("Skip object %s with matched tags", *obj.Key)
+			}
+		}
+	}
+}
+
+// matchTags check if tags contain any of key=value pairs of filter.
+func matchTags(tags map[string]string, filter map[string][]string) bool {
+	for key, values := range filter {
+		tag, ok := tags[key]
+		if !ok {
+			continue
+		}
+		for _, val := range values {
+			if tag == val {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"sync/atomic"
+	"time"
+)
+
+// ThroughputStats counts objects and bytes passed through ThroughputLogger or Terminator step.
+type ThroughputStats struct {
+	objects uint64
+	bytes   uint64
+}
+
+// NewThroughputStats return new empty ThroughputStats.
+func NewThroughputStats() *ThroughputStats {
+	return &ThroughputStats{}
+}
+
+// Objects return the number of counted objects.
+func (s *ThroughputStats) Objects() uint64 {
+	return atomic.LoadUint64(&s.objects)
+}
+
+// Bytes return the total size of counted objects.
+func (s *ThroughputStats) Bytes() uint64 {
+	return atomic.LoadUint64(&s.bytes)
+}
+
+// add count object and its size, it return the object size.
+func (s *ThroughputStats) add(obj *storage.Object) uint64 {
+	var size uint64
+	if obj.Content != nil {
+		size = uint64(len(*obj.Content))
+	}
+	atomic.AddUint64(&s.objects, 1)
+	atomic.AddUint64(&s.bytes, size)
+	return size
+}
+
+// ThroughputLogger read objects from input, log object size and download throughput and send object to next pipeline steps.
+//
+// This filter read configuration from Step.Config and assert it type to *ThroughputStats type.
+var ThroughputLogger pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*ThroughputStats)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			size := cfg.add(obj)
+			pipeline.Log.WithField("key", *obj.Key).WithField("size", size).WithField("download", obj.Timings.Download).
+				Infof("Object throughput: %.f byte/s", Throughput(size, obj.Timings.Download))
+			output <- obj
+		}
+	}
+}
+
+// Throughput return bytes per second rate of transfer of given size and duration.
+func Throughput(size uint64, dur time.Duration) float64 {
+	if dur <= 0 {
+		return 0
+	}
+	return float64(size) / dur.Seconds()
+}
+
+// ByteBudget limits the total size of transferred objects, it can be shared between pipelines.
+type ByteBudget struct {
+	limit uint64
+	used  uint64
+}
+
+// NewByteBudget return new ByteBudget with given limit in bytes.
+func NewByteBudget(limit uint64) *ByteBudget {
+	return &ByteBudget{limit: limit}
+}
+
+// Limit return the budget limit in bytes.
+func (b *ByteBudget) Limit() uint64 {
+	return b.limit
+}
+
+// Used return the size of charged objects.
+func (b *ByteBudget) Used() uint64 {
+	return atomic.LoadUint64(&b.used)
+}
+
+// Exhausted check if charged size reached the limit.
+func (b *ByteBudget) Exhausted() bool {
+	return b.Used() >= b.limit
+}
+
+// FilterObjectsByByteBudget read objects from input and send it to next pipeline steps until the budget is exhausted.
+// Objects received after that are skipped.
+//
+// This filter read configuration from Step.Config and assert it type to *ByteBudget type.
+var FilterObjectsByByteBudget pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*ByteBudget)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if !cfg.Exhausted() {
+				output <- obj
+			}
+		}
+	}
+}
+
+// ChargeByteBudget read objects from input, charge its size to the budget and send object to next pipeline steps.
+//
+// This filter read configuration from Step.Config and assert it type to *ByteBudget type.
+var ChargeByteBudget pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*ByteBudget)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			if obj.Content != nil {
+				atomic.AddUint64(&cfg.used, uint64(len(*obj.Content)))
+			}
+			output <- obj
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// timingSamplesLimit is the max number of samples kept for every phase.
+// When limit is reached, samples are replaced with reservoir sampling.
+const timingSamplesLimit = 10000
+
+// TimingPhases contain names of object processing phases in the order they are reported.
+var TimingPhases = []string{"queue_wait", "source_ttfb", "download", "upload", "meta", "limiter_wait"}
+
+// TimingStats collects samples of object processing phases durations.
+type TimingStats struct {
+	mu      sync.Mutex
+	count   uint64
+	samples map[string][]time.Duration
+}
+
+// NewTimingStats return new empty TimingStats.
+func NewTimingStats() *TimingStats {
+	return &TimingStats{samples: make(map[string][]time.Duration, len(TimingPhases))}
+}
+
+// Add store object timings.
+func (s *TimingStats) Add(t storage.ObjectTimings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count++
+	for phase, dur := range timingFields(t) {
+		if len(s.samples[phase]) < timingSamplesLimit {
+			s.samples[phase] = append(s.samples[phase], dur.(time.Duration))
+		} else if i := rand.Int63n(int64(s.count)); i < timingSamplesLimit {
+			s.samples[phase][i] = dur.(time.Duration)
+		}
+	}
+}
+
+// Percentile return the p-th (0-100) percentile of given phase duration.
+func (s *TimingStats) Percentile(phase string, p float64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	samples := s.samples[phase]
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(samples))
+	copy(sorted, samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(p / 100 * float64(len(sorted)-1))
+	return sorted[idx]
+}
+
+func timingFields(t storage.ObjectTimings) logrus.Fields {
+	return logrus.Fields{
+		"queue_wait":   t.QueueWait,
+		"source_ttfb":  t.SourceTTFB,
+		"download":     t.Download,
+		"upload":       t.Upload,
+		"meta":         t.Meta,
+		"limiter_wait": t.LimiterWait,
+	}
+}
+
+// TimingLogger read objects from input, log object processing phases durations and send object to next pipeline steps.
+//
+// This filter read configuration from Step.Config and assert it type to *TimingStats type.
+var TimingLogger pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	cfg, ok := info.Config.(*TimingStats)
+	if !ok {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			cfg.Add(obj.Timings)
+			pipeline.Log.WithFields(timingFields(obj.Timings)).WithField("key", *obj.Key).Info("Object timings")
+			output <- obj
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package collection
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// traceObject create transfer span of the object if tracing enabled.
+// Span starts at the moment object was listed. Failed objects are always traced.
+func traceObject(group *pipeline.Group, obj *storage.Object, err error) {
+	if group.Tracer == nil {
+		return
+	}
+	span := group.Tracer.StartSampled("object", group.TraceSpan, obj.Timings.Listed)
+	span.SetAttr("object.key", *obj.Key)
+	if obj.Content != nil {
+		span.SetAttr("object.size", len(*obj.Content))
+	}
+	span.SetAttr("object.attempts", obj.Attempts)
+	if err != nil {
+		span.SetAttr("object.outcome", "failed")
+	} else {
+		span.SetAttr("object.outcome", "synced")
+	}
+	span.End(err)
+}
+
+// TraceObjects read objects from input, create its transfer spans and send object to next pipeline steps.
+// Errors of objects are traced by steps which produce them.
+var TraceObjects pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	for obj := range input {
+		select {
+		case <-group.Ctx.Done():
+			return
+		default:
+			traceObject(group, obj, nil)
+			output <- obj
+		}
+	}
+}
@@ -6,14 +6,34 @@ import (
 )
 
 // UploadObjectData read objects from input, put its content and meta to Target storage and send object to next pipeline steps.
+// Objects rejected by conditional upload of Target storage are skipped, see storage.PreconditionFailedError.
+//
+// This step read optional configuration from Step.Config and assert it type to *pipeline.WorkerGate type.
+// If gate is configured, it limits the number of concurrent uploads.
 var UploadObjectData pipeline.StepFn = func(group *pipeline.Group, stepNum int, input <-chan *storage.Object, output chan<- *storage.Object, errChan chan<- error) {
+	info := group.GetStepInfo(stepNum)
+	gate, ok := info.Config.(*pipeline.WorkerGate)
+	if !ok && info.Config != nil {
+		errChan <- &pipeline.StepConfigurationError{StepName: info.Name, StepNum: stepNum}
+		return
+	}
 	for obj := range input {
 		select {
 		case <-group.Ctx.Done():
 			return
 		default:
-			err := group.Target.PutObject(obj)
-			if err != nil {
+			start, ok := gate.Acquire()
+			if !ok {
+				return
+			}
+			ctx, cancel := group.ObjectContext()
+			err := group.Target.PutObject(ctx, obj)
+			cancel()
+			gate.Release(start)
+			if _, ok := err.(*storage.PreconditionFailedError); ok {
+				pipeline.Log.Debugf("Skip object: %s, target precondition failed", *obj.Key)
+			} else if err != nil {
+				traceObject(group, obj, err)
 				errChan <- err
 			} else {
 				output <- obj
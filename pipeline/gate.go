@@ -0,0 +1,133 @@
+package pipeline
+
+import (
+	"sync"
+	"time"
+)
+
+// WorkerGate limits the number of step workers processing objects at the same time.
+// The limit can be changed at runtime and the gate can be paused, all methods are safe to call on nil WorkerGate.
+// The gate should be closed when the pipeline finishes, so workers waiting in Acquire exit.
+type WorkerGate struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  uint
+	paused bool
+	closed bool
+	active uint
+	done   uint64
+	busy   time.Duration
+}
+
+// NewWorkerGate return new WorkerGate with given limit.
+func NewWorkerGate(limit uint) *WorkerGate {
+	gate := &WorkerGate{limit: limit}
+	gate.cond = sync.NewCond(&gate.mu)
+	return gate
+}
+
+// Acquire blocks until worker allowed to process an object or the gate is closed.
+// It return the time of acquiring which should be passed to Release, and false if the gate is closed,
+// then the worker should exit without processing the object.
+func (gate *WorkerGate) Acquire() (time.Time, bool) {
+	if gate == nil {
+		return time.Time{}, true
+	}
+	gate.mu.Lock()
+	for !gate.closed && (gate.paused || gate.active >= gate.limit) {
+		gate.cond.Wait()
+	}
+	if gate.closed {
+		gate.mu.Unlock()
+		return time.Time{}, false
+	}
+	gate.active++
+	gate.mu.Unlock()
+	return time.Now(), true
+}
+
+// Release marks object processing started at given time as finished.
+func (gate *WorkerGate) Release(start time.Time) {
+	if gate == nil {
+		return
+	}
+	gate.mu.Lock()
+	gate.active--
+	gate.done++
+	gate.busy += time.Since(start)
+	gate.mu.Unlock()
+	gate.cond.Broadcast()
+}
+
+// SetLimit change the number of concurrently working workers.
+func (gate *WorkerGate) SetLimit(limit uint) {
+	if gate == nil {
+		return
+	}
+	gate.mu.Lock()
+	gate.limit = limit
+	gate.mu.Unlock()
+	gate.cond.Broadcast()
+}
+
+// Pause stops admitting new objects, objects being processed are not affected.
+func (gate *WorkerGate) Pause() {
+	if gate == nil {
+		return
+	}
+	gate.mu.Lock()
+	gate.paused = true
+	gate.mu.Unlock()
+}
+
+// Resume restarts admitting objects after Pause.
+func (gate *WorkerGate) Resume() {
+	if gate == nil {
+		return
+	}
+	gate.mu.Lock()
+	gate.paused = false
+	gate.mu.Unlock()
+	gate.cond.Broadcast()
+}
+
+// Close stops admitting objects and wakes up waiting workers, even if the gate is paused.
+func (gate *WorkerGate) Close() {
+	if gate == nil {
+		return
+	}
+	gate.mu.Lock()
+	gate.closed = true
+	gate.mu.Unlock()
+	gate.cond.Broadcast()
+}
+
+// Active return the number of objects being processed.
+func (gate *WorkerGate) Active() uint {
+	if gate == nil {
+		return 0
+	}
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	return gate.active
+}
+
+// Limit return current limit.
+func (gate *WorkerGate) Limit() uint {
+	if gate == nil {
+		return 0
+	}
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	return gate.limit
+}
+
+// Stats return the number of processed objects and the total processing time.
+func (gate *WorkerGate) Stats() (done uint64, busy time.Duration) {
+	if gate == nil {
+		return 0, 0
+	}
+	gate.mu.Lock()
+	defer gate.mu.Unlock()
+	return gate.done, gate.busy
+}
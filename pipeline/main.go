@@ -4,8 +4,11 @@ package pipeline
 import (
 	"context"
 	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/tracing"
 	"github.com/sirupsen/logrus"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Log implement Logrus logger for debug logging.
@@ -13,12 +16,16 @@ var Log = logrus.New()
 
 // Group store a Source and Target storage's and pipeline configuration.
 type Group struct {
-	Source  storage.Storage
-	Target  storage.Storage
-	Ctx     context.Context
-	steps   []Step
-	errChan chan error
-	errWg   *sync.WaitGroup
+	Source     storage.Storage
+	Target     storage.Storage
+	Ctx        context.Context
+	Tracer     *tracing.Tracer
+	TraceSpan  *tracing.Span
+	objTimeout time.Duration
+	steps      []Step
+	errChan    chan error
+	errWg      *sync.WaitGroup
+	fwdWg      *sync.WaitGroup
 }
 
 // NewGroup return a new prepared Group.
@@ -27,6 +34,7 @@ func NewGroup() Group {
 	group := Group{
 		errChan: make(chan error),
 		errWg:   &sync.WaitGroup{},
+		fwdWg:   &sync.WaitGroup{},
 		Ctx:     context.Background(),
 		steps:   make([]Step, 0),
 	}
@@ -38,6 +46,29 @@ func (group *Group) WithContext(ctx context.Context) {
 	group.Ctx = ctx
 }
 
+// WithObjectTimeout set timeout of storage operations with single object, like download or upload.
+// Zero timeout means no limit.
+func (group *Group) WithObjectTimeout(timeout time.Duration) {
+	group.objTimeout = timeout
+}
+
+// ObjectContext return context for storage operation with single object derived from group context.
+// The context is limited with object timeout if it is configured.
+// Caller should call returned cancel function after operation is finished.
+func (group *Group) ObjectContext() (context.Context, context.CancelFunc) {
+	if group.objTimeout > 0 {
+		return context.WithTimeout(group.Ctx, group.objTimeout)
+	}
+	return context.WithCancel(group.Ctx)
+}
+
+// WithTracing add's tracer to group.
+// Spans of pipeline steps will be created as children of given span.
+func (group *Group) WithTracing(tracer *tracing.Tracer, span *tracing.Span) {
+	group.Tracer = tracer
+	group.TraceSpan = span
+}
+
 // SetSource configure source storage for group.
 func (group *Group) SetSource(st storage.Storage) {
 	group.Source = st
@@ -63,7 +94,7 @@ func (group *Group) AddPipeStep(step Step) {
 func (group *Group) GetStepsInfo() []StepInfo {
 	res := make([]StepInfo, len(group.steps))
 	for i := range group.steps {
-		res[i] = StepInfo{Stats: group.steps[i].stats,
+		res[i] = StepInfo{Stats: group.steps[i].stats.load(),
 			Name:   group.steps[i].Name,
 			Num:    i,
 			Config: group.steps[i].Config,
@@ -74,7 +105,7 @@ func (group *Group) GetStepsInfo() []StepInfo {
 
 // GetStepInfo return info about step with given sequential number.
 func (group *Group) GetStepInfo(stepNum int) StepInfo {
-	return StepInfo{Stats: group.steps[stepNum].stats,
+	return StepInfo{Stats: group.steps[stepNum].stats.load(),
 		Name:   group.steps[stepNum].Name,
 		Num:    stepNum,
 		Config: group.steps[stepNum].Config,
@@ -91,31 +122,46 @@ func (group *Group) Run() {
 		go func(i int) {
 			for e := range group.steps[i].errChan {
 				Log.Debugf("Recv pipeline err: %s", e)
-				group.steps[i].stats.Error += 1
+				atomic.AddUint64(&group.steps[i].stats.Error, 1)
 				group.errChan <- &PipelineError{StepName: group.steps[i].Name, StepNum: i, Err: e}
 			}
 			group.errWg.Done()
 		}(i)
 
+		group.fwdWg.Add(1)
 		go func(i int) {
+			defer group.fwdWg.Done()
 			for obj := range group.steps[i].intOutChan {
-				group.steps[i].stats.Output += 1
-				group.steps[i].outChan <- obj
+				atomic.AddUint64(&group.steps[i].stats.Output, 1)
+				// Output of the last step has no reader, objects are only counted.
+				if i+1 < len(group.steps) {
+					group.steps[i].outChan <- obj
+				}
 			}
 			close(group.steps[i].outChan)
 		}(i)
 
 		if i > 0 {
+			group.fwdWg.Add(1)
 			go func(i int) {
+				defer group.fwdWg.Done()
 				for obj := range group.steps[i-1].outChan {
-					group.steps[i].stats.Input += 1
-					group.steps[i].intInChan <- obj
+					atomic.AddUint64(&group.steps[i].stats.Input, 1)
+					// Workers of canceled group may exit without reading their input,
+					// objects are discarded then, so previous steps are not blocked.
+					select {
+					case group.steps[i].intInChan <- obj:
+					case <-group.Ctx.Done():
+					}
 				}
 				close(group.steps[i].intInChan)
 			}(i)
 		}
 
 		go func(i int) {
+			span := group.Tracer.Start(group.steps[i].Name, group.TraceSpan)
+			span.SetAttr("step.num", i)
+			span.SetAttr("step.workers", group.steps[i].AddWorkers+1)
 			for w := uint(0); w <= group.steps[i].AddWorkers; w++ {
 				group.steps[i].workerWg.Add(1)
 				go func(i int) {
@@ -129,12 +175,19 @@ func (group *Group) Run() {
 			}
 
 			group.steps[i].workerWg.Wait()
+			stats := group.steps[i].stats.load()
+			span.SetAttr("step.input", stats.Input)
+			span.SetAttr("step.output", stats.Output)
+			span.SetAttr("step.errors", stats.Error)
+			span.End(nil)
 			Log.Debugf("Pipeline step: %s finished", group.steps[i].Name)
 			close(group.steps[i].intOutChan)
 			close(group.steps[i].errChan)
 			if i+1 == len(group.steps) {
 				Log.Debugf("All pipeline steps finished")
 				group.errWg.Wait()
+				// Stats are complete when the nil message is received.
+				group.fwdWg.Wait()
 				group.errChan <- nil
 				close(group.errChan)
 			}
@@ -3,6 +3,7 @@ package pipeline
 import (
 	"github.com/larrabee/s3sync/storage"
 	"sync"
+	"sync/atomic"
 )
 
 //StepFn implement the type of pipeline Step function.
@@ -32,6 +33,15 @@ type StepStats struct {
 	Error  uint64
 }
 
+// load return copy of stats updated by running pipeline.
+func (stats *StepStats) load() StepStats {
+	return StepStats{
+		Input:  atomic.LoadUint64(&stats.Input),
+		Output: atomic.LoadUint64(&stats.Output),
+		Error:  atomic.LoadUint64(&stats.Error),
+	}
+}
+
 // StepInfo is used to represent step information, statistic and the step configuration interface.
 type StepInfo struct {
 	Stats  StepStats
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/larrabee/ratelimit"
+	"math/bits"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DedupChunkDir is the directory under S3 storage prefix with chunks of deduplicated objects,
+// chunk with SHA-256 hash "<hash>" is stored as ".s3sync-chunks/<hash>". The directory is never listed
+// and keys in it can't be written.
+const DedupChunkDir = ".s3sync-chunks"
+
+// Metadata keys of chunk manifests written by DedupStorage.
+const (
+	// DedupManifestMetaKey marks manifest objects, the value is the manifest format version.
+	DedupManifestMetaKey = "S3sync-Dedup-Manifest"
+	// DedupSizeMetaKey is the size of the original object.
+	DedupSizeMetaKey = "S3sync-Dedup-Size"
+	// DedupETagMetaKey is the ETag of the original object, if it is known.
+	DedupETagMetaKey = "S3sync-Dedup-Etag"
+)
+
+// Limits of average chunk size of DedupStorage, the size should be a power of two.
+const (
+	DedupMinChunkSize = 64 << 10
+	DedupMaxChunkSize = 64 << 20
+)
+
+const dedupManifestVersion = 1
+
+// dedupManifest is the content of manifest object, it lists chunks of the original object in order.
+type dedupManifest struct {
+	Version int          `json:"version"`
+	Size    int64        `json:"size"`
+	Chunks  []dedupChunk `json:"chunks"`
+}
+
+type dedupChunk struct {
+	Hash string `json:"sha256"`
+	Size int64  `json:"size"`
+}
+
+// DedupStats contain counters of chunks written by DedupStorage.
+type DedupStats struct {
+	// Chunks and Bytes are the number and size of all chunks of written objects.
+	Chunks uint64
+	Bytes  uint64
+	// NewChunks and NewBytes are the number and size of uploaded chunks, other chunks were already stored.
+	NewChunks uint64
+	NewBytes  uint64
+}
+
+// DedupStorage is S3 storage with block-level deduplication. Objects are split into content-defined chunks,
+// every unique chunk is uploaded once to DedupChunkDir and the object key stores JSON manifest with chunk
+// references instead of the content. Reading manifests reassembles the original content and metadata,
+// other objects are read as is.
+//
+// Chunks are never deleted, deleting or overwriting objects leaves unreferenced chunks in the bucket.
+type DedupStorage struct {
+	// stats is the first field for 64-bit alignment of atomic counters on 32-bit platforms.
+	stats   DedupStats
+	st      *S3Storage
+	avgSize int
+	mask    uint64
+
+	mu    sync.Mutex
+	index map[string]struct{}
+}
+
+// NewDedupStorage return new DedupStorage storing objects of st split into chunks of avgSize bytes on average.
+// avgSize should be a power of two between DedupMinChunkSize and DedupMaxChunkSize.
+//
+// You should always create new storage with this constructor.
+func NewDedupStorage(st *S3Storage, avgSize int) *DedupStorage {
+	// Boundaries are detected by the high bits of gear hash, they depend on more content bytes than low bits.
+	maskBits := uint(bits.Len(uint(avgSize)) - 1)
+	return &DedupStorage{
+		st:      st,
+		avgSize: avgSize,
+		mask:    ((uint64(1) << maskBits) - 1) << (64 - maskBits),
+		index:   make(map[string]struct{}),
+	}
+}
+
+// WithRateLimit set rate limit (bytes/sec) for chunk and manifest transfers.
+func (storage *DedupStorage) WithRateLimit(limit int) error {
+	return storage.st.WithRateLimit(limit)
+}
+
+// WithRateLimitBucket set rate limit bucket for chunk and manifest transfers.
+func (storage *DedupStorage) WithRateLimitBucket(bucket ratelimit.Bucket) {
+	storage.st.WithRateLimitBucket(bucket)
+}
+
+// chunkPrefix return the full key prefix of chunks.
+func (storage *DedupStorage) chunkPrefix() string {
+	return filepath.Join(storage.st.prefix, DedupChunkDir) + "/"
+}
+
+// LoadChunkIndex list stored chunks, so PutObject uploads only missing chunks, and return the number of chunks.
+// Without the index every chunk is uploaded once per job.
+func (storage *DedupStorage) LoadChunkIndex(ctx context.Context) (int, error) {
+	ch := make(chan *Object, 1000)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- storage.st.listPrefix(ctx, storage.chunkPrefix(), ch)
+		close(ch)
+	}()
+	var count int
+	for obj := range ch {
+		storage.mu.Lock()
+		storage.index[path.Base(*obj.Key)] = struct{}{}
+		storage.mu.Unlock()
+		count++
+	}
+	return count, <-errChan
+}
+
+// List S3 bucket and send founded objects and manifests to chan, chunks are skipped.
+// Sizes and ETags of listed manifests are those of manifest objects.
+func (storage *DedupStorage) List(ctx context.Context, output chan<- *Object) error {
+	ch := make(chan *Object, cap(output))
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- storage.st.List(ctx, ch)
+		close(ch)
+	}()
+	chunkPrefix := storage.chunkPrefix()
+	for obj := range ch {
+		if strings.HasPrefix(*obj.Key, chunkPrefix) {
+			continue
+		}
+		select {
+		case output <- obj:
+		case <-ctx.Done():
+			// Drain the channel, the listing is stopped by ctx.
+		}
+	}
+	return <-errChan
+}
+
+// PutObject split object content into chunks, upload missing chunks and save the manifest to object key.
+func (storage *DedupStorage) PutObject(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	defer func() { obj.Timings.Upload = time.Since(start) }()
+	key := filepath.ToSlash(filepath.Clean(*obj.Key))
+	if (key == DedupChunkDir) || strings.HasPrefix(key, DedupChunkDir+"/") {
+		return fmt.Errorf("can't write %s, %s dir is reserved for chunks", *obj.Key, DedupChunkDir)
+	}
+	var content []byte
+	if obj.Content != nil {
+		content = *obj.Content
+	}
+
+	manifest := dedupManifest{Version: dedupManifestVersion, Size: int64(len(content))}
+	for _, chunk := range splitChunks(content, storage.avgSize, storage.mask) {
+		sum := sha256.Sum256(chunk)
+		hash := hex.EncodeToString(sum[:])
+		manifest.Chunks = append(manifest.Chunks, dedupChunk{Hash: hash, Size: int64(len(chunk))})
+		atomic.AddUint64(&storage.stats.Chunks, 1)
+		atomic.AddUint64(&storage.stats.Bytes, uint64(len(chunk)))
+		if storage.hasChunk(hash) {
+			continue
+		}
+
+		chunk := chunk
+		chunkObj := &Object{
+			Key:          aws.String(path.Join(DedupChunkDir, hash)),
+			Content:      &chunk,
+			ContentType:  aws.String("application/octet-stream"),
+			ACL:          obj.ACL,
+			StorageClass: obj.StorageClass,
+		}
+		err := storage.st.PutObject(ctx, chunkObj)
+		obj.Timings.LimiterWait += chunkObj.Timings.LimiterWait
+		if err != nil {
+			return fmt.Errorf("chunk %s of %s: %s", hash, *obj.Key, err)
+		}
+		storage.mu.Lock()
+		storage.index[hash] = struct{}{}
+		storage.mu.Unlock()
+		atomic.AddUint64(&storage.stats.NewChunks, 1)
+		atomic.AddUint64(&storage.stats.NewBytes, uint64(len(chunk)))
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestObj := *obj
+	manifestObj.Content = &data
+	manifestObj.Metadata = make(map[string]*string, len(obj.Metadata)+3)
+	for k, v := range obj.Metadata {
+		manifestObj.Metadata[k] = v
+	}
+	manifestObj.Metadata[DedupManifestMetaKey] = aws.String(strconv.Itoa(dedupManifestVersion))
+	manifestObj.Metadata[DedupSizeMetaKey] = aws.String(strconv.FormatInt(manifest.Size, 10))
+	if obj.ETag != nil {
+		manifestObj.Metadata[DedupETagMetaKey] = obj.ETag
+	}
+	err = storage.st.PutObject(ctx, &manifestObj)
+	obj.Attempts = manifestObj.Attempts
+	obj.Timings.LimiterWait = manifestObj.Timings.LimiterWait
+	return err
+}
+
+// hasChunk check if chunk with given hash is stored.
+func (storage *DedupStorage) hasChunk(hash string) bool {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	_, ok := storage.index[hash]
+	return ok
+}
+
+// GetObjectContent read object content and metadata from S3, content of manifests is reassembled from chunks.
+func (storage *DedupStorage) GetObjectContent(ctx context.Context, obj *Object) error {
+	if err := storage.st.GetObjectContent(ctx, obj); err != nil {
+		return err
+	}
+	if metaValue(obj.Metadata, DedupManifestMetaKey) == "" {
+		return nil
+	}
+
+	var manifest dedupManifest
+	if err := json.Unmarshal(*obj.Content, &manifest); err != nil {
+		return fmt.Errorf("invalid chunk manifest of %s: %s", *obj.Key, err)
+	}
+	if manifest.Version != dedupManifestVersion {
+		return fmt.Errorf("unsupported chunk manifest version %d of %s", manifest.Version, *obj.Key)
+	}
+	data := make([]byte, 0, manifest.Size)
+	for _, c := range manifest.Chunks {
+		if _, err := hex.DecodeString(c.Hash); (err != nil) || (len(c.Hash) != sha256.Size*2) {
+			return fmt.Errorf("invalid chunk hash %q in manifest of %s", c.Hash, *obj.Key)
+		}
+		chunkObj := &Object{Key: aws.String(storage.chunkPrefix() + c.Hash)}
+		if err := storage.st.GetObjectContent(ctx, chunkObj); err != nil {
+			return fmt.Errorf("chunk %s of %s: %s", c.Hash, *obj.Key, err)
+		}
+		if sum := sha256.Sum256(*chunkObj.Content); hex.EncodeToString(sum[:]) != c.Hash {
+			return fmt.Errorf("chunk %s of %s is corrupted, SHA-256 mismatch", c.Hash, *obj.Key)
+		}
+		data = append(data, *chunkObj.Content...)
+	}
+	if int64(len(data)) != manifest.Size {
+		return fmt.Errorf("reassembled %s has size %d, expected %d", *obj.Key, len(data), manifest.Size)
+	}
+	obj.Content = &data
+	storage.restoreMeta(obj, false)
+	return nil
+}
+
+// GetObjectMeta read object metadata from S3, size and ETag of manifests are those of the original object,
+// so manifests in target can be compared with source objects.
+func (storage *DedupStorage) GetObjectMeta(ctx context.Context, obj *Object) error {
+	if err := storage.st.GetObjectMeta(ctx, obj); err != nil {
+		return err
+	}
+	if metaValue(obj.Metadata, DedupManifestMetaKey) != "" {
+		storage.restoreMeta(obj, true)
+	}
+	return nil
+}
+
+// restoreMeta set size of manifest object to the original size and remove dedup metadata keys.
+// If origETag is set, ETag is set to the original ETag too, otherwise the manifest ETag is kept, so objects
+// reassembled to target can be compared with the source listing.
+func (storage *DedupStorage) restoreMeta(obj *Object, origETag bool) {
+	if size, err := strconv.ParseInt(metaValue(obj.Metadata, DedupSizeMetaKey), 10, 64); err == nil {
+		obj.Size = &size
+	}
+	if origETag {
+		obj.ETag = nil
+		if etag := metaValue(obj.Metadata, DedupETagMetaKey); etag != "" {
+			obj.ETag = &etag
+		}
+	}
+	for k := range obj.Metadata {
+		if strings.EqualFold(k, DedupManifestMetaKey) || strings.EqualFold(k, DedupSizeMetaKey) || strings.EqualFold(k, DedupETagMetaKey) {
+			delete(obj.Metadata, k)
+		}
+	}
+}
+
+// DeleteObject delete object or manifest from S3, its chunks are kept.
+func (storage *DedupStorage) DeleteObject(ctx context.Context, obj *Object) error {
+	return storage.st.DeleteObject(ctx, obj)
+}
+
+// GetStorageType return storage type.
+func (storage *DedupStorage) GetStorageType() Type {
+	return TypeS3
+}
+
+// Stats return chunk counters of written objects.
+func (storage *DedupStorage) Stats() DedupStats {
+	return DedupStats{
+		Chunks:    atomic.LoadUint64(&storage.stats.Chunks),
+		Bytes:     atomic.LoadUint64(&storage.stats.Bytes),
+		NewChunks: atomic.LoadUint64(&storage.stats.NewChunks),
+		NewBytes:  atomic.LoadUint64(&storage.stats.NewBytes),
+	}
+}
+
+// metaValue return value of metadata key, S3 returns keys in canonical form, so keys are compared case-insensitively.
+func metaValue(meta map[string]*string, key string) string {
+	for k, v := range meta {
+		if strings.EqualFold(k, key) && (v != nil) {
+			return *v
+		}
+	}
+	return ""
+}
+
+// gearTable is the table of gear hash used to find chunk boundaries, it is generated from fixed seed
+// with splitmix64. Changing it changes all chunk boundaries, so stored chunks would not be reused.
+var gearTable = func() (table [256]uint64) {
+	seed := uint64(0x5333796e63446564)
+	for i := range table {
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return
+}()
+
+// splitChunks split data into content-defined chunks with gear hash: chunk ends after the byte at which
+// the hash matches mask. Chunks are between avgSize/4 and avgSize*4 bytes, except the last one.
+// Since boundaries depend only on nearby content, inserts and deletes change only adjacent chunks.
+func splitChunks(data []byte, avgSize int, mask uint64) [][]byte {
+	minSize, maxSize := avgSize/4, avgSize*4
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := len(data)
+		if n > minSize {
+			if n > maxSize {
+				n = maxSize
+			}
+			var hash uint64
+			for i := minSize; i < n; i++ {
+				hash = (hash << 1) + gearTable[data[i]]
+				if hash&mask == 0 {
+					n = i + 1
+					break
+				}
+			}
+		}
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+	return chunks
+}
@@ -0,0 +1,193 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Hardlink handling modes of FS storage.
+const (
+	// HardlinksCopy read every link of a file as independent object. It is the default mode.
+	HardlinksCopy = "copy"
+	// HardlinksPreserve read the first link of a file as usual and other links as empty objects with the key
+	// of the first link in HardlinkMetaKey metadata, objects with the metadata are written as hardlinks.
+	HardlinksPreserve = "preserve"
+)
+
+// HardlinkMetaKey is the user metadata key of the first read link of a hardlinked file. The first link has
+// its own key in the metadata.
+const HardlinkMetaKey = "S3sync-Hardlink"
+
+// fileID identify a file on the host by device and inode.
+type fileID struct {
+	dev, ino uint64
+}
+
+// hardlinkFile is the first read link of a file and the number of its links which are not read yet.
+type hardlinkFile struct {
+	key  string
+	left uint64
+}
+
+// hardlinkState is the hardlink preservation state of FS storage.
+type hardlinkState struct {
+	mu sync.Mutex
+	// files map read files with multiple links to their first links, files are removed when all links are read.
+	files map[fileID]*hardlinkFile
+	// links map keys of first links to keys of other links written in this run.
+	links map[string][]string
+}
+
+// WithHardlinks set hardlink handling mode, one of Hardlinks* constants. HardlinksCopy is used by default.
+// Only files with multiple links are tracked, so memory usage doesn't depend on the number of other files.
+func (storage *FSStorage) WithHardlinks(mode string) {
+	if mode != HardlinksPreserve {
+		storage.links = nil
+		return
+	}
+	storage.links = &hardlinkState{
+		files: make(map[fileID]*hardlinkFile),
+		links: make(map[string][]string),
+	}
+}
+
+// hardlinkOf return key of the first read link of the file with given stat, it return empty string if
+// hardlinks are not preserved or the file has a single link.
+func (storage *FSStorage) hardlinkOf(stat os.FileInfo, key string) string {
+	if storage.links == nil {
+		return ""
+	}
+	id, nlink, ok := fileLinks(stat)
+	if !ok || (nlink < 2) {
+		return ""
+	}
+	state := storage.links
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	file, ok := state.files[id]
+	if !ok {
+		state.files[id] = &hardlinkFile{key: key, left: nlink - 1}
+		return key
+	}
+	if file.key == key {
+		// The first link is read again, like on retry.
+		return key
+	}
+	if file.left--; file.left == 0 {
+		delete(state.files, id)
+	}
+	return file.key
+}
+
+// setHardlinkMeta set HardlinkMetaKey metadata of read object to the first link key, stale metadata of files
+// which have a single link now is removed.
+func setHardlinkMeta(obj *Object, first string) {
+	for key := range obj.Metadata {
+		if strings.EqualFold(key, HardlinkMetaKey) {
+			delete(obj.Metadata, key)
+		}
+	}
+	if first == "" {
+		return
+	}
+	if obj.Metadata == nil {
+		obj.Metadata = make(map[string]*string, 1)
+	}
+	obj.Metadata[HardlinkMetaKey] = &first
+}
+
+// hardlinkTarget return key of the first link of hardlink object.
+func hardlinkTarget(obj *Object) (string, bool) {
+	for key, val := range obj.Metadata {
+		if strings.EqualFold(key, HardlinkMetaKey) && (val != nil) && (*val != "") {
+			return *val, true
+		}
+	}
+	return "", false
+}
+
+// putHardlink write the key as a hardlink of the first link. If the first link is not written yet, the link
+// is created when it is written, so the order of writes doesn't matter.
+func (storage *FSStorage) putHardlink(key, first string) error {
+	clean := filepath.ToSlash(filepath.Clean(first))
+	if filepath.IsAbs(first) || (clean == "..") || strings.HasPrefix(clean, "../") || isSidecarKey(clean) {
+		return fmt.Errorf("can't link %s, invalid hardlink target %q", key, first)
+	}
+	state := storage.links
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.links[first] = append(state.links[first], key)
+	firstPath := filepath.Join(storage.dir, first)
+	if stat, err := os.Lstat(firstPath); os.IsNotExist(err) {
+		Log.Debugf("Hardlink %s is waiting for %s", key, first)
+		return nil
+	} else if err != nil {
+		return err
+	} else if !stat.Mode().IsRegular() {
+		return fmt.Errorf("can't link %s, hardlink target %s is not a regular file", key, first)
+	}
+	return storage.link(firstPath, key)
+}
+
+// relinkHardlinks link keys of other links written before the first link obj, since they point to the old
+// file or don't exist.
+func (storage *FSStorage) relinkHardlinks(obj *Object) error {
+	first, ok := hardlinkTarget(obj)
+	if !ok || (first != *obj.Key) {
+		return nil
+	}
+	state := storage.links
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	firstPath := filepath.Join(storage.dir, first)
+	for _, key := range state.links[first] {
+		if err := storage.link(firstPath, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// link atomically replace the key with a hardlink of file at path, the key is not changed if it is the same file.
+func (storage *FSStorage) link(path, key string) error {
+	destPath := filepath.Join(storage.dir, key)
+	if err := os.MkdirAll(filepath.Dir(destPath), storage.dirPerm); err != nil {
+		return err
+	}
+	src, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if dest, err := os.Lstat(destPath); (err == nil) && os.SameFile(src, dest) {
+		return nil
+	}
+
+	suffix := make([]byte, 8)
+	var tmp string
+	for i := 0; ; i++ {
+		if _, err := rand.Read(suffix); err != nil {
+			return err
+		}
+		tmp = filepath.Join(filepath.Dir(destPath), TempFilePrefix+hex.EncodeToString(suffix))
+		err := os.Link(path, tmp)
+		if os.IsExist(err) && (i < 10) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		break
+	}
+	if err := os.Rename(tmp, destPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if storage.fsync {
+		return syncDir(filepath.Dir(destPath))
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultIgnoreFile is the default name of ignore files of FS storage listing.
+const DefaultIgnoreFile = ".s3syncignore"
+
+// ignoreRule is a pattern of ignore file in gitignore syntax.
+type ignoreRule struct {
+	// segments is the pattern split by slashes, "**" segment matches any number of path elements.
+	segments []string
+	// negate re-includes matched paths excluded by previous rules.
+	negate bool
+	// dirOnly matches directories only.
+	dirOnly bool
+	// anchored patterns are matched against the path relative to the ignore file dir,
+	// other patterns are matched against the last path element.
+	anchored bool
+}
+
+// WithIgnoreFile enable ignore files with given name in listed dirs, empty name disables them.
+// Ignore files use gitignore syntax: glob patterns, "**", negation with "!" and directory-only patterns
+// with trailing "/". Rules apply to the dir of the ignore file and its subdirs, rules of deeper dirs take
+// precedence. Ignored dirs are not walked.
+func (storage *FSStorage) WithIgnoreFile(name string) {
+	storage.ignoreFile = name
+}
+
+// parseIgnoreRules parse content of ignore file, blank lines and comments are skipped.
+func parseIgnoreRules(data string) []ignoreRule {
+	var rules []ignoreRule
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if !strings.HasSuffix(line, `\ `) {
+			line = strings.TrimRight(line, " ")
+		}
+		if (line == "") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule ignoreRule
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		rule.anchored = strings.Contains(line, "/")
+		rule.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// match check if path relative to the ignore file dir matches the rule.
+func (rule ignoreRule) match(rel string, dir bool) bool {
+	if rule.dirOnly && !dir {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	if !rule.anchored {
+		ok, _ := path.Match(rule.segments[0], parts[len(parts)-1])
+		return ok
+	}
+	return matchSegments(rule.segments, parts)
+}
+
+// matchSegments match path elements with pattern segments, "**" matches zero or more elements,
+// trailing "**" matches one or more elements.
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			if len(pattern) == 1 {
+				return len(parts) > 0
+			}
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// loadIgnoreFile read rules of ignore file of dir with given key prefix ("" for the storage dir or "dir/").
+// Missing ignore file is not an error, it has no rules.
+func (storage *FSStorage) loadIgnoreFile(prefix string) ([]ignoreRule, error) {
+	data, err := ioutil.ReadFile(filepath.Join(storage.dir, prefix, storage.ignoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return parseIgnoreRules(string(data)), nil
+}
+
+// ignored check if key is excluded by rules of ignore files of its parent dirs. The last matched rule of
+// the deepest dir wins.
+func ignored(rules map[string][]ignoreRule, key string, dir bool) bool {
+	i := len(key)
+	for {
+		i = strings.LastIndex(key[:i], "/")
+		prefix := key[:i+1]
+		list := rules[prefix]
+		for j := len(list) - 1; j >= 0; j-- {
+			if list[j].match(key[len(prefix):], dir) {
+				return !list[j].negate
+			}
+		}
+		if i < 0 {
+			return false
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// InvalidKeyError raises when FS storage can't write an object, since its key is not a valid file path on the OS.
+type InvalidKeyError struct {
+	Key    string
+	Reason string
+}
+
+func (e *InvalidKeyError) Error() string {
+	return fmt.Sprintf("object: %s can't be written, invalid file name: %s", e.Key, e.Reason)
+}
+
+// CaseConflictError raises when FS storage on case-insensitive filesystem writes an object with key differing
+// only by case from the key of other object written by the sync, so the file of other object would be overwritten.
+type CaseConflictError struct {
+	Key      string
+	OtherKey string
+}
+
+func (e *CaseConflictError) Error() string {
+	return fmt.Sprintf("object: %s can't be written, it conflicts with object %s on case-insensitive filesystem", e.Key, e.OtherKey)
+}
+
+// windowsReserved are device names, which can't be used as file names on Windows with any extension.
+var windowsReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// windowsKeyError return the reason why the key can't be written as a file on Windows, or empty string if it can.
+// Path elements can't be reserved device names, like "NUL" or "con.txt", can't end with dot or space and can't
+// contain control characters and `<>:"|?*\` characters.
+func windowsKeyError(key string) string {
+	for _, name := range strings.Split(key, "/") {
+		if (name == "") || (name == ".") || (name == "..") {
+			continue
+		}
+		if i := strings.IndexFunc(name, func(r rune) bool { return (r < 32) || strings.ContainsRune(`<>:"|?*\`, r) }); i >= 0 {
+			return fmt.Sprintf("%q contains character %q", name, name[i])
+		}
+		if strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ") {
+			return fmt.Sprintf("%q ends with dot or space", name)
+		}
+		base := strings.TrimRight(strings.SplitN(name, ".", 2)[0], " ")
+		if windowsReserved[strings.ToUpper(base)] {
+			return fmt.Sprintf("%q is a reserved device name", name)
+		}
+	}
+	return ""
+}
+
+// caseKeys records keys written to case-insensitive filesystem by lower case.
+type caseKeys struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+// claim record written key, it return CaseConflictError if other key differing only by case is already written.
+// Keys are kept in memory during the sync.
+func (c *caseKeys) claim(key string) error {
+	lower := strings.ToLower(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if other, ok := c.keys[lower]; ok && (other != key) {
+		return &CaseConflictError{Key: key, OtherKey: other}
+	}
+	c.keys[lower] = key
+	return nil
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Metadata keys of file owner stored by FS storage with WithPreserveOwner.
+const (
+	OwnerUIDMetaKey   = "S3sync-Uid"
+	OwnerGIDMetaKey   = "S3sync-Gid"
+	OwnerUserMetaKey  = "S3sync-User"
+	OwnerGroupMetaKey = "S3sync-Group"
+)
+
+// OwnerMap remap owners of files written to FS storage, it maps stored user and group names or numeric IDs
+// to local names or IDs, like "www-data" to "nginx" or "1000" to "2000".
+type OwnerMap map[string]string
+
+// ParseOwnerMap parse comma separated owner map entries FROM:TO, like "1000:2000,www-data:nginx".
+func ParseOwnerMap(s string) (OwnerMap, error) {
+	m := make(OwnerMap)
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if (len(parts) != 2) || (parts[0] == "") || (parts[1] == "") {
+			return nil, fmt.Errorf("invalid entry %q, expected format: FROM:TO", entry)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// resolve return local ID of owner with stored name and numeric ID, lookup return local ID of given name.
+// Mapped names are preferred to mapped IDs, then the stored name is looked up and the stored ID is used as is.
+func (m OwnerMap) resolve(name, id string, lookup func(string) (string, error)) (int, bool) {
+	local := func(v string) (int, bool) {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, true
+		}
+		if v, err := lookup(v); err == nil {
+			n, err := strconv.Atoi(v)
+			return n, err == nil
+		}
+		return 0, false
+	}
+	if to, ok := m[name]; ok && (name != "") {
+		return local(to)
+	}
+	if to, ok := m[id]; ok && (id != "") {
+		return local(to)
+	}
+	if name != "" {
+		if v, err := lookup(name); err == nil {
+			return local(v)
+		}
+	}
+	if id != "" {
+		return local(id)
+	}
+	return 0, false
+}
+
+// ownerState is the owner preservation state of FS storage.
+type ownerState struct {
+	ownerMap OwnerMap
+	strict   bool
+	// names caches user and group names by "u"/"g" and numeric ID.
+	names sync.Map
+	// chownFailed logs the first chown failure in non-strict mode.
+	chownFailed sync.Once
+}
+
+// WithPreserveOwner enable preservation of file owners: owner UID, GID, user and group names are added
+// to Owner*MetaKey metadata of read objects and written files are chowned to the owner from the metadata,
+// remapped with ownerMap. Chown requires root or CAP_CHOWN, in strict mode chown failures are object errors,
+// otherwise the first failure is logged as warning and owners are not set. Owners are not preserved on Windows.
+func (storage *FSStorage) WithPreserveOwner(enabled bool, ownerMap OwnerMap, strict bool) {
+	if !enabled || !ownerSupported {
+		storage.owner = nil
+		return
+	}
+	storage.owner = &ownerState{ownerMap: ownerMap, strict: strict}
+}
+
+// readOwner add owner of file to object metadata.
+func (storage *FSStorage) readOwner(stat os.FileInfo, obj *Object) {
+	uid, gid, ok := fileOwner(stat)
+	if !ok {
+		return
+	}
+	if obj.Metadata == nil {
+		obj.Metadata = make(map[string]*string, 4)
+	}
+	uidStr, gidStr := strconv.Itoa(uid), strconv.Itoa(gid)
+	obj.Metadata[OwnerUIDMetaKey], obj.Metadata[OwnerGIDMetaKey] = &uidStr, &gidStr
+	if name, ok := storage.owner.name("u", uidStr, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	}); ok {
+		obj.Metadata[OwnerUserMetaKey] = &name
+	}
+	if name, ok := storage.owner.name("g", gidStr, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	}); ok {
+		obj.Metadata[OwnerGroupMetaKey] = &name
+	}
+}
+
+// name return cached user or group name of numeric ID, unknown IDs are cached too.
+func (s *ownerState) name(kind, id string, lookup func(string) (string, error)) (string, bool) {
+	if name, ok := s.names.Load(kind + id); ok {
+		return name.(string), name.(string) != ""
+	}
+	name, err := lookup(id)
+	if err != nil {
+		name = ""
+	}
+	s.names.Store(kind+id, name)
+	return name, name != ""
+}
+
+// writeOwner chown written file f to the owner from object metadata, objects without owner metadata are skipped.
+func (storage *FSStorage) writeOwner(f *os.File, obj *Object) error {
+	meta := func(key string) string {
+		for k, v := range obj.Metadata {
+			if strings.EqualFold(k, key) && (v != nil) {
+				return *v
+			}
+		}
+		return ""
+	}
+	s := storage.owner
+	uid, uidOk := s.ownerMap.resolve(meta(OwnerUserMetaKey), meta(OwnerUIDMetaKey), func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	gid, gidOk := s.ownerMap.resolve(meta(OwnerGroupMetaKey), meta(OwnerGIDMetaKey), func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if !uidOk && !gidOk {
+		return nil
+	}
+	if !uidOk {
+		uid = -1
+	}
+	if !gidOk {
+		gid = -1
+	}
+
+	err := f.Chown(uid, gid)
+	if (err == nil) || s.strict {
+		return err
+	}
+	s.chownFailed.Do(func() {
+		Log.Warnf("Setting owner of %s failed with error: %s, owners of files are not preserved, run as root or with CAP_CHOWN", *obj.Key, err)
+	})
+	return nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"github.com/karrick/godirwalk"
+	"path/filepath"
+	"sync"
+)
+
+// WithListWorkers set the number of goroutines walking dirs on listing, values greater than 1 enable parallel listing.
+// Dirs are walked in parallel, so objects are listed in no particular order. It is ignored with sorted listing,
+// see WithSorted.
+func (storage *FSStorage) WithListWorkers(workers int) {
+	storage.listWorkers = workers
+}
+
+// dirQueue is the work queue of dirs of parallel listing.
+type dirQueue struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	dirs []string
+	// pending is the number of dirs queued or being walked, the walk is complete when it is zero.
+	pending int
+	err     error
+}
+
+// push add dir to the queue.
+func (q *dirQueue) push(dir string) {
+	q.mu.Lock()
+	q.dirs = append(q.dirs, dir)
+	q.pending++
+	q.mu.Unlock()
+	q.cond.Signal()
+}
+
+// pop wait for the next dir, it returns false when the walk is complete or failed.
+// The last queued dir is returned, so the tree is walked mostly depth-first and the queue stays small.
+func (q *dirQueue) pop() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for (len(q.dirs) == 0) && (q.pending > 0) && (q.err == nil) {
+		q.cond.Wait()
+	}
+	if (q.pending == 0) || (q.err != nil) {
+		return "", false
+	}
+	dir := q.dirs[len(q.dirs)-1]
+	q.dirs = q.dirs[:len(q.dirs)-1]
+	return dir, true
+}
+
+// done mark dir returned by pop as walked with given error.
+func (q *dirQueue) done(err error) {
+	q.mu.Lock()
+	q.pending--
+	if (err != nil) && (q.err == nil) {
+		q.err = err
+	}
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// walkParallel walks dir like godirwalk.Walk with storage.listWorkers goroutines, every goroutine reads and walks
+// entries of one dir at a time and queues found subdirs. The callback is called concurrently and should be safe
+// for it, but the callback of a dir always returns before its entries are walked. The callback is not called
+// for dir itself.
+func (storage *FSStorage) walkParallel(dir string, opts *godirwalk.Options) error {
+	q := &dirQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	q.push(dir)
+
+	var wg sync.WaitGroup
+	for i := 0; i < storage.listWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scratch := make([]byte, len(opts.ScratchBuffer))
+			for {
+				dir, ok := q.pop()
+				if !ok {
+					return
+				}
+				q.done(walkParallelDir(dir, scratch, opts, q))
+			}
+		}()
+	}
+	wg.Wait()
+	return q.err
+}
+
+// walkParallelDir call the callback for entries of dir and queue its subdirs.
+func walkParallelDir(dir string, scratch []byte, opts *godirwalk.Options, q *dirQueue) error {
+	dirents, err := godirwalk.ReadDirents(dir, scratch)
+	if err != nil {
+		if opts.ErrorCallback(dir, err) == godirwalk.SkipNode {
+			return nil
+		}
+		return err
+	}
+	for _, de := range dirents {
+		path := filepath.Join(dir, de.Name())
+		err := opts.Callback(path, de)
+		if err == filepath.SkipDir {
+			continue
+		} else if err != nil {
+			if opts.ErrorCallback(path, err) == godirwalk.SkipNode {
+				continue
+			}
+			return err
+		}
+		if de.IsDir() || (opts.FollowSymbolicLinks && isDir(path, de)) {
+			q.push(path)
+		}
+	}
+	return nil
+}
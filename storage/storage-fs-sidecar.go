@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/xattr"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Metadata stores of FS storage.
+const (
+	// MetaStoreXattr store object metadata in xattr of files. It is the default store.
+	MetaStoreXattr = "xattr"
+	// MetaStoreSidecar store object metadata in JSON sidecar files in SidecarDir, for filesystems without xattr
+	// support and backups losing xattrs.
+	MetaStoreSidecar = "sidecar"
+	// MetaStoreBoth write metadata to xattr and sidecar files, the record of the newer object is read.
+	// It is used to migrate between stores.
+	MetaStoreBoth = "both"
+)
+
+// SidecarDir is the directory in the root of FS storage with sidecar metadata files, metadata of file
+// "dir/file.txt" is stored in ".s3sync-meta/dir/file.txt.json". The directory is never listed and keys in it
+// can't be written.
+const SidecarDir = ".s3sync-meta"
+
+// WithMetaStore set store of object metadata, one of MetaStore* constants. Xattr store is used only if
+// extended metadata is enabled in NewFSStorage, MetaStoreXattr is used by default.
+func (storage *FSStorage) WithMetaStore(store string) {
+	switch store {
+	case MetaStoreSidecar:
+		storage.xattr, storage.sidecar = false, true
+	case MetaStoreBoth:
+		storage.sidecar = true
+	default:
+		storage.sidecar = false
+	}
+}
+
+// sidecarPath return path of sidecar metadata file of the key.
+func (storage *FSStorage) sidecarPath(key string) string {
+	return filepath.Join(storage.dir, SidecarDir, key+".json")
+}
+
+// isSidecarKey check if the key is in SidecarDir.
+func isSidecarKey(key string) bool {
+	key = filepath.ToSlash(filepath.Clean(key))
+	return (key == SidecarDir) || strings.HasPrefix(key, SidecarDir+"/")
+}
+
+// writeMeta store object metadata of written file f to enabled metadata stores.
+func (storage *FSStorage) writeMeta(f *os.File, obj *Object) error {
+	if !storage.xattr && !storage.sidecar {
+		return nil
+	}
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	if storage.xattr {
+		if err := xattr.FSet(f, storage.xattrKey, data); err != nil {
+			return err
+		}
+	}
+	if storage.sidecar {
+		return storage.writeSidecar(*obj.Key, data)
+	}
+	return nil
+}
+
+// writeSidecar atomically replace sidecar metadata file of the key with data.
+func (storage *FSStorage) writeSidecar(key string, data []byte) error {
+	path := storage.sidecarPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), storage.dirPerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), storage.filePerm); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readMeta load object metadata of file f with given stat from enabled metadata stores. If both stores have
+// the metadata, the record with newer object Mtime wins. Files without metadata get Content-Type by extension
+// and file modification time.
+func (storage *FSStorage) readMeta(f *os.File, key string, stat os.FileInfo, obj *Object) error {
+	var data []byte
+	if storage.xattr {
+		var err error
+		if data, err = xattr.FGet(f, storage.xattrKey); err != nil {
+			if xerr, ok := err.(*xattr.Error); !ok || (xerr.Err != syscall.ENODATA) {
+				return err
+			}
+			data = nil
+		}
+	}
+	if storage.sidecar {
+		sidecar, err := storage.readSidecar(key, stat)
+		if err != nil {
+			return err
+		}
+		if (sidecar != nil) && ((data == nil) || newerMeta(sidecar, data)) {
+			data = sidecar
+		}
+	}
+
+	if data == nil {
+		contentType := mime.TypeByExtension(filepath.Ext(key))
+		mtime := stat.ModTime()
+		obj.ContentType = &contentType
+		obj.Mtime = &mtime
+		return nil
+	}
+	return json.Unmarshal(data, obj)
+}
+
+// readSidecar return content of sidecar metadata file of the key, or nil if it doesn't exist.
+// Sidecar files older than the file modification time are stale, the file was replaced after sync,
+// so they are ignored too.
+func (storage *FSStorage) readSidecar(key string, stat os.FileInfo) ([]byte, error) {
+	path := storage.sidecarPath(key)
+	sidecarStat, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if sidecarStat.ModTime().Before(stat.ModTime()) {
+		Log.Debugf("Ignore stale sidecar metadata of %s", key)
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(data) {
+		return nil, fmt.Errorf("invalid sidecar metadata file %s", path)
+	}
+	return data, nil
+}
+
+// newerMeta check if metadata record a has newer object Mtime than record b.
+func newerMeta(a, b []byte) bool {
+	var ma, mb struct {
+		Mtime *time.Time `json:"mtime"`
+	}
+	if (json.Unmarshal(a, &ma) != nil) || (ma.Mtime == nil) {
+		return false
+	}
+	if (json.Unmarshal(b, &mb) != nil) || (mb.Mtime == nil) {
+		return true
+	}
+	return ma.Mtime.After(*mb.Mtime)
+}
+
+// deleteSidecar remove sidecar metadata file of the key if it exists.
+func (storage *FSStorage) deleteSidecar(key string) error {
+	if err := os.Remove(storage.sidecarPath(key)); (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"github.com/karrick/godirwalk"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// WithSorted enables sorted listing: objects are listed in lexicographic order of keys, the same order as S3 listing
+// of the same keys. Entries of every dir are read and sorted before the dir is walked, so memory usage is proportional
+// to the number of entries in the dirs of the current path instead of being constant.
+func (storage *FSStorage) WithSorted(sorted bool) {
+	storage.sorted = sorted
+}
+
+// WithListStartAfter starts sorted listing after given key, keys before it and dirs with all keys before it are not
+// walked. It requires sorted listing, see WithSorted.
+func (storage *FSStorage) WithListStartAfter(key string) {
+	storage.startAfter = key
+}
+
+// sortedDirent is the dir entry with its key used for sorting.
+type sortedDirent struct {
+	de *godirwalk.Dirent
+	// sortKey is the entry name, with trailing slash for walked dirs.
+	sortKey string
+}
+
+// walkSorted walks dir like godirwalk.Walk, but children of every dir are visited in the order of its keys.
+// Names of walked dirs are sorted with trailing slash, so "a/b" is listed after "a.txt" like in S3 listing.
+// The callback is not called for dir itself.
+func (storage *FSStorage) walkSorted(dir string, opts *godirwalk.Options) error {
+	dirents, err := godirwalk.ReadDirents(dir, opts.ScratchBuffer)
+	if err != nil {
+		if opts.ErrorCallback(dir, err) == godirwalk.SkipNode {
+			return nil
+		}
+		return err
+	}
+	entries := make([]sortedDirent, len(dirents))
+	for i, de := range dirents {
+		entries[i] = sortedDirent{de: de, sortKey: de.Name()}
+		if de.IsDir() || (opts.FollowSymbolicLinks && isDir(filepath.Join(dir, de.Name()), de)) {
+			entries[i].sortKey += "/"
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].sortKey < entries[j].sortKey })
+
+	for i := range entries {
+		path := filepath.Join(dir, entries[i].de.Name())
+		walked := strings.HasSuffix(entries[i].sortKey, "/")
+		if storage.startAfter != "" {
+			key := storage.pathKey(path)
+			if walked && (key+"/" < storage.startAfter) && !strings.HasPrefix(storage.startAfter, key+"/") {
+				continue
+			}
+			if !walked && (key <= storage.startAfter) {
+				continue
+			}
+		}
+
+		err := opts.Callback(path, entries[i].de)
+		if err == filepath.SkipDir {
+			if walked {
+				continue
+			}
+			return nil
+		} else if err != nil {
+			if opts.ErrorCallback(path, err) == godirwalk.SkipNode {
+				continue
+			}
+			return err
+		}
+		if walked {
+			if err := storage.walkSorted(path, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// DiskFullError raises when FS storage can't write an object, since the filesystem has no free space.
+type DiskFullError struct {
+	Key string
+	Err error
+}
+
+func (e *DiskFullError) Error() string {
+	return fmt.Sprintf("object: %s can't be written, no space left on target disk: %s", e.Key, e.Err)
+}
+
+// isNoSpace check if err is the full disk or exceeded quota error of FS operation.
+func isNoSpace(err error) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	case *os.SyscallError:
+		err = e.Err
+	}
+	return isNoSpaceErrno(err)
+}
+
+// FreeSpace return the number of bytes available to unprivileged users on the filesystem of storage dir.
+// If the dir doesn't exist yet, its closest existing parent is checked.
+func (storage *FSStorage) FreeSpace() (uint64, error) {
+	dir := storage.dir
+	for {
+		free, err := diskFree(dir)
+		if err == nil {
+			return free, nil
+		}
+		parent := filepath.Dir(filepath.Clean(dir))
+		if !os.IsNotExist(err) || (parent == filepath.Clean(dir)) {
+			return 0, &os.PathError{Op: "statfs", Path: dir, Err: err}
+		}
+		dir = parent
+	}
+}
+
+// UsedSpace return the size of existing file of the key, 0 if it doesn't exist.
+func (storage *FSStorage) UsedSpace(key string) uint64 {
+	stat, err := os.Lstat(filepath.Join(storage.dir, key))
+	if (err != nil) || !stat.Mode().IsRegular() {
+		return 0
+	}
+	return uint64(stat.Size())
+}
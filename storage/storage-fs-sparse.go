@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"github.com/larrabee/ratelimit"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// lseek whence values of Linux to find data and holes of sparse files.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// Limits of sparse block size, the size should be a multiple of SparseMinBlock.
+const (
+	SparseMinBlock = 4 << 10
+	SparseMaxBlock = 64 << 20
+)
+
+// WithSparse enable sparse files support with given block size, 0 disables it. Written blocks of zeros are
+// skipped with seek instead of writing, so they become holes on filesystems supporting sparse files.
+// On Linux holes of read files are found with SEEK_DATA and SEEK_HOLE and are not read, the content is
+// zero-filled, so it is the same as the content of dense files.
+func (storage *FSStorage) WithSparse(blockSize int) {
+	storage.sparse = blockSize
+}
+
+// writeSparse copy r to file f by blocks of blockSize, blocks of zeros are skipped with seek.
+// The file is truncated to the copied size in the end, so trailing holes are kept.
+func writeSparse(f *os.File, r io.Reader, blockSize int) error {
+	buf := make([]byte, blockSize)
+	zeros := make([]byte, blockSize)
+	var size int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				if _, err := f.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if (err == io.EOF) || (err == io.ErrUnexpectedEOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return f.Truncate(size)
+}
+
+// readSparse read size bytes of file f, data segments are found with SEEK_DATA and SEEK_HOLE and only they
+// are read with r, which reads from f. Holes are returned as zeros. It return false if the filesystem or OS
+// doesn't support hole detection, the file should be read as dense file then.
+func readSparse(f *os.File, r io.Reader, size int64) ([]byte, bool, error) {
+	if runtime.GOOS != "linux" {
+		return nil, false, nil
+	}
+	data := make([]byte, size)
+	for off := int64(0); off < size; {
+		start, err := f.Seek(off, seekData)
+		if pathErr, ok := err.(*os.PathError); ok && (pathErr.Err == syscall.ENXIO) {
+			// No data after the offset, the rest of the file is a hole.
+			break
+		} else if ok && (pathErr.Err == syscall.EINVAL) && (off == 0) {
+			return nil, false, nil
+		} else if err != nil {
+			return nil, false, err
+		}
+		if start >= size {
+			break
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return nil, false, err
+		}
+		if end > size {
+			end = size
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return nil, false, err
+		}
+		if _, err := io.ReadFull(r, data[start:end]); err != nil {
+			return nil, false, err
+		}
+		off = end
+	}
+	return data, true, nil
+}
+
+// readContent read size bytes of file content, with WithSparse holes are not read.
+func (storage *FSStorage) readContent(ctx context.Context, f *os.File, size int64, obj *Object) ([]byte, error) {
+	r := ratelimit.NewReader(contextReader{ctx, f}, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait})
+	if storage.sparse > 0 {
+		if data, ok, err := readSparse(f, r, size); ok || (err != nil) {
+			return data, err
+		}
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	if _, err := io.Copy(buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
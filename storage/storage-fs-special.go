@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"syscall"
+)
+
+// Special file handling modes of FS storage. Special files are files other than regular files, dirs and
+// symlinks, like sockets, named pipes and devices. Symlinks to special files are special files too,
+// unless symlinks are not followed.
+const (
+	// SpecialFilesSkip skip special files with warning. It is the default mode.
+	SpecialFilesSkip = "skip"
+	// SpecialFilesFail fail listing on the first special file.
+	SpecialFilesFail = "fail"
+)
+
+// SpecialFileError raises when FS storage meets a special file, it is never opened.
+type SpecialFileError struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (e *SpecialFileError) Error() string {
+	return fmt.Sprintf("%s is a special file (%s), it can't be synced", e.Path, specialFileType(e.Mode))
+}
+
+// specialFileType return human readable type of special file mode.
+func specialFileType(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	default:
+		return "irregular file"
+	}
+}
+
+// WithSpecialFiles set special file handling mode of listing, one of SpecialFiles* constants.
+// SpecialFilesSkip is used by default.
+func (storage *FSStorage) WithSpecialFiles(mode string) {
+	storage.specialFail = mode == SpecialFilesFail
+}
+
+// SpecialFiles return the number of special files skipped by listing.
+func (storage *FSStorage) SpecialFiles() uint64 {
+	return atomic.LoadUint64(&storage.specialCnt)
+}
+
+// skipSpecial handle listed special file: it is counted and skipped, or SpecialFileError is returned with
+// SpecialFilesFail mode.
+func (storage *FSStorage) skipSpecial(path string, mode os.FileMode) error {
+	if storage.specialFail {
+		return &SpecialFileError{Path: path, Mode: mode}
+	}
+	atomic.AddUint64(&storage.specialCnt, 1)
+	Log.Warnf("Skip special file %s (%s)", path, specialFileType(mode))
+	return nil
+}
+
+// openRegular open regular file at path for reading, special files return SpecialFileError. The file is opened
+// in non-blocking mode, so a named pipe created after the check doesn't block, and it is checked again after open.
+func openRegular(path string) (*os.File, os.FileInfo, error) {
+	if stat, err := os.Stat(path); err != nil {
+		return nil, nil, err
+	} else if stat.IsDir() {
+		return nil, nil, &os.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	} else if !stat.Mode().IsRegular() {
+		return nil, nil, &SpecialFileError{Path: path, Mode: stat.Mode()}
+	}
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if !stat.Mode().IsRegular() {
+		f.Close()
+		return nil, nil, &SpecialFileError{Path: path, Mode: stat.Mode()}
+	}
+	return f, stat, nil
+}
@@ -0,0 +1,75 @@
+//go:build !windows
+// +build !windows
+
+package storage
+
+import (
+	"os"
+	"syscall"
+)
+
+// caseInsensitiveFS enables detection of written keys differing only by case, see CaseConflictError.
+const caseInsensitiveFS = false
+
+// xattrSupported enables xattr metadata store, see MetaStoreXattr.
+const xattrSupported = true
+
+// ownerSupported enables preservation of file owners, see WithPreserveOwner.
+const ownerSupported = true
+
+// platformNotice log features of FS storage unsupported on the OS, there are none.
+func platformNotice(extendedMeta bool) {}
+
+// longPath return path used to access the storage dir.
+func longPath(dir string) string {
+	return dir
+}
+
+// checkKeyName check if the key can be written as a file, any key is valid.
+func checkKeyName(key string) error {
+	return nil
+}
+
+// makeWritable allow replacing or removing the file of path, files are always replaceable.
+func makeWritable(path string) error {
+	return nil
+}
+
+// deviceID return ID of device containing the file.
+func deviceID(stat os.FileInfo) uint64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Dev)
+	}
+	return 0
+}
+
+// fileLinks return ID and the number of hardlinks of the file.
+func fileLinks(stat os.FileInfo) (fileID, uint64, bool) {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileID{}, 0, false
+	}
+	return fileID{dev: uint64(sys.Dev), ino: uint64(sys.Ino)}, uint64(sys.Nlink), true
+}
+
+// fileOwner return owner UID and GID of the file.
+func fileOwner(stat os.FileInfo) (int, int, bool) {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return int(sys.Uid), int(sys.Gid), true
+	}
+	return 0, 0, false
+}
+
+// diskFree return the number of bytes available to unprivileged users on the filesystem of dir.
+func diskFree(dir string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
+// isNoSpaceErrno check if err is the errno of full disk or exceeded quota.
+func isNoSpaceErrno(err error) bool {
+	return (err == syscall.ENOSPC) || (err == syscall.EDQUOT)
+}
@@ -0,0 +1,119 @@
+//go:build windows
+// +build windows
+
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// caseInsensitiveFS enables detection of written keys differing only by case, see CaseConflictError.
+// NTFS and FAT are case-insensitive by default.
+const caseInsensitiveFS = true
+
+// xattrSupported enables xattr metadata store, see MetaStoreXattr. Windows has no xattrs.
+const xattrSupported = false
+
+// ownerSupported enables preservation of file owners, see WithPreserveOwner. Windows files have no UID and GID.
+const ownerSupported = false
+
+// Windows errors of full disk.
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+var (
+	procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	// noticeOnce logs platformNotice once for source and target storages.
+	noticeOnce sync.Once
+)
+
+// platformNotice log features of FS storage unsupported on Windows: xattrs and owners are not stored,
+// permissions are mapped to the read-only attribute by os.Chmod.
+func platformNotice(extendedMeta bool) {
+	noticeOnce.Do(func() {
+		msg := "FS storage on Windows: file owners are not preserved, file permissions without write bit are mapped to the read-only attribute, dir permissions are ignored"
+		if extendedMeta {
+			msg += ", object metadata is not stored in xattr (use sidecar metadata store)"
+		}
+		Log.Info(msg)
+	})
+}
+
+// longPath return absolute path of dir with `\\?\` prefix, so paths longer than MAX_PATH (260 characters)
+// are accessible. UNC paths get `\\?\UNC\` prefix. Paths with the prefix are not normalized by Windows,
+// so they should be cleaned.
+func longPath(dir string) string {
+	if strings.HasPrefix(dir, `\\?\`) {
+		return dir
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
+
+// checkKeyName check if the key can be written as a file on Windows, see windowsKeyError.
+func checkKeyName(key string) error {
+	if reason := windowsKeyError(key); reason != "" {
+		return &InvalidKeyError{Key: key, Reason: reason}
+	}
+	return nil
+}
+
+// makeWritable clear the read-only attribute of existing file of path, Windows can't replace or remove read-only files.
+func makeWritable(path string) error {
+	stat, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if stat.Mode().IsRegular() && (stat.Mode().Perm()&0200 == 0) {
+		return os.Chmod(path, stat.Mode().Perm()|0200)
+	}
+	return nil
+}
+
+// deviceID return ID of device containing the file, it is unknown on Windows.
+func deviceID(stat os.FileInfo) uint64 {
+	return 0
+}
+
+// fileLinks return ID and the number of hardlinks of the file, they are unknown on Windows without opening the file.
+func fileLinks(stat os.FileInfo) (fileID, uint64, bool) {
+	return fileID{}, 0, false
+}
+
+// fileOwner return owner UID and GID of the file, Windows files have none.
+func fileOwner(stat os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
+
+// diskFree return the number of bytes available to the user on the volume of dir.
+func diskFree(dir string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0); r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
+
+// isNoSpaceErrno check if err is the error of full disk.
+func isNoSpaceErrno(err error) bool {
+	return (err == errorDiskFull) || (err == errorHandleDiskFull) || (err == syscall.ENOSPC)
+}
@@ -3,38 +3,136 @@ package storage
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"github.com/karrick/godirwalk"
 	"github.com/larrabee/ratelimit"
-	"github.com/pkg/xattr"
 	"io"
 	"mime"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"time"
 )
 
+// DefaultXattrPrefix is the default prefix of xattr keys used by FS storage to store object metadata.
+const DefaultXattrPrefix = "user.s3sync."
+
+// Symlink handling modes of FS storage.
+const (
+	// SymlinksFollow read content of symlink targets and walk symlinked dirs, symlink loops are skipped.
+	// Broken symlinks are listed, reading them fails with not exist error. It is the default mode.
+	SymlinksFollow = "follow"
+	// SymlinksSkip ignore symlinks.
+	SymlinksSkip = "skip"
+	// SymlinksPreserve list symlinks as empty objects with the link target path in SymlinkMetaKey metadata,
+	// objects with the metadata are written as symlinks.
+	SymlinksPreserve = "preserve"
+)
+
+// SymlinkMetaKey is the user metadata key of preserved symlink target path.
+const SymlinkMetaKey = "S3sync-Symlink"
+
+// MtimeMetaKey is the user metadata key of file modification time in time.RFC3339Nano format,
+// FS storage with WithPreserveMtime stores it on read and restores it on write.
+const MtimeMetaKey = "S3sync-Mtime"
+
+// TempFilePrefix is the name prefix of temp files written by FS storage, they are renamed to the destination
+// when the write is complete. Files with the prefix are never listed.
+const TempFilePrefix = ".s3sync-tmp-"
+
 // FSStorage configuration.
 type FSStorage struct {
-	dir      string
-	filePerm os.FileMode
-	dirPerm  os.FileMode
-	bufSize  int
-	xattr    bool
-	ctx      context.Context
-	rlBucket ratelimit.Bucket
+	// specialCnt is accessed atomically, so it is the first field to be 64-bit aligned.
+	specialCnt  uint64
+	specialFail bool
+	dir         string
+	filePerm    os.FileMode
+	dirPerm     os.FileMode
+	bufSize     int
+	xattr       bool
+	xattrKey    string
+	sidecar     bool
+	fsync       bool
+	sparse      int
+	noHidden    bool
+	ignoreFile  string
+	sorted      bool
+	startAfter  string
+	listWorkers int
+	maxDepth    uint
+	oneDev      bool
+	chmod       []ChmodRule
+	symlinks    string
+	mtime       bool
+	owner       *ownerState
+	links       *hardlinkState
+	caseKeys    *caseKeys
+	rlBucket    ratelimit.Bucket
+}
+
+// ChmodRule set permissions of written files matching Pattern.
+type ChmodRule struct {
+	// Pattern is path.Match pattern of file name, patterns with "/" are matched against the whole key.
+	Pattern string
+	Mode    os.FileMode
+	// Source keeps permissions of the source file instead of Mode, it works only with FS source.
+	Source bool
+}
+
+// ParseChmodRules parse comma separated chmod rules PATTERN=MODE, like "*.sh=0755,*=0644".
+// MODE is octal permissions or "source" to keep permissions of the source file.
+func ParseChmodRules(s string) ([]ChmodRule, error) {
+	var rules []ChmodRule
+	for _, rule := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(rule), "=", 2)
+		if (len(parts) != 2) || (parts[0] == "") {
+			return nil, fmt.Errorf("invalid rule %q, expected format: PATTERN=MODE", rule)
+		}
+		if _, err := path.Match(parts[0], ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", parts[0], err)
+		}
+		r := ChmodRule{Pattern: parts[0]}
+		if parts[1] == "source" {
+			r.Source = true
+		} else if mode, err := strconv.ParseUint(parts[1], 8, 32); (err != nil) || (mode > 0777) {
+			return nil, fmt.Errorf("invalid mode %q of pattern %q, expected octal permissions like 0644 or source", parts[1], parts[0])
+		} else {
+			r.Mode = os.FileMode(mode)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// match check if key matches the rule pattern.
+func (r ChmodRule) match(key string) bool {
+	key = filepath.ToSlash(key)
+	if !strings.Contains(r.Pattern, "/") {
+		key = path.Base(key)
+	}
+	ok, _ := path.Match(r.Pattern, key)
+	return ok
 }
 
 // NewFSStorage return new configured FS storage.
+// On Windows the dir is accessed with `\\?\` prefix to support long paths, extended metadata is not supported,
+// written keys which are not valid file names or differ only by case from other written keys fail with
+// InvalidKeyError and CaseConflictError.
 //
 // You should always create new storage with this constructor.
 func NewFSStorage(dir string, filePerm, dirPerm os.FileMode, bufSize int, extendedMeta bool) *FSStorage {
+	platformNotice(extendedMeta)
 	storage := FSStorage{
-		dir:      filepath.Clean(dir) + "/",
+		dir:      longPath(filepath.Clean(dir)) + string(filepath.Separator),
 		filePerm: filePerm,
 		dirPerm:  dirPerm,
-		xattr:    extendedMeta,
+		xattr:    extendedMeta && xattrSupported,
+		xattrKey: DefaultXattrPrefix + "meta",
 		rlBucket: ratelimit.NewFakeBucket(),
 	}
 	if bufSize < godirwalk.MinimumScratchBufferSize {
@@ -42,14 +140,12 @@ func NewFSStorage(dir string, filePerm, dirPerm os.FileMode, bufSize int, extend
 	} else {
 		storage.bufSize = bufSize
 	}
+	if caseInsensitiveFS {
+		storage.caseKeys = &caseKeys{keys: make(map[string]string)}
+	}
 	return &storage
 }
 
-// WithContext add's context to storage.
-func (storage *FSStorage) WithContext(ctx context.Context) {
-	storage.ctx = ctx
-}
-
 // WithRateLimit set rate limit (bytes/sec) for storage.
 func (storage *FSStorage) WithRateLimit(limit int) error {
 	bucket, err := ratelimit.NewBucketWithRate(float64(limit), int64(limit))
@@ -60,188 +156,653 @@ func (storage *FSStorage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithRateLimitBucket set rate limit bucket for storage.
+// The bucket can be shared between storages to limit their total bandwidth.
+func (storage *FSStorage) WithRateLimitBucket(bucket ratelimit.Bucket) {
+	storage.rlBucket = bucket
+}
+
+// WithXattrPrefix set prefix of xattr keys used to store object metadata, like "user.s3sync.".
+// On Linux the prefix should be in the user namespace, since other namespaces are not writable by regular users.
+func (storage *FSStorage) WithXattrPrefix(prefix string) {
+	storage.xattrKey = prefix + "meta"
+}
+
+// WithChmod set permissions of written files by the first rule matching the key, instead of file permissions
+// of the storage. Permissions are set exactly, regardless of umask, existing files are updated too.
+func (storage *FSStorage) WithChmod(rules []ChmodRule) {
+	storage.chmod = rules
+}
+
+// filePermOf return permissions of written file of the object, it return false if no chmod rule matched.
+func (storage *FSStorage) filePermOf(obj *Object) (os.FileMode, bool) {
+	for _, r := range storage.chmod {
+		if !r.match(*obj.Key) {
+			continue
+		}
+		if !r.Source {
+			return r.Mode, true
+		}
+		if obj.Mode != nil {
+			return *obj.Mode, true
+		}
+		return storage.filePerm, false
+	}
+	return storage.filePerm, false
+}
+
+// WithSymlinks set symlink handling mode, one of Symlinks* constants. SymlinksFollow is used by default.
+func (storage *FSStorage) WithSymlinks(mode string) {
+	storage.symlinks = mode
+}
+
+// followSymlinks check if symlinks are followed.
+func (storage *FSStorage) followSymlinks() bool {
+	return (storage.symlinks != SymlinksSkip) && (storage.symlinks != SymlinksPreserve)
+}
+
+// WithPreserveMtime enable preservation of file modification times: file mtime is added to MtimeMetaKey
+// metadata of read objects and written files get mtime from the metadata or from the object Mtime if it is absent.
+func (storage *FSStorage) WithPreserveMtime(enabled bool) {
+	storage.mtime = enabled
+}
+
+// WithFsync enable sync of written files and their directories to disk before the write is complete.
+// It makes writes durable on power loss at the cost of write throughput.
+func (storage *FSStorage) WithFsync(enabled bool) {
+	storage.fsync = enabled
+}
+
+// WithExcludeHidden enables skipping of hidden (dot-prefixed) files and directories on listing.
+func (storage *FSStorage) WithExcludeHidden(exclude bool) {
+	storage.noHidden = exclude
+}
+
+// WithMaxDepth limits listing to files with given depth relative to the storage dir, 0 means no limit.
+// Files in the storage dir have depth 1, directories at the max depth are not walked.
+func (storage *FSStorage) WithMaxDepth(depth uint) {
+	storage.maxDepth = depth
+}
+
+// WithNoCrossDevice enables skipping of directories located on other devices than the storage dir, like "find -xdev".
+func (storage *FSStorage) WithNoCrossDevice(enabled bool) {
+	storage.oneDev = enabled
+}
+
 // List FS and send founded objects to chan.
-func (storage *FSStorage) List(output chan<- *Object) error {
+func (storage *FSStorage) List(ctx context.Context, output chan<- *Object) error {
+	var rootDev uint64
+	if storage.oneDev {
+		stat, err := os.Stat(storage.dir)
+		if err != nil {
+			return err
+		}
+		rootDev = deviceID(stat)
+	}
+	follow := storage.followSymlinks()
+	parallel := !storage.sorted && (storage.listWorkers > 1)
+	// mu guards realDirs and ignoreRules, which are shared by walkers of parallel listing.
+	var mu sync.Mutex
+	// realDirs map walked dirs to its real paths to detect symlink loops.
+	realDirs := make(map[string]string)
+	if follow && (storage.sorted || parallel) {
+		// Sorted and parallel walks don't call listObjectsFn for the storage dir, its real path is added here.
+		root := filepath.Clean(storage.dir)
+		real, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return err
+		}
+		realDirs[root] = real
+	}
+	// ignoreRules map key prefixes of walked dirs to rules of its ignore files.
+	ignoreRules := make(map[string][]ignoreRule)
+	if storage.ignoreFile != "" {
+		rules, err := storage.loadIgnoreFile("")
+		if err != nil {
+			return err
+		}
+		ignoreRules[""] = rules
+	}
+
+	sendObject := func(path string) error {
+		key := storage.pathKey(path)
+		select {
+		case output <- &Object{Key: &key, Timings: ObjectTimings{Listed: time.Now()}}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
 	listObjectsFn := func(path string, de *godirwalk.Dirent) error {
 		select {
-		case <-storage.ctx.Done():
-			return storage.ctx.Err()
+		case <-ctx.Done():
+			return ctx.Err()
 		default:
+			if path == storage.dir+SidecarDir {
+				return filepath.SkipDir
+			}
+			// Root dir path has no trailing slash, so the root is never skipped even if it is hidden.
+			if storage.noHidden && strings.HasPrefix(de.Name(), ".") && strings.HasPrefix(path, storage.dir) {
+				if isDir(path, de) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if (storage.ignoreFile != "") && strings.HasPrefix(path, storage.dir) {
+				key := storage.pathKey(path)
+				dir := de.IsDir() || (follow && isDir(path, de))
+				mu.Lock()
+				skip := ignored(ignoreRules, key, dir)
+				mu.Unlock()
+				if skip {
+					Log.Debugf("Skip ignored %s", path)
+					if dir {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+				if dir {
+					rules, err := storage.loadIgnoreFile(key + "/")
+					if err != nil {
+						return err
+					}
+					if len(rules) > 0 {
+						mu.Lock()
+						ignoreRules[key+"/"] = rules
+						mu.Unlock()
+					}
+				}
+			}
+			if de.IsSymlink() && !follow {
+				if storage.symlinks == SymlinksSkip {
+					Log.Debugf("Skip symlink %s", path)
+					return nil
+				}
+				if storage.maxDepth > 0 && keyDepth(storage.pathKey(path)) > storage.maxDepth {
+					return nil
+				}
+				return sendObject(path)
+			}
+			if storage.maxDepth > 0 && strings.HasPrefix(path, storage.dir) {
+				dir := isDir(path, de)
+				depth := keyDepth(storage.pathKey(path))
+				if dir && depth >= storage.maxDepth {
+					return filepath.SkipDir
+				}
+				if !dir && depth > storage.maxDepth {
+					return nil
+				}
+			}
+			if storage.oneDev && strings.HasPrefix(path, storage.dir) && isDir(path, de) {
+				stat, err := os.Stat(path)
+				if err != nil {
+					return err
+				}
+				if deviceID(stat) != rootDev {
+					Log.Debugf("Skip dir %s on another device", path)
+					return filepath.SkipDir
+				}
+			}
+			if follow && isDir(path, de) {
+				mu.Lock()
+				loop, err := symlinkLoop(realDirs, path, de)
+				mu.Unlock()
+				if err != nil {
+					return err
+				}
+				if loop {
+					Log.Debugf("Skip symlink loop %s", path)
+					return filepath.SkipDir
+				}
+			}
 			if de.IsRegular() {
-				key := strings.TrimPrefix(path, storage.dir)
-				output <- &Object{Key: &key}
+				if strings.HasPrefix(de.Name(), TempFilePrefix) {
+					Log.Debugf("Skip temp file %s", path)
+					return nil
+				}
+				return sendObject(path)
+			}
+			if !de.IsDir() && !de.IsSymlink() {
+				return storage.skipSpecial(path, de.ModeType())
 			}
 			if de.IsSymlink() {
 				pathTarget, err := filepath.EvalSymlinks(path)
-				if err != nil {
+				if os.IsNotExist(err) {
+					// Broken symlink is synced as missing object.
+					return sendObject(path)
+				} else if err != nil {
 					return err
 				}
 				symStat, err := os.Stat(pathTarget)
 				if err != nil {
 					return err
 				}
-				if !symStat.IsDir() {
-					key := strings.TrimPrefix(path, storage.dir)
-					output <- &Object{Key: &key}
+				if symStat.Mode().IsRegular() {
+					return sendObject(path)
+				} else if !symStat.IsDir() {
+					return storage.skipSpecial(path, symStat.Mode())
 				}
 			}
 			return nil
 		}
 	}
 
-	err := godirwalk.Walk(storage.dir, &godirwalk.Options{
-		FollowSymbolicLinks: true,
+	walkOpts := &godirwalk.Options{
+		FollowSymbolicLinks: follow,
 		Unsorted:            true,
 		ScratchBuffer:       make([]byte, storage.bufSize),
 		Callback:            listObjectsFn,
-	})
+		ErrorCallback: func(path string, err error) godirwalk.ErrorAction {
+			// Walker can't check if broken symlink is a dir, it is already listed.
+			if stat, lerr := os.Lstat(path); os.IsNotExist(err) && (lerr == nil) && (stat.Mode()&os.ModeSymlink != 0) {
+				return godirwalk.SkipNode
+			}
+			return godirwalk.Halt
+		},
+	}
+	var err error
+	if storage.sorted {
+		err = storage.walkSorted(filepath.Clean(storage.dir), walkOpts)
+	} else if parallel {
+		err = storage.walkParallel(filepath.Clean(storage.dir), walkOpts)
+	} else {
+		err = godirwalk.Walk(storage.dir, walkOpts)
+	}
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// PutObject saves object to FS.
-func (storage *FSStorage) PutObject(obj *Object) error {
+// symlinkLoop check if walked dir is a symlink loop: its real path is the real path of one of its parent dirs.
+// Real paths of walked dirs are kept in realDirs, so real paths of not symlinked dirs are computed without syscalls.
+// Symlinked dirs pointing outside of the walk parents are walked, even if they are walked already by other path.
+func symlinkLoop(realDirs map[string]string, path string, de *godirwalk.Dirent) (bool, error) {
+	parent := filepath.Dir(path)
+	real, ok := realDirs[parent]
+	if ok && !de.IsSymlink() {
+		real = filepath.Join(real, de.Name())
+	} else {
+		var err error
+		if real, err = filepath.EvalSymlinks(path); err != nil {
+			return false, err
+		}
+	}
+	for p := parent; ; p = filepath.Dir(p) {
+		r, ok := realDirs[p]
+		if !ok {
+			break
+		}
+		if r == real {
+			return true, nil
+		}
+		if p == filepath.Dir(p) {
+			break
+		}
+	}
+	realDirs[path] = real
+	return false, nil
+}
+
+// isDir check if directory entry is a directory or a symlink to directory.
+func isDir(path string, de *godirwalk.Dirent) bool {
+	if de.IsSymlink() {
+		stat, err := os.Stat(path)
+		return err == nil && stat.IsDir()
+	}
+	return de.IsDir()
+}
+
+// pathKey return key of the path in the storage dir, keys use "/" separator on every OS.
+func (storage *FSStorage) pathKey(path string) string {
+	return filepath.ToSlash(strings.TrimPrefix(path, storage.dir))
+}
+
+// PutObject saves object to FS. Writes failed due to lack of free space return DiskFullError.
+func (storage *FSStorage) PutObject(ctx context.Context, obj *Object) error {
+	err := storage.putObject(ctx, obj)
+	if isNoSpace(err) {
+		return &DiskFullError{Key: *obj.Key, Err: err}
+	}
+	return err
+}
+
+func (storage *FSStorage) putObject(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	defer func() { obj.Timings.Upload = time.Since(start) }()
+	obj.Attempts++
+	if isSidecarKey(*obj.Key) {
+		return fmt.Errorf("can't write %s, %s dir is reserved for sidecar metadata", *obj.Key, SidecarDir)
+	}
+	if err := checkKeyName(*obj.Key); err != nil {
+		return err
+	}
+	if storage.caseKeys != nil {
+		if err := storage.caseKeys.claim(*obj.Key); err != nil {
+			return err
+		}
+	}
 	destPath := filepath.Join(storage.dir, *obj.Key)
+	if storage.symlinks == SymlinksPreserve {
+		// Preserved symlinks can point anywhere, files are never written through them.
+		if err := storage.checkSymlinkParents(*obj.Key); err != nil {
+			return err
+		}
+	}
 	err := os.MkdirAll(filepath.Dir(destPath), storage.dirPerm)
 	if err != nil {
 		return err
 	}
-	f, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, storage.filePerm)
+	if target, ok := symlinkTarget(obj); ok && (storage.symlinks == SymlinksPreserve) {
+		if err := makeWritable(destPath); err != nil {
+			return err
+		}
+		if err := os.Remove(destPath); (err != nil) && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Symlink(target, destPath)
+	}
+	if first, ok := hardlinkTarget(obj); ok && (storage.links != nil) && (first != *obj.Key) {
+		return storage.putHardlink(*obj.Key, first)
+	}
+	// Files are written to a temp file in the same dir and renamed over the destination,
+	// so readers and interrupted syncs never see partially written files.
+	perm, matched := storage.filePermOf(obj)
+	f, err := createTempFile(filepath.Dir(destPath), perm)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	objReader := bytes.NewReader(*obj.Content)
-	if _, err := io.Copy(f, ratelimit.NewReader(objReader, storage.rlBucket)); err != nil {
+	err = storage.writeFile(ctx, f, obj, perm, matched)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if (err == nil) && storage.mtime {
+		if mtime, ok := objectMtime(obj); ok {
+			err = os.Chtimes(f.Name(), time.Now(), mtime)
+		}
+	}
+	if err == nil {
+		err = makeWritable(destPath)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), destPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
 		return err
 	}
+	if storage.fsync {
+		if err := syncDir(filepath.Dir(destPath)); err != nil {
+			return err
+		}
+	}
+	if storage.links != nil {
+		return storage.relinkHardlinks(obj)
+	}
+	return nil
+}
 
-	if storage.xattr {
-		data, err := json.Marshal(obj)
-		if err != nil {
+// writeFile write object content, metadata and owner to file f, the file is synced to disk with WithFsync.
+func (storage *FSStorage) writeFile(ctx context.Context, f *os.File, obj *Object, perm os.FileMode, exactPerm bool) error {
+	if exactPerm {
+		if err := f.Chmod(perm); err != nil {
 			return err
 		}
+	}
 
-		if err := xattr.FSet(f, "user.s3sync.meta", data); err != nil {
+	objReader := contextReader{ctx, bytes.NewReader(*obj.Content)}
+	r := ratelimit.NewReader(objReader, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait})
+	if storage.sparse > 0 {
+		if err := writeSparse(f, r, storage.sparse); err != nil {
 			return err
 		}
+	} else if _, err := io.Copy(f, r); err != nil {
+		return err
 	}
 
+	if err := storage.writeMeta(f, obj); err != nil {
+		return err
+	}
+
+	if storage.owner != nil {
+		if err := storage.writeOwner(f, obj); err != nil {
+			return err
+		}
+	}
+
+	if storage.fsync {
+		return f.Sync()
+	}
 	return nil
 }
 
-// GetObjectContent read object content and metadata from FS.
-func (storage *FSStorage) GetObjectContent(obj *Object) error {
-	destPath := filepath.Join(storage.dir, *obj.Key)
-	f, err := os.Open(destPath)
-	if err != nil {
-		return err
+// createTempFile create new temp file with TempFilePrefix in dir, perm is applied like in os.OpenFile.
+func createTempFile(dir string, perm os.FileMode) (*os.File, error) {
+	suffix := make([]byte, 8)
+	for i := 0; ; i++ {
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		f, err := os.OpenFile(filepath.Join(dir, TempFilePrefix+hex.EncodeToString(suffix)), os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && (i < 10) {
+			continue
+		}
+		return f, err
 	}
-	defer f.Close()
+}
 
-	fileInfo, err := f.Stat()
+// syncDir sync directory entries to disk, so renamed files survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
+	defer d.Close()
+	return d.Sync()
+}
 
-	buf := bytes.NewBuffer(make([]byte, 0, fileInfo.Size()))
-	if _, err := io.Copy(buf, ratelimit.NewReader(f, storage.rlBucket)); err != nil {
-		return err
+// CleanTempFiles remove temp files left in the storage dir by interrupted writes and return their number.
+// Temp files of running writes are removed too, so it should be called before the sync.
+func (storage *FSStorage) CleanTempFiles(ctx context.Context) (int, error) {
+	var removed int
+	if _, err := os.Stat(storage.dir); os.IsNotExist(err) {
+		return 0, nil
 	}
-
-	data := buf.Bytes()
-
-	obj.Content = &data
-
-	if storage.xattr {
-		if data, err := xattr.FGet(f, "user.s3sync.meta"); err == nil {
-			err := json.Unmarshal(data, obj)
-			if err != nil {
+	err := godirwalk.Walk(storage.dir, &godirwalk.Options{
+		Unsorted:      true,
+		ScratchBuffer: make([]byte, storage.bufSize),
+		Callback: func(path string, de *godirwalk.Dirent) error {
+			if err := ctx.Err(); err != nil {
 				return err
 			}
-		} else {
-			switch err.(type) {
-			case *xattr.Error:
-				if err.(*xattr.Error).Err == syscall.ENODATA {
-					contentType := mime.TypeByExtension(filepath.Ext(destPath))
-					Mtime := fileInfo.ModTime()
-					obj.ContentType = &contentType
-					obj.Mtime = &Mtime
-					break
-				} else {
-					return err
-				}
-			default:
+			if path == storage.dir+SidecarDir {
+				return filepath.SkipDir
+			}
+			if !de.IsRegular() || !strings.HasPrefix(de.Name(), TempFilePrefix) {
+				return nil
+			}
+			if err := os.Remove(path); (err != nil) && !os.IsNotExist(err) {
 				return err
 			}
+			Log.Debugf("Removed temp file %s", path)
+			removed++
+			return nil
+		},
+	})
+	return removed, err
+}
+
+// GetObjectContent read object content and metadata from FS.
+func (storage *FSStorage) GetObjectContent(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	obj.Attempts++
+	destPath := filepath.Join(storage.dir, *obj.Key)
+	if storage.symlinks == SymlinksPreserve {
+		if ok, err := readSymlink(destPath, obj); ok || (err != nil) {
+			return err
 		}
+	}
+	f, fileInfo, err := openRegular(destPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size := fileInfo.Size()
+	obj.Size = &size
+	mode := fileInfo.Mode().Perm()
+	obj.Mode = &mode
+	obj.Timings.SourceTTFB = time.Since(start)
+
+	first := storage.hardlinkOf(fileInfo, *obj.Key)
+	data := []byte{}
+	if (first == "") || (first == *obj.Key) {
+		start = time.Now()
+		if data, err = storage.readContent(ctx, f, fileInfo.Size(), obj); err != nil {
+			return err
+		}
+		obj.Timings.Download = time.Since(start)
 	} else {
-		contentType := mime.TypeByExtension(filepath.Ext(destPath))
-		Mtime := fileInfo.ModTime()
-		obj.ContentType = &contentType
-		obj.Mtime = &Mtime
+		// Other links of the file are empty objects pointing to the first read link.
+		size = 0
+	}
+
+	obj.Content = &data
+
+	if err := storage.readMeta(f, *obj.Key, fileInfo, obj); err != nil {
+		return err
+	}
+
+	if storage.mtime {
+		mtime := fileInfo.ModTime().UTC().Format(time.RFC3339Nano)
+		if obj.Metadata == nil {
+			obj.Metadata = make(map[string]*string, 1)
+		}
+		obj.Metadata[MtimeMetaKey] = &mtime
+	}
+	if storage.owner != nil {
+		storage.readOwner(fileInfo, obj)
+	}
+	if storage.links != nil {
+		setHardlinkMeta(obj, first)
 	}
 
 	return nil
 }
 
 // GetObjectMeta update object metadata from FS.
-func (storage *FSStorage) GetObjectMeta(obj *Object) error {
+func (storage *FSStorage) GetObjectMeta(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	defer func() { obj.Timings.Meta += time.Since(start) }()
+
 	destPath := filepath.Join(storage.dir, *obj.Key)
-	f, err := os.Open(destPath)
+	if storage.symlinks == SymlinksPreserve {
+		if ok, err := readSymlink(destPath, obj); ok || (err != nil) {
+			return err
+		}
+	}
+	f, fileInfo, err := openRegular(destPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	fileInfo, err := f.Stat()
-	if err != nil {
-		return err
-	}
+	size := fileInfo.Size()
+	obj.Size = &size
+	mode := fileInfo.Mode().Perm()
+	obj.Mode = &mode
 
-	if storage.xattr {
-		if data, err := xattr.FGet(f, "user.s3sync.meta"); err == nil {
-			err := json.Unmarshal(data, obj)
-			if err != nil {
-				return err
-			}
-		} else {
-			switch err.(type) {
-			case *xattr.Error:
-				if err.(*xattr.Error).Err == syscall.ENODATA {
-					contentType := mime.TypeByExtension(filepath.Ext(destPath))
-					Mtime := fileInfo.ModTime()
-					obj.ContentType = &contentType
-					obj.Mtime = &Mtime
-					break
-				} else {
-					return err
-				}
-			default:
-				return err
-			}
-		}
-	} else {
-		contentType := mime.TypeByExtension(filepath.Ext(destPath))
-		Mtime := fileInfo.ModTime()
-		obj.ContentType = &contentType
-		obj.Mtime = &Mtime
+	if err := storage.readMeta(f, *obj.Key, fileInfo, obj); err != nil {
+		return err
 	}
 
 	return nil
 }
 
 // DeleteObject remove object from FS.
-func (storage *FSStorage) DeleteObject(obj *Object) error {
+func (storage *FSStorage) DeleteObject(ctx context.Context, obj *Object) error {
 	destPath := filepath.Join(storage.dir, *obj.Key)
+	if err := makeWritable(destPath); err != nil {
+		return err
+	}
 	err := os.Remove(destPath)
 	if err != nil {
 		return err
 	}
+	if storage.sidecar {
+		return storage.deleteSidecar(*obj.Key)
+	}
+
+	return nil
+}
+
+// symlinkTarget return target path of preserved symlink object.
+func symlinkTarget(obj *Object) (string, bool) {
+	for key, val := range obj.Metadata {
+		if strings.EqualFold(key, SymlinkMetaKey) && (val != nil) {
+			return *val, true
+		}
+	}
+	return "", false
+}
+
+// objectMtime return file modification time of object from MtimeMetaKey metadata, or object Mtime if it is absent.
+func objectMtime(obj *Object) (time.Time, bool) {
+	for key, val := range obj.Metadata {
+		if strings.EqualFold(key, MtimeMetaKey) && (val != nil) {
+			if mtime, err := time.Parse(time.RFC3339Nano, *val); err == nil {
+				return mtime, true
+			}
+			Log.Warnf("Invalid %s metadata of object %s: %q", MtimeMetaKey, *obj.Key, *val)
+		}
+	}
+	if obj.Mtime != nil {
+		return *obj.Mtime, true
+	}
+	return time.Time{}, false
+}
 
+// readSymlink load empty object content and metadata with symlink target path if path is a symlink,
+// it return false if path is not a symlink.
+func readSymlink(path string, obj *Object) (bool, error) {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return false, err
+	}
+	if stat.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	target, err := os.Readlink(path)
+	if err != nil {
+		return false, err
+	}
+	data, size, mtime, mode := []byte{}, int64(0), stat.ModTime(), stat.Mode().Perm()
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	obj.Content, obj.Size, obj.Mtime, obj.Mode, obj.ContentType = &data, &size, &mtime, &mode, &contentType
+	obj.Metadata = map[string]*string{SymlinkMetaKey: &target}
+	return true, nil
+}
+
+// checkSymlinkParents return error if any parent dir of the key in the storage is a symlink.
+func (storage *FSStorage) checkSymlinkParents(key string) error {
+	path := storage.dir
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(key)), "/")
+	for _, part := range parts {
+		if (part == "") || (part == ".") {
+			continue
+		}
+		path = filepath.Join(path, part)
+		stat, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if stat.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("can't write %s, parent dir %s is a symlink", key, path)
+		}
+	}
 	return nil
 }
 
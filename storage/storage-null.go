@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"github.com/larrabee/ratelimit"
+	"os"
+)
+
+// NullStorage is a target storage which discards all objects, like /dev/null.
+// It is empty on listing and immediately acknowledges uploads.
+type NullStorage struct{}
+
+// NewNullStorage return new Null storage.
+//
+// You should always create new storage with this constructor.
+func NewNullStorage() *NullStorage {
+	return &NullStorage{}
+}
+
+// WithRateLimit do nothing, since objects are not written.
+func (storage *NullStorage) WithRateLimit(limit int) error {
+	return nil
+}
+
+// WithRateLimitBucket do nothing, since objects are not written.
+func (storage *NullStorage) WithRateLimitBucket(bucket ratelimit.Bucket) {
+}
+
+// List do nothing, the storage is always empty.
+func (storage *NullStorage) List(ctx context.Context, output chan<- *Object) error {
+	return nil
+}
+
+// PutObject discard object.
+func (storage *NullStorage) PutObject(ctx context.Context, obj *Object) error {
+	return nil
+}
+
+// GetObjectContent always return not exist error.
+func (storage *NullStorage) GetObjectContent(ctx context.Context, obj *Object) error {
+	return os.ErrNotExist
+}
+
+// GetObjectMeta always return not exist error.
+func (storage *NullStorage) GetObjectMeta(ctx context.Context, obj *Object) error {
+	return os.ErrNotExist
+}
+
+// DeleteObject do nothing.
+func (storage *NullStorage) DeleteObject(ctx context.Context, obj *Object) error {
+	return nil
+}
+
+// GetStorageType return storage type.
+func (storage *NullStorage) GetStorageType() Type {
+	return TypeNull
+}
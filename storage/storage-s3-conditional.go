@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"net/http"
+)
+
+// Conditional upload modes of S3 storage.
+const (
+	// PutIfNoneMatchAny upload objects only if the key doesn't exist, with If-None-Match: * header.
+	PutIfNoneMatchAny = "*"
+	// PutIfNoneMatchETag upload objects only if the existing object has other ETag than MD5 of the content,
+	// with If-None-Match: "<md5>" header. It requires S3 implementation supporting ETag conditions of PutObject.
+	PutIfNoneMatchETag = "etag"
+)
+
+// PreconditionFailedError raises when conditional upload is rejected by S3 with 412 Precondition Failed,
+// the object is not written.
+type PreconditionFailedError struct {
+	Key string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return fmt.Sprintf("object: %s is not uploaded, upload precondition failed", e.Key)
+}
+
+// WithPutIfNoneMatch enable conditional uploads with given mode, one of PutIfNoneMatch* constants,
+// empty mode disables them. Rejected uploads return PreconditionFailedError.
+func (storage *S3Storage) WithPutIfNoneMatch(mode string) {
+	storage.ifNoneMatch = mode
+}
+
+// putCondition return request option with If-None-Match header of conditional upload of content,
+// it return nil if conditional uploads are disabled.
+func (storage *S3Storage) putCondition(content []byte) request.Option {
+	var value string
+	switch storage.ifNoneMatch {
+	case PutIfNoneMatchAny:
+		value = "*"
+	case PutIfNoneMatchETag:
+		sum := md5.Sum(content)
+		value = "\"" + hex.EncodeToString(sum[:]) + "\""
+	default:
+		return nil
+	}
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("If-None-Match", value)
+	}
+}
+
+// isPreconditionFailed check if err is 412 Precondition Failed response of S3.
+func isPreconditionFailed(err error) bool {
+	reqErr, ok := err.(awserr.RequestFailure)
+	return ok && (reqErr.StatusCode() == http.StatusPreconditionFailed)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// metaHeaderPrefix is the prefix of response headers with user metadata of S3 objects.
+const metaHeaderPrefix = "X-Amz-Meta-"
+
+// WithPresignDownload download object content with plain HTTP GET of pre-signed URL valid for ttl instead of
+// GetObject API requests, 0 disables it. Requests go through the storage HTTP client directly, so they can be
+// routed to the endpoint of the URL bypassing SDK endpoint resolution, like through a CloudFront cache.
+func (storage *S3Storage) WithPresignDownload(ttl time.Duration) {
+	storage.presignTTL = ttl
+}
+
+// presignedGetObject download object with HTTP GET of pre-signed URL of input. Response headers are returned
+// with the output parsed from them, the caller should close output Body.
+func (storage *S3Storage) presignedGetObject(ctx context.Context, input *s3.GetObjectInput) (*s3.GetObjectOutput, http.Header, error) {
+	req, _ := storage.awsSvc.GetObjectRequest(input)
+	if storage.crc32c || storage.reqChecksum {
+		req.Handlers.Build.PushBack(withChecksumMode)
+	}
+	url, signed, err := req.PresignRequest(storage.presignTTL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	httpReq, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range signed {
+		for _, v := range values {
+			httpReq.Header.Add(name, v)
+		}
+	}
+	// Stored content should not be decoded by HTTP client, like it is not decoded with GetObject.
+	httpReq.Header.Set("Accept-Encoding", "identity")
+
+	client := storage.awsSession.Config.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		_ = resp.Body.Close()
+		return nil, nil, fmt.Errorf("pre-signed GET of %s failed with status %s: %s", aws.StringValue(input.Key), resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	header := func(name string) *string {
+		if v := resp.Header.Get(name); v != "" {
+			return aws.String(v)
+		}
+		return nil
+	}
+	out := &s3.GetObjectOutput{
+		Body:               resp.Body,
+		ContentType:        header("Content-Type"),
+		ContentDisposition: header("Content-Disposition"),
+		ContentEncoding:    header("Content-Encoding"),
+		ContentLanguage:    header("Content-Language"),
+		ETag:               aws.String(resp.Header.Get("Etag")),
+		CacheControl:       header("Cache-Control"),
+		StorageClass:       header("X-Amz-Storage-Class"),
+	}
+	if resp.ContentLength >= 0 {
+		out.ContentLength = aws.Int64(resp.ContentLength)
+	}
+	if mtime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		out.LastModified = &mtime
+	}
+	for name, values := range resp.Header {
+		if strings.HasPrefix(name, metaHeaderPrefix) && (len(values) > 0) {
+			if out.Metadata == nil {
+				out.Metadata = make(map[string]*string)
+			}
+			out.Metadata[strings.TrimPrefix(name, metaHeaderPrefix)] = aws.String(values[0])
+		}
+	}
+	return out, resp.Header, nil
+}
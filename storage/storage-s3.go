@@ -3,20 +3,43 @@ package storage
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/larrabee/ratelimit"
+	"hash/crc32"
 	"io"
+	"net"
+	"net/http"
 	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 )
 
+const crc32cHeader = "X-Amz-Checksum-Crc32c"
+
+// checksumHeaders are GetObject response headers of stored object checksums of all supported algorithms.
+var checksumHeaders = []string{
+	"X-Amz-Checksum-Crc32",
+	crc32cHeader,
+	"X-Amz-Checksum-Crc64nvme",
+	"X-Amz-Checksum-Sha1",
+	"X-Amz-Checksum-Sha256",
+}
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 // S3Storage configuration.
 type S3Storage struct {
 	awsSvc        *s3.S3
@@ -26,9 +49,24 @@ type S3Storage struct {
 	keysPerReq    int64
 	retryCnt      uint
 	retryInterval time.Duration
-	ctx           context.Context
-	listMarker    *string
 	rlBucket      ratelimit.Bucket
+	crc32c        bool
+	noLengthCheck bool
+	reqChecksum   bool
+	maxDepth      uint
+	prefixes      []string
+	transport     *http.Transport
+	startAfter    string
+	maxPages      uint
+	lastKey       string
+	truncated     bool
+	sse           *string
+	kmsKeyID      *string
+	kmsContext    *string
+	expectedOwner string
+	fetchOwner    bool
+	presignTTL    time.Duration
+	ifNoneMatch   string
 }
 
 // NewS3Storage return new configured S3 storage.
@@ -68,18 +106,12 @@ func NewS3Storage(awsAccessKey, awsSecretKey, awsRegion, endpoint, bucketName, p
 		keysPerReq:    keysPerReq,
 		retryCnt:      retryCnt,
 		retryInterval: retryInterval,
-		ctx:           context.TODO(),
 		rlBucket:      ratelimit.NewFakeBucket(),
 	}
 
 	return &storage
 }
 
-// WithContext add's context to storage.
-func (storage *S3Storage) WithContext(ctx context.Context) {
-	storage.ctx = ctx
-}
-
 // WithRateLimit set rate limit (bytes/sec) for storage.
 func (storage *S3Storage) WithRateLimit(limit int) error {
 	bucket, err := ratelimit.NewBucketWithRate(float64(limit), int64(limit))
@@ -90,49 +122,463 @@ func (storage *S3Storage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithMaxDepth limits listing to objects with given depth relative to the storage prefix, 0 means no limit.
+// Objects directly under the prefix have depth 1. Listing is done with "/" delimiter, so deeper prefixes are not listed at all.
+func (storage *S3Storage) WithMaxDepth(depth uint) {
+	storage.maxDepth = depth
+}
+
+// WithPrefixes limits listing to given prefixes relative to the storage prefix, like "app1/" and "app2/".
+// Prefixes are listed in parallel and objects of all prefixes are sent to the same chan, so object keys are the same
+// as with listing of the whole storage prefix. Prefixes should not overlap, empty list means the whole storage prefix.
+// Prefixes are ignored if WithMaxDepth is set.
+func (storage *S3Storage) WithPrefixes(prefixes []string) {
+	storage.prefixes = prefixes
+}
+
+// WithListStartAfter start listing after given key, like the last key of previous limited listing.
+// The key is the full S3 key including the storage prefix. It is ignored if WithMaxDepth or WithPrefixes is set.
+func (storage *S3Storage) WithListStartAfter(key string) {
+	storage.startAfter = key
+}
+
+// WithListMaxPages stop listing after given number of listing pages, 0 means no limit.
+// Every page contains up to keysPerReq objects. Use ListCursor to continue listing from the last listed key.
+// It is ignored if WithMaxDepth or WithPrefixes is set.
+func (storage *S3Storage) WithListMaxPages(pages uint) {
+	storage.maxPages = pages
+}
+
+// ListCursor return the last listed key and true if listing was stopped by WithListMaxPages limit before
+// the end of the storage prefix. It should be called after List is finished.
+func (storage *S3Storage) ListCursor() (string, bool) {
+	return storage.lastKey, storage.truncated
+}
+
+// WithRateLimitBucket set rate limit bucket for storage.
+// The bucket can be shared between storages to limit their total bandwidth.
+func (storage *S3Storage) WithRateLimitBucket(bucket ratelimit.Bucket) {
+	storage.rlBucket = bucket
+}
+
+// WithLengthCheck enable validation of downloaded objects size with Content-Length returned by S3,
+// mismatches are retried and returned as ContentLengthError. It is enabled by default.
+func (storage *S3Storage) WithLengthCheck(enabled bool) {
+	storage.noLengthCheck = !enabled
+}
+
+// WithCRC32CValidation enable validation of downloaded objects content with CRC32C checksum returned by S3.
+// Objects without stored CRC32C checksum and multipart objects with composite checksum are not validated.
+func (storage *S3Storage) WithCRC32CValidation(enabled bool) {
+	storage.crc32c = enabled
+}
+
+// WithRequireChecksum make downloads of objects without stored checksum of any algorithm fail with MissingChecksumError.
+// Only presence of checksum is checked, use WithCRC32CValidation to validate it.
+func (storage *S3Storage) WithRequireChecksum(enabled bool) {
+	storage.reqChecksum = enabled
+}
+
+// WithKMSEncryption make uploaded and copied objects encrypted with SSE-KMS using given KMS key ID or ARN.
+// Non-empty encryptionContext is passed to KMS as the encryption context of all objects.
+func (storage *S3Storage) WithKMSEncryption(keyID string, encryptionContext map[string]string) error {
+	storage.sse = aws.String(s3.ServerSideEncryptionAwsKms)
+	storage.kmsKeyID = aws.String(keyID)
+	if len(encryptionContext) > 0 {
+		data, err := json.Marshal(encryptionContext)
+		if err != nil {
+			return err
+		}
+		storage.kmsContext = aws.String(base64.StdEncoding.EncodeToString(data))
+	}
+	return nil
+}
+
+// kmsContextHeader return request option with X-Amz-Server-Side-Encryption-Context header of uploads and copies,
+// PutObjectInput and CopyObjectInput of the SDK have no encryption context field. It return nil without context.
+func (storage *S3Storage) kmsContextHeader() request.Option {
+	if storage.kmsContext == nil {
+		return nil
+	}
+	value := *storage.kmsContext
+	return func(r *request.Request) {
+		r.HTTPRequest.Header.Set("X-Amz-Server-Side-Encryption-Context", value)
+	}
+}
+
+// WithAWSConfigFile load credentials from given AWS config file instead of ~/.aws/config and ~/.aws/credentials.
+// The file is read like the SDK shared config, with AWS_PROFILE or "default" profile, so it can also configure
+// assume role or credential_process. Credentials from environment variables still take precedence.
+// If the storage is created without region, the region of the profile is used, us-east-1 if the profile has none.
+func (storage *S3Storage) WithAWSConfigFile(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		SharedConfigFiles: []string{path},
+	})
+	if err != nil {
+		return err
+	}
+	storage.awsSession.Config.Credentials = sess.Config.Credentials
+	if aws.StringValue(storage.awsSession.Config.Region) == "" {
+		region := aws.StringValue(sess.Config.Region)
+		if region == "" {
+			region = "us-east-1"
+		}
+		storage.awsSession.Config.Region = aws.String(region)
+	}
+	storage.awsSvc = storage.newService()
+	return nil
+}
+
+// WithAnonymous configure storage to send unsigned requests without credentials, like for public buckets.
+func (storage *S3Storage) WithAnonymous(enabled bool) {
+	if !enabled {
+		return
+	}
+	storage.awsSession.Config.Credentials = credentials.AnonymousCredentials
+	storage.awsSvc = storage.newService()
+}
+
+// WithEndpointDiscovery enable SDK endpoint discovery, it is needed only for S3-compatible services supporting it.
+func (storage *S3Storage) WithEndpointDiscovery(enabled bool) {
+	if !enabled {
+		return
+	}
+	storage.awsSession.Config.EnableEndpointDiscovery = aws.Bool(true)
+	storage.awsSvc = storage.newService()
+}
+
+// WithPathStyle select path-style (https://endpoint/bucket/key) or virtual-hosted-style (https://bucket.endpoint/key)
+// requests. Path-style requests are used by default.
+func (storage *S3Storage) WithPathStyle(enabled bool) {
+	storage.awsSession.Config.S3ForcePathStyle = aws.Bool(enabled)
+	storage.awsSvc = storage.newService()
+}
+
+// WithExpectedBucketOwner send x-amz-expected-bucket-owner header with every request, S3 rejects requests
+// with 403 Access Denied if the bucket is not owned by given AWS account ID. Server-side copies also send
+// x-amz-source-expected-bucket-owner, the source of them is the same bucket. Empty owner disables the check.
+func (storage *S3Storage) WithExpectedBucketOwner(owner string) {
+	storage.expectedOwner = owner
+	storage.awsSvc = storage.newService()
+}
+
+// newService return S3 client of the storage session with storage request handlers.
+func (storage *S3Storage) newService() *s3.S3 {
+	svc := s3.New(storage.awsSession)
+	if owner := storage.expectedOwner; owner != "" {
+		svc.Handlers.Build.PushBack(func(r *request.Request) {
+			r.HTTPRequest.Header.Set("X-Amz-Expected-Bucket-Owner", owner)
+			if (r.Operation.Name == "CopyObject") || (r.Operation.Name == "UploadPartCopy") {
+				r.HTTPRequest.Header.Set("X-Amz-Source-Expected-Bucket-Owner", owner)
+			}
+		})
+	}
+	return svc
+}
+
+// Session return AWS session of the storage. It can be used to create clients of other AWS services
+// with the same credentials, region and HTTP client, endpoint should be overridden for them.
+func (storage *S3Storage) Session() *session.Session {
+	return storage.awsSession
+}
+
+// WithTLSConfig set TLS configuration of connections to S3 endpoint, like custom CA or disabled certificate verification.
+func (storage *S3Storage) WithTLSConfig(cfg *tls.Config) {
+	storage.httpTransport().TLSClientConfig = cfg
+}
+
+// WithProxy send requests to S3 endpoint through given HTTP(S) or SOCKS5 proxy instead of the proxy from
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables. Proxy credentials can be given in URL user info.
+func (storage *S3Storage) WithProxy(proxy *url.URL) {
+	storage.httpTransport().Proxy = http.ProxyURL(proxy)
+}
+
+// OwnerMetaKey is the metadata key of source object owner ID added by S3 storage with WithFetchOwner.
+const OwnerMetaKey = "Owner"
+
+// WithFetchOwner add owner of listed objects to Object.Owner and owner ID to OwnerMetaKey metadata of
+// downloaded objects. Objects which are not listed, like objects of SQS events, have no owner.
+func (storage *S3Storage) WithFetchOwner(enabled bool) {
+	storage.fetchOwner = enabled
+}
+
+// httpTransport return transport of storage own HTTP client, the client is created on first call.
+// By default storage uses the SDK default HTTP client shared with other storages.
+func (storage *S3Storage) httpTransport() *http.Transport {
+	if storage.transport == nil {
+		storage.transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		}
+		storage.awsSession.Config.HTTPClient = &http.Client{Transport: storage.transport}
+		storage.awsSvc = storage.newService()
+	}
+	return storage.transport
+}
+
 // List S3 bucket and send founded objects to chan.
-func (storage *S3Storage) List(output chan<- *Object) error {
+func (storage *S3Storage) List(ctx context.Context, output chan<- *Object) error {
+	if storage.maxDepth > 0 {
+		return storage.listDepth(ctx, storage.prefix, output)
+	}
+	if len(storage.prefixes) == 0 {
+		return storage.listPages(ctx, output)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errChan := make(chan error, len(storage.prefixes))
+	for _, p := range storage.prefixes {
+		go func(prefix string) {
+			errChan <- storage.listPrefix(ctx, prefix, output)
+		}(joinPrefix(storage.prefix, p))
+	}
+	var err error
+	for range storage.prefixes {
+		if listErr := <-errChan; (listErr != nil) && (err == nil) {
+			err = listErr
+			cancel()
+		}
+	}
+	return err
+}
+
+// listPages list objects under storage prefix starting after WithListStartAfter key up to WithListMaxPages pages.
+// Without start key and pages limit it is the same as listPrefix.
+func (storage *S3Storage) listPages(ctx context.Context, output chan<- *Object) error {
+	if (storage.startAfter == "") && (storage.maxPages == 0) {
+		return storage.listPrefix(ctx, storage.prefix, output)
+	}
+	marker := aws.String(storage.startAfter)
+	var pages uint
 	listObjectsFn := func(p *s3.ListObjectsOutput, lastPage bool) bool {
 		for _, o := range p.Contents {
-			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
-			output <- &Object{
-				Key:          &key,
-				ETag:         strongEtag(o.ETag),
-				Mtime:        o.LastModified,
-				StorageClass: o.StorageClass,
+			obj := storage.listedObject(o)
+			select {
+			case output <- obj:
+				storage.lastKey, marker = *obj.Key, obj.Key
+			case <-ctx.Done():
+				return false
 			}
 		}
-		storage.listMarker = p.Marker
+		pages++
+		if !lastPage && (storage.maxPages > 0) && (pages >= storage.maxPages) {
+			storage.truncated = true
+			return false
+		}
 		return !lastPage // continue paging
 	}
 
-	for i := uint(0); ; i++ {
+	retry := listRetry{retryCnt: storage.retryCnt, retryInterval: storage.retryInterval}
+	for {
 		input := &s3.ListObjectsInput{
 			Bucket:       storage.awsBucket,
 			Prefix:       aws.String(storage.prefix),
 			MaxKeys:      aws.Int64(storage.keysPerReq),
 			EncodingType: aws.String(s3.EncodingTypeUrl),
-			Marker:       storage.listMarker,
 		}
-		err := storage.awsSvc.ListObjectsPagesWithContext(storage.ctx, input, listObjectsFn)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+		if aws.StringValue(marker) != "" {
+			input.Marker = marker
+		}
+		err := storage.awsSvc.ListObjectsPagesWithContext(ctx, input, listObjectsFn)
+		if err == nil {
+			// Paging is stopped without error if context is done while sending objects.
+			err = ctx.Err()
+		}
+		if err != nil {
+			if err := retry.wait(ctx, "S3 listing", pages+1, err); err != nil {
+				return err
+			}
 			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			return err
+		}
+		if storage.truncated {
+			Log.Debugf("Listing stopped after %d pages at key %s", pages, storage.lastKey)
 		} else {
 			Log.Debugf("Listing bucket finished")
+		}
+		return nil
+	}
+}
+
+// listPrefix list all objects under given prefix and send them to chan.
+func (storage *S3Storage) listPrefix(ctx context.Context, prefix string, output chan<- *Object) error {
+	// Failed listing is resumed after the last sent key, so objects of listed pages are not sent twice.
+	var marker *string
+	var pages uint
+	listObjectsFn := func(p *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range p.Contents {
+			obj := storage.listedObject(o)
+			select {
+			case output <- obj:
+				marker = obj.Key
+			case <-ctx.Done():
+				return false
+			}
+		}
+		pages++
+		return !lastPage // continue paging
+	}
+
+	retry := listRetry{retryCnt: storage.retryCnt, retryInterval: storage.retryInterval}
+	for {
+		input := &s3.ListObjectsInput{
+			Bucket:       storage.awsBucket,
+			Prefix:       aws.String(prefix),
+			MaxKeys:      aws.Int64(storage.keysPerReq),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
+			Marker:       marker,
+		}
+		err := storage.awsSvc.ListObjectsPagesWithContext(ctx, input, listObjectsFn)
+		if err == nil {
+			// Paging is stopped without error if context is done while sending objects.
+			err = ctx.Err()
+		}
+		if err != nil {
+			if err := retry.wait(ctx, "S3 listing of prefix "+prefix, pages+1, err); err != nil {
+				return err
+			}
+			continue
+		}
+		Log.Debugf("Listing of prefix %s finished", prefix)
+		return nil
+	}
+}
+
+// RelativeKey return listed key without the storage prefix, the reverse of PutObject key mapping.
+func (storage *S3Storage) RelativeKey(key string) (string, bool) {
+	return relativeKey(storage.prefix, key)
+}
+
+// relativeKey return key without prefix joined to it with filepath.Join, like keys of uploaded objects.
+func relativeKey(prefix, key string) (string, bool) {
+	prefix = filepath.Clean(prefix)
+	if (prefix == ".") || (prefix == string(filepath.Separator)) {
+		return key, true
+	}
+	prefix += string(filepath.Separator)
+	if !strings.HasPrefix(key, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(key, prefix), true
+}
+
+// joinPrefix return sub prefix of storage prefix.
+func joinPrefix(prefix, sub string) string {
+	if (prefix == "") || strings.HasSuffix(prefix, "/") {
+		return prefix + strings.TrimPrefix(sub, "/")
+	}
+	return prefix + "/" + strings.TrimPrefix(sub, "/")
+}
+
+// listDepth list objects under given prefix with "/" delimiter and descends into common prefixes up to storage.maxDepth.
+func (storage *S3Storage) listDepth(ctx context.Context, prefix string, output chan<- *Object) error {
+	var marker *string
+	var prefixes []string
+	var pages uint
+	listObjectsFn := func(p *s3.ListObjectsOutput, lastPage bool) bool {
+		for _, o := range p.Contents {
+			select {
+			case output <- storage.listedObject(o):
+			case <-ctx.Done():
+				return false
+			}
+		}
+		for _, cp := range p.CommonPrefixes {
+			cpKey, _ := url.QueryUnescape(aws.StringValue(cp.Prefix))
+			rel := strings.TrimPrefix(cpKey, storage.prefix)
+			// The source root itself can be returned as common prefix if source path has no trailing slash.
+			if strings.Trim(rel, "/") == "" || keyDepth(rel) < storage.maxDepth {
+				prefixes = append(prefixes, cpKey)
+			}
+		}
+		if p.NextMarker != nil {
+			next, _ := url.QueryUnescape(aws.StringValue(p.NextMarker))
+			marker = &next
+		}
+		pages++
+		return !lastPage // continue paging
+	}
+
+	retry := listRetry{retryCnt: storage.retryCnt, retryInterval: storage.retryInterval}
+	for {
+		input := &s3.ListObjectsInput{
+			Bucket:       storage.awsBucket,
+			Prefix:       aws.String(prefix),
+			Delimiter:    aws.String("/"),
+			MaxKeys:      aws.Int64(storage.keysPerReq),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
+			Marker:       marker,
+		}
+		err := storage.awsSvc.ListObjectsPagesWithContext(ctx, input, listObjectsFn)
+		if err == nil {
+			// Paging is stopped without error if context is done while sending objects.
+			err = ctx.Err()
+		}
+		if err != nil {
+			if err := retry.wait(ctx, "S3 listing of prefix "+prefix, pages+1, err); err != nil {
+				return err
+			}
+			continue
+		}
+		break
+	}
+
+	for _, p := range prefixes {
+		if err := storage.listDepth(ctx, p, output); err != nil {
 			return err
 		}
 	}
+	if prefix == storage.prefix {
+		Log.Debugf("Listing bucket finished")
+	}
+	return nil
+}
+
+// listedObject return new Object from S3 listing entry.
+func (storage *S3Storage) listedObject(o *s3.Object) *Object {
+	key, _ := url.QueryUnescape(aws.StringValue(o.Key))
+	obj := &Object{
+		Key:          &key,
+		Timings:      ObjectTimings{Listed: time.Now()},
+		ETag:         strongEtag(o.ETag),
+		Mtime:        o.LastModified,
+		Size:         o.Size,
+		StorageClass: o.StorageClass,
+	}
+	if storage.fetchOwner && (o.Owner != nil) {
+		obj.Owner = &ObjectOwner{ID: aws.StringValue(o.Owner.ID), DisplayName: aws.StringValue(o.Owner.DisplayName)}
+	}
+	return obj
+}
+
+// addOwnerMeta add owner ID of listed object to OwnerMetaKey metadata, if owner fetching is enabled.
+func (storage *S3Storage) addOwnerMeta(obj *Object) {
+	if !storage.fetchOwner || (obj.Owner == nil) || (obj.Owner.ID == "") {
+		return
+	}
+	if obj.Metadata == nil {
+		obj.Metadata = make(map[string]*string, 1)
+	}
+	obj.Metadata[OwnerMetaKey] = aws.String(obj.Owner.ID)
 }
 
 // PutObject saves object to S3.
-func (storage *S3Storage) PutObject(obj *Object) error {
+func (storage *S3Storage) PutObject(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	defer func() { obj.Timings.Upload = time.Since(start) }()
 	objReader := bytes.NewReader(*obj.Content)
-	rlReader := ratelimit.NewReadSeeker(objReader, storage.rlBucket)
+	rlReader := ratelimit.NewReadSeeker(objReader, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait})
 
 	input := &s3.PutObjectInput{
 		Bucket:             storage.awsBucket,
@@ -146,13 +592,29 @@ func (storage *S3Storage) PutObject(obj *Object) error {
 		Metadata:           obj.Metadata,
 		CacheControl:       obj.CacheControl,
 		StorageClass:       obj.StorageClass,
+
+		ServerSideEncryption: storage.sse,
+		SSEKMSKeyId:          storage.kmsKeyID,
+	}
+	var opts []request.Option
+	if condition := storage.putCondition(*obj.Content); condition != nil {
+		opts = append(opts, condition)
+	}
+	if header := storage.kmsContextHeader(); header != nil {
+		opts = append(opts, header)
 	}
 
 	for i := uint(0); ; i++ {
-		_, err := storage.awsSvc.PutObjectWithContext(storage.ctx, input)
+		obj.Attempts++
+		_, err := storage.awsSvc.PutObjectWithContext(ctx, input, opts...)
+		if isPreconditionFailed(err) {
+			return &PreconditionFailedError{Key: *obj.Key}
+		}
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj uploading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj uploading failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -163,33 +625,91 @@ func (storage *S3Storage) PutObject(obj *Object) error {
 }
 
 // GetObjectContent read object content and metadata from S3.
-func (storage *S3Storage) GetObjectContent(obj *Object) error {
+func (storage *S3Storage) GetObjectContent(ctx context.Context, obj *Object) error {
 	input := &s3.GetObjectInput{
 		Bucket: storage.awsBucket,
 		Key:    obj.Key,
 	}
 
+	var opts []request.Option
+	var checksum string
+	var header http.Header
+	if storage.crc32c {
+		opts = append(opts, withChecksumMode, request.WithGetResponseHeader(crc32cHeader, &checksum))
+	}
+	if storage.reqChecksum {
+		opts = append(opts, withChecksumMode, request.WithGetResponseHeaders(&header))
+	}
+
 	for i := uint(0); ; i++ {
-		result, err := storage.awsSvc.GetObjectWithContext(storage.ctx, input)
+		checksum = ""
+		header = nil
+		obj.Attempts++
+		start := time.Now()
+		var result *s3.GetObjectOutput
+		var err error
+		if storage.presignTTL > 0 {
+			result, header, err = storage.presignedGetObject(ctx, input)
+			checksum = header.Get(crc32cHeader)
+		} else {
+			result, err = storage.awsSvc.GetObjectWithContext(ctx, input, opts...)
+		}
+		obj.Timings.SourceTTFB = time.Since(start)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj content downloading request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
 		}
 
+		if storage.reqChecksum && !hasChecksum(header) {
+			_ = result.Body.Close()
+			return &MissingChecksumError{Key: *obj.Key}
+		}
+
 		buf := bytes.NewBuffer(make([]byte, 0, aws.Int64Value(result.ContentLength)))
-		_, err = io.Copy(ratelimit.NewWriter(buf, storage.rlBucket), result.Body)
+		start = time.Now()
+		_, err = io.Copy(ratelimit.NewWriter(buf, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait}), result.Body)
+		obj.Timings.Download = time.Since(start)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj content downloading failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
 		}
 
 		data := buf.Bytes()
+		if !storage.noLengthCheck {
+			err = checkContentLength(*obj.Key, data, result.ContentLength)
+			if (err != nil) && (i < storage.retryCnt) {
+				Log.Warnf("S3 obj content validation failed with error: %s, retrying", err)
+				if err := sleepContext(ctx, storage.retryInterval); err != nil {
+					return err
+				}
+				continue
+			} else if (err != nil) && (i == storage.retryCnt) {
+				return err
+			}
+		}
+		if storage.crc32c && checksum != "" {
+			err = validateCRC32C(*obj.Key, data, checksum)
+			if (err != nil) && (i < storage.retryCnt) {
+				Log.Warnf("S3 obj content validation failed with error: %s, retrying", err)
+				if err := sleepContext(ctx, storage.retryInterval); err != nil {
+					return err
+				}
+				continue
+			} else if (err != nil) && (i == storage.retryCnt) {
+				return err
+			}
+		}
+
 		obj.Content = &data
 		obj.ContentType = result.ContentType
 		obj.ContentDisposition = result.ContentDisposition
@@ -197,7 +717,9 @@ func (storage *S3Storage) GetObjectContent(obj *Object) error {
 		obj.ContentLanguage = result.ContentLanguage
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
+		storage.addOwnerMeta(obj)
 		obj.Mtime = result.LastModified
+		obj.Size = result.ContentLength
 		obj.CacheControl = result.CacheControl
 		obj.StorageClass = result.StorageClass
 
@@ -205,18 +727,108 @@ func (storage *S3Storage) GetObjectContent(obj *Object) error {
 	}
 }
 
+// SelectObjectContent filter object content with S3 Select and set object content to the matched rows.
+// If no rows matched, object content will be empty.
+// CSV and JSON objects return rows in the input format, Parquet objects return JSON rows.
+// The first line of CSV objects is used as a header with column names, it is not returned with the rows.
+func (storage *S3Storage) SelectObjectContent(ctx context.Context, obj *Object, query SelectQuery) error {
+	input := &s3.SelectObjectContentInput{
+		Bucket:         storage.awsBucket,
+		Key:            obj.Key,
+		Expression:     aws.String(query.Expression),
+		ExpressionType: aws.String(s3.ExpressionTypeSql),
+		InputSerialization: &s3.InputSerialization{
+			CompressionType: aws.String(query.Compression),
+		},
+		OutputSerialization: &s3.OutputSerialization{},
+	}
+	switch query.InputFormat {
+	case "CSV":
+		input.InputSerialization.CSV = &s3.CSVInput{FileHeaderInfo: aws.String(s3.FileHeaderInfoUse)}
+		input.OutputSerialization.CSV = &s3.CSVOutput{}
+	case "JSON":
+		input.InputSerialization.JSON = &s3.JSONInput{Type: aws.String(s3.JSONTypeLines)}
+		input.OutputSerialization.JSON = &s3.JSONOutput{}
+	case "Parquet":
+		input.InputSerialization.Parquet = &s3.ParquetInput{}
+		input.OutputSerialization.JSON = &s3.JSONOutput{}
+	}
+
+	for i := uint(0); ; i++ {
+		obj.Attempts++
+		result, err := storage.awsSvc.SelectObjectContentWithContext(ctx, input)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj select request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		buf := bytes.NewBuffer(nil)
+		w := ratelimit.NewWriter(buf, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait})
+		for event := range result.EventStream.Events() {
+			if records, ok := event.(*s3.RecordsEvent); ok {
+				if _, err = w.Write(records.Payload); err != nil {
+					break
+				}
+			}
+		}
+		_ = result.EventStream.Close()
+		if err == nil {
+			err = result.EventStream.Err()
+		}
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj select failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		data := buf.Bytes()
+		obj.Content = &data
+		return nil
+	}
+}
+
+// archiveStatusHeader return request option storing x-amz-archive-status header of HeadObject response to status,
+// HeadObjectOutput of the SDK has no archive status field. Status is nil if the header is absent.
+func archiveStatusHeader(status **string) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(req *request.Request) {
+			*status = nil
+			if req.HTTPResponse == nil {
+				return
+			}
+			if value := req.HTTPResponse.Header.Get("X-Amz-Archive-Status"); value != "" {
+				*status = aws.String(value)
+			}
+		})
+	}
+}
+
 // GetObjectMeta update object metadata from S3.
-func (storage *S3Storage) GetObjectMeta(obj *Object) error {
+func (storage *S3Storage) GetObjectMeta(ctx context.Context, obj *Object) error {
 	input := &s3.HeadObjectInput{
 		Bucket: storage.awsBucket,
 		Key:    obj.Key,
 	}
 
+	start := time.Now()
+	defer func() { obj.Timings.Meta += time.Since(start) }()
+
 	for i := uint(0); ; i++ {
-		result, err := storage.awsSvc.HeadObjectWithContext(storage.ctx, input)
+		result, err := storage.awsSvc.HeadObjectWithContext(ctx, input, archiveStatusHeader(&obj.ArchiveStatus))
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj meta downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj meta downloading request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -228,7 +840,9 @@ func (storage *S3Storage) GetObjectMeta(obj *Object) error {
 		obj.ContentLanguage = result.ContentLanguage
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
+		storage.addOwnerMeta(obj)
 		obj.Mtime = result.LastModified
+		obj.Size = result.ContentLength
 		obj.CacheControl = result.CacheControl
 		obj.StorageClass = result.StorageClass
 
@@ -236,18 +850,418 @@ func (storage *S3Storage) GetObjectMeta(obj *Object) error {
 	}
 }
 
+// ListBuckets return names of buckets starting with prefix, at most max names.
+// Storage bucket and prefix are not used, it lists all buckets of the account.
+func (storage *S3Storage) ListBuckets(ctx context.Context, prefix string, max int) ([]string, error) {
+	result, err := storage.awsSvc.ListBucketsWithContext(ctx, &s3.ListBucketsInput{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(result.Buckets))
+	for _, bucket := range result.Buckets {
+		name := aws.StringValue(bucket.Name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if len(names) == max {
+			break
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// ListCommonPrefixes return keys and common prefixes ("directories") of storage bucket starting with prefix,
+// up to the next "/" after it, at most max items. Only one List request is made and it is not retried.
+func (storage *S3Storage) ListCommonPrefixes(ctx context.Context, prefix string, max int) ([]string, error) {
+	input := &s3.ListObjectsInput{
+		Bucket:    storage.awsBucket,
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int64(int64(max)),
+	}
+	result, err := storage.awsSvc.ListObjectsWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, 0, len(result.CommonPrefixes)+len(result.Contents))
+	for _, p := range result.CommonPrefixes {
+		items = append(items, aws.StringValue(p.Prefix))
+	}
+	for _, obj := range result.Contents {
+		items = append(items, aws.StringValue(obj.Key))
+	}
+	if len(items) > max {
+		items = items[:max]
+	}
+	return items, nil
+}
+
+// Services of S3 event notification destinations.
+const (
+	NotificationSNS    = "sns"
+	NotificationSQS    = "sqs"
+	NotificationLambda = "lambda"
+)
+
+// NotificationEvent is the event type of notifications added by PutBucketNotification.
+const NotificationEvent = "s3:ObjectCreated:*"
+
+// NotificationService return service of S3 event notification destination ARN:
+// NotificationSNS for SNS topic, NotificationSQS for SQS queue or NotificationLambda for Lambda function.
+func NotificationService(destARN string) (string, error) {
+	parts := strings.SplitN(destARN, ":", 6)
+	if (len(parts) != 6) || (parts[0] != "arn") || (parts[5] == "") {
+		return "", fmt.Errorf("invalid ARN %q", destARN)
+	}
+	switch parts[2] {
+	case NotificationSNS, NotificationSQS, NotificationLambda:
+		return parts[2], nil
+	}
+	return "", fmt.Errorf("unsupported notification destination %q, should be ARN of SNS topic, SQS queue or Lambda function", destARN)
+}
+
+// PutBucketNotification add notification of NotificationEvent events of objects under storage prefix
+// to destination destARN into the bucket notification configuration. Existing notifications are kept.
+// The configuration is not changed if it already has the same notification, it return true if it is updated.
+func (storage *S3Storage) PutBucketNotification(ctx context.Context, destARN string) (bool, error) {
+	service, err := NotificationService(destARN)
+	if err != nil {
+		return false, err
+	}
+	cfg, err := storage.awsSvc.GetBucketNotificationConfigurationWithContext(ctx, &s3.GetBucketNotificationConfigurationRequest{
+		Bucket: storage.awsBucket,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	var filter *s3.NotificationConfigurationFilter
+	if storage.prefix != "" {
+		filter = &s3.NotificationConfigurationFilter{Key: &s3.KeyFilter{FilterRules: []*s3.FilterRule{
+			{Name: aws.String("prefix"), Value: aws.String(storage.prefix)},
+		}}}
+	}
+	events := []*string{aws.String(NotificationEvent)}
+
+	switch service {
+	case NotificationSNS:
+		for _, c := range cfg.TopicConfigurations {
+			if (aws.StringValue(c.TopicArn) == destARN) && storage.isNotification(c.Events, c.Filter) {
+				return false, nil
+			}
+		}
+		cfg.TopicConfigurations = append(cfg.TopicConfigurations, &s3.TopicConfiguration{TopicArn: aws.String(destARN), Events: events, Filter: filter})
+	case NotificationSQS:
+		for _, c := range cfg.QueueConfigurations {
+			if (aws.StringValue(c.QueueArn) == destARN) && storage.isNotification(c.Events, c.Filter) {
+				return false, nil
+			}
+		}
+		cfg.QueueConfigurations = append(cfg.QueueConfigurations, &s3.QueueConfiguration{QueueArn: aws.String(destARN), Events: events, Filter: filter})
+	case NotificationLambda:
+		for _, c := range cfg.LambdaFunctionConfigurations {
+			if (aws.StringValue(c.LambdaFunctionArn) == destARN) && storage.isNotification(c.Events, c.Filter) {
+				return false, nil
+			}
+		}
+		cfg.LambdaFunctionConfigurations = append(cfg.LambdaFunctionConfigurations, &s3.LambdaFunctionConfiguration{LambdaFunctionArn: aws.String(destARN), Events: events, Filter: filter})
+	}
+
+	_, err = storage.awsSvc.PutBucketNotificationConfigurationWithContext(ctx, &s3.PutBucketNotificationConfigurationInput{
+		Bucket:                    storage.awsBucket,
+		NotificationConfiguration: cfg,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SuspendVersioning suspend versioning of the bucket, it return true if versioning was enabled.
+// Buckets which never had versioning enabled are not changed.
+func (storage *S3Storage) SuspendVersioning(ctx context.Context) (bool, error) {
+	cfg, err := storage.awsSvc.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{
+		Bucket: storage.awsBucket,
+	})
+	if err != nil {
+		return false, err
+	}
+	if aws.StringValue(cfg.Status) != s3.BucketVersioningStatusEnabled {
+		return false, nil
+	}
+
+	_, err = storage.awsSvc.PutBucketVersioningWithContext(ctx, &s3.PutBucketVersioningInput{
+		Bucket: storage.awsBucket,
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(s3.BucketVersioningStatusSuspended),
+		},
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// isNotification check if notification with given events and filter is the notification added by PutBucketNotification:
+// it has NotificationEvent and the only filter rule is the storage prefix.
+func (storage *S3Storage) isNotification(events []*string, filter *s3.NotificationConfigurationFilter) bool {
+	hasEvent := false
+	for _, e := range events {
+		if aws.StringValue(e) == NotificationEvent {
+			hasEvent = true
+		}
+	}
+	if !hasEvent {
+		return false
+	}
+	prefix := ""
+	if (filter != nil) && (filter.Key != nil) {
+		for _, rule := range filter.Key.FilterRules {
+			if !strings.EqualFold(aws.StringValue(rule.Name), "prefix") {
+				return false
+			}
+			prefix = aws.StringValue(rule.Value)
+		}
+	}
+	return prefix == storage.prefix
+}
+
+// GetObjectTags read object tags to obj.Tags with GetObjectTagging request.
+func (storage *S3Storage) GetObjectTags(ctx context.Context, obj *Object) error {
+	input := &s3.GetObjectTaggingInput{
+		Bucket:    storage.awsBucket,
+		Key:       obj.Key,
+		VersionId: obj.VersionId,
+	}
+
+	start := time.Now()
+	defer func() { obj.Timings.Meta += time.Since(start) }()
+
+	for i := uint(0); ; i++ {
+		result, err := storage.awsSvc.GetObjectTaggingWithContext(ctx, input)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj tags downloading request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		obj.Tags = make(map[string]string, len(result.TagSet))
+		for _, tag := range result.TagSet {
+			obj.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+		return nil
+	}
+}
+
+// CopyObject copy object to dstKey in the same bucket with server-side copy.
+// Object metadata is copied, ACL and storage class are taken from obj.
+func (storage *S3Storage) CopyObject(ctx context.Context, obj *Object, dstKey string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:       storage.awsBucket,
+		CopySource:   aws.String(url.PathEscape(*storage.awsBucket + "/" + *obj.Key)),
+		Key:          aws.String(dstKey),
+		ACL:          obj.ACL,
+		StorageClass: obj.StorageClass,
+
+		ServerSideEncryption: storage.sse,
+		SSEKMSKeyId:          storage.kmsKeyID,
+	}
+	var opts []request.Option
+	if header := storage.kmsContextHeader(); header != nil {
+		opts = append(opts, header)
+	}
+
+	for i := uint(0); ; i++ {
+		_, err := storage.awsSvc.CopyObjectWithContext(ctx, input, opts...)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj copying failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// PutObjectCopy write obj to the storage with server-side copy of existing object srcKey instead of content upload.
+// Both keys are relative to storage prefix, metadata of copy is replaced with metadata of obj.
+func (storage *S3Storage) PutObjectCopy(ctx context.Context, obj *Object, srcKey string) error {
+	start := time.Now()
+	defer func() { obj.Timings.Upload = time.Since(start) }()
+	input := &s3.CopyObjectInput{
+		Bucket:             storage.awsBucket,
+		CopySource:         aws.String(url.PathEscape(*storage.awsBucket + "/" + filepath.Join(storage.prefix, srcKey))),
+		Key:                aws.String(filepath.Join(storage.prefix, *obj.Key)),
+		MetadataDirective:  aws.String(s3.MetadataDirectiveReplace),
+		ContentType:        obj.ContentType,
+		ContentDisposition: obj.ContentDisposition,
+		ContentEncoding:    obj.ContentEncoding,
+		ContentLanguage:    obj.ContentLanguage,
+		ACL:                obj.ACL,
+		Metadata:           obj.Metadata,
+		CacheControl:       obj.CacheControl,
+		StorageClass:       obj.StorageClass,
+
+		ServerSideEncryption: storage.sse,
+		SSEKMSKeyId:          storage.kmsKeyID,
+	}
+	var opts []request.Option
+	if header := storage.kmsContextHeader(); header != nil {
+		opts = append(opts, header)
+	}
+
+	for i := uint(0); ; i++ {
+		obj.Attempts++
+		_, err := storage.awsSvc.CopyObjectWithContext(ctx, input, opts...)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj copying failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// PutObjectACL set canned ACL of existing object.
+func (storage *S3Storage) PutObjectACL(ctx context.Context, obj *Object, acl string) error {
+	input := &s3.PutObjectAclInput{
+		Bucket: storage.awsBucket,
+		Key:    obj.Key,
+		ACL:    aws.String(acl),
+	}
+
+	for i := uint(0); ; i++ {
+		_, err := storage.awsSvc.PutObjectAclWithContext(ctx, input)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj ACL updating failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		return nil
+	}
+}
+
+// GetObjectGrants return owner and grants of object ACL.
+func (storage *S3Storage) GetObjectGrants(ctx context.Context, obj *Object) (*ObjectACL, error) {
+	input := &s3.GetObjectAclInput{
+		Bucket: storage.awsBucket,
+		Key:    obj.Key,
+	}
+
+	for i := uint(0); ; i++ {
+		result, err := storage.awsSvc.GetObjectAclWithContext(ctx, input)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj ACL downloading failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return nil, err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return nil, err
+		}
+
+		acl := &ObjectACL{Grants: make([]ObjectGrant, 0, len(result.Grants))}
+		if result.Owner != nil {
+			acl.Owner = &ObjectOwner{ID: aws.StringValue(result.Owner.ID), DisplayName: aws.StringValue(result.Owner.DisplayName)}
+		}
+		for _, g := range result.Grants {
+			grant := ObjectGrant{Permission: aws.StringValue(g.Permission)}
+			if g.Grantee != nil {
+				grant.Type = aws.StringValue(g.Grantee.Type)
+				grant.ID = aws.StringValue(g.Grantee.ID)
+				grant.DisplayName = aws.StringValue(g.Grantee.DisplayName)
+				grant.URI = aws.StringValue(g.Grantee.URI)
+				grant.Email = aws.StringValue(g.Grantee.EmailAddress)
+			}
+			acl.Grants = append(acl.Grants, grant)
+		}
+		return acl, nil
+	}
+}
+
+// PutObjectGrants replace ACL of existing object with given owner and grants. Owner of the ACL should be
+// the owner of the object.
+func (storage *S3Storage) PutObjectGrants(ctx context.Context, obj *Object, acl *ObjectACL) error {
+	policy := &s3.AccessControlPolicy{Grants: make([]*s3.Grant, 0, len(acl.Grants))}
+	if acl.Owner != nil {
+		policy.Owner = &s3.Owner{ID: aws.String(acl.Owner.ID)}
+		if acl.Owner.DisplayName != "" {
+			policy.Owner.DisplayName = aws.String(acl.Owner.DisplayName)
+		}
+	}
+	optional := func(s string) *string {
+		if s == "" {
+			return nil
+		}
+		return aws.String(s)
+	}
+	for _, g := range acl.Grants {
+		policy.Grants = append(policy.Grants, &s3.Grant{
+			Permission: aws.String(g.Permission),
+			Grantee: &s3.Grantee{
+				Type:         aws.String(g.Type),
+				ID:           optional(g.ID),
+				DisplayName:  optional(g.DisplayName),
+				URI:          optional(g.URI),
+				EmailAddress: optional(g.Email),
+			},
+		})
+	}
+	input := &s3.PutObjectAclInput{
+		Bucket:              storage.awsBucket,
+		Key:                 obj.Key,
+		AccessControlPolicy: policy,
+	}
+
+	for i := uint(0); ; i++ {
+		_, err := storage.awsSvc.PutObjectAclWithContext(ctx, input)
+		if (err != nil) && (i < storage.retryCnt) {
+			Log.Warnf("S3 obj ACL updating failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
+			continue
+		} else if (err != nil) && (i == storage.retryCnt) {
+			return err
+		}
+
+		return nil
+	}
+}
+
 // DeleteObject remove object from S3.
-func (storage *S3Storage) DeleteObject(obj *Object) error {
+func (storage *S3Storage) DeleteObject(ctx context.Context, obj *Object) error {
 	input := &s3.DeleteObjectInput{
 		Bucket: storage.awsBucket,
 		Key:    obj.Key,
 	}
 
 	for i := uint(0); ; i++ {
-		_, err := storage.awsSvc.DeleteObjectWithContext(storage.ctx, input)
+		_, err := storage.awsSvc.DeleteObjectWithContext(ctx, input)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj removing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj removing failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -269,3 +1283,35 @@ func strongEtag(s *string) *string {
 	etag := strings.TrimPrefix(*s, "W/")
 	return &etag
 }
+
+// withChecksumMode ask S3 to return stored object checksums in GetObject response headers.
+func withChecksumMode(r *request.Request) {
+	r.HTTPRequest.Header.Set("X-Amz-Checksum-Mode", "ENABLED")
+}
+
+// hasChecksum checks if response headers contain stored object checksum of any algorithm.
+func hasChecksum(header http.Header) bool {
+	for _, name := range checksumHeaders {
+		if header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCRC32C compare CRC32C checksum of data with base64 encoded checksum returned by S3.
+// Multipart objects have composite checksums, like "base64==-N", checksums of part checksums. Part boundaries
+// are unknown after the download, so such objects are not validated.
+func validateCRC32C(key string, data []byte, expected string) error {
+	if strings.Contains(expected, "-") {
+		Log.Debugf("Skip CRC32C validation of multipart object %s with composite checksum %s", key, expected)
+		return nil
+	}
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, crc32.Checksum(data, crc32cTable))
+	actual := base64.StdEncoding.EncodeToString(sum)
+	if actual != expected {
+		return &ChecksumMismatchError{Key: key, Expected: expected, Actual: actual}
+	}
+	return nil
+}
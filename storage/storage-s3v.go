@@ -7,6 +7,7 @@ import (
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/credentials/ec2rolecreds"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/larrabee/ratelimit"
@@ -25,9 +26,11 @@ type S3vStorage struct {
 	keysPerReq    int64
 	retryCnt      uint
 	retryInterval time.Duration
-	ctx           context.Context
+	keyMarker     *string
 	listMarker    *string
 	rlBucket      ratelimit.Bucket
+	crc32c        bool
+	noLengthCheck bool
 }
 
 // NewS3vStorage return new configured S3 storage.
@@ -67,18 +70,12 @@ func NewS3vStorage(awsAccessKey, awsSecretKey, awsRegion, endpoint, bucketName,
 		keysPerReq:    keysPerReq,
 		retryCnt:      retryCnt,
 		retryInterval: retryInterval,
-		ctx:           context.TODO(),
 		rlBucket:      ratelimit.NewFakeBucket(),
 	}
 
 	return &storage
 }
 
-// WithContext add's context to storage.
-func (storage *S3vStorage) WithContext(ctx context.Context) {
-	storage.ctx = ctx
-}
-
 // WithRateLimit set rate limit (bytes/sec) for storage.
 func (storage *S3vStorage) WithRateLimit(limit int) error {
 	bucket, err := ratelimit.NewBucketWithRate(float64(limit), int64(limit))
@@ -89,52 +86,88 @@ func (storage *S3vStorage) WithRateLimit(limit int) error {
 	return nil
 }
 
+// WithRateLimitBucket set rate limit bucket for storage.
+// The bucket can be shared between storages to limit their total bandwidth.
+func (storage *S3vStorage) WithRateLimitBucket(bucket ratelimit.Bucket) {
+	storage.rlBucket = bucket
+}
+
+// WithLengthCheck enable validation of downloaded objects size with Content-Length returned by S3,
+// mismatches are retried and returned as ContentLengthError. It is enabled by default.
+func (storage *S3vStorage) WithLengthCheck(enabled bool) {
+	storage.noLengthCheck = !enabled
+}
+
+// WithCRC32CValidation enable validation of downloaded objects content with CRC32C checksum returned by S3.
+// Objects without stored CRC32C checksum and multipart objects with composite checksum are not validated.
+func (storage *S3vStorage) WithCRC32CValidation(enabled bool) {
+	storage.crc32c = enabled
+}
+
 // List S3 bucket and send founded objects versions to chan.
-func (storage *S3vStorage) List(output chan<- *Object) error {
+func (storage *S3vStorage) List(ctx context.Context, output chan<- *Object) error {
+	var pages uint
 	listObjectsFn := func(p *s3.ListObjectVersionsOutput, lastPage bool) bool {
 		for _, o := range p.Versions {
 			key, _ := url.QueryUnescape(aws.StringValue(o.Key))
-			output <- &Object{
+			obj := &Object{
 				Key:          &key,
+				Timings:      ObjectTimings{Listed: time.Now()},
 				VersionId:    o.VersionId,
 				ETag:         strongEtag(o.ETag),
 				Mtime:        o.LastModified,
+				Size:         o.Size,
 				IsLatest:     o.IsLatest,
 				StorageClass: o.StorageClass,
 			}
+			select {
+			case output <- obj:
+			case <-ctx.Done():
+				return false
+			}
+		}
+		// Failed listing is resumed after the last listed page.
+		if p.NextKeyMarker != nil {
+			next, _ := url.QueryUnescape(aws.StringValue(p.NextKeyMarker))
+			storage.keyMarker, storage.listMarker = &next, p.NextVersionIdMarker
 		}
-		storage.listMarker = p.VersionIdMarker
+		pages++
 		return !lastPage // continue paging
 	}
 
-	for i := uint(0); ; i++ {
+	retry := listRetry{retryCnt: storage.retryCnt, retryInterval: storage.retryInterval}
+	for {
 		input := &s3.ListObjectVersionsInput{
 			Bucket:          storage.awsBucket,
 			Prefix:          aws.String(storage.prefix),
 			MaxKeys:         aws.Int64(storage.keysPerReq),
 			EncodingType:    aws.String(s3.EncodingTypeUrl),
+			KeyMarker:       storage.keyMarker,
 			VersionIdMarker: storage.listMarker,
 		}
-		err := storage.awsSvc.ListObjectVersionsPagesWithContext(storage.ctx, input, listObjectsFn)
-		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+		err := storage.awsSvc.ListObjectVersionsPagesWithContext(ctx, input, listObjectsFn)
+		if err == nil {
+			// Paging is stopped without error if context is done while sending objects.
+			err = ctx.Err()
+		}
+		if err != nil {
+			if err := retry.wait(ctx, "S3 listing", pages+1, err); err != nil {
+				return err
+			}
 			continue
-		} else if (err != nil) && (i == storage.retryCnt) {
-			Log.Debugf("S3 listing failed with error: %s", err)
-			return err
-		} else {
-			Log.Debugf("Listing bucket finished")
-			return err
 		}
+		Log.Debugf("Listing bucket finished")
+		return nil
 	}
 }
 
 // PutObject saves object to S3.
 // PutObject ignore VersionId, it always save object as latest version.
-func (storage *S3vStorage) PutObject(obj *Object) error {
+func (storage *S3vStorage) PutObject(ctx context.Context, obj *Object) error {
+	start := time.Now()
+	defer func() { obj.Timings.Upload = time.Since(start) }()
 	objReader := bytes.NewReader(*obj.Content)
-	rlReader := ratelimit.NewReadSeeker(objReader, storage.rlBucket)
+	rlReader := ratelimit.NewReadSeeker(objReader, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait})
 
 	input := &s3.PutObjectInput{
 		Bucket:             storage.awsBucket,
@@ -151,10 +184,13 @@ func (storage *S3vStorage) PutObject(obj *Object) error {
 	}
 
 	for i := uint(0); ; i++ {
-		_, err := storage.awsSvc.PutObjectWithContext(storage.ctx, input)
+		obj.Attempts++
+		_, err := storage.awsSvc.PutObjectWithContext(ctx, input)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj uploading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj uploading failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -165,34 +201,75 @@ func (storage *S3vStorage) PutObject(obj *Object) error {
 }
 
 // GetObjectContent read object content and metadata from S3.
-func (storage *S3vStorage) GetObjectContent(obj *Object) error {
+func (storage *S3vStorage) GetObjectContent(ctx context.Context, obj *Object) error {
 	input := &s3.GetObjectInput{
 		Bucket:    storage.awsBucket,
 		Key:       obj.Key,
 		VersionId: obj.VersionId,
 	}
 
+	var opts []request.Option
+	var checksum string
+	if storage.crc32c {
+		opts = append(opts, withChecksumMode, request.WithGetResponseHeader(crc32cHeader, &checksum))
+	}
+
 	for i := uint(0); ; i++ {
-		result, err := storage.awsSvc.GetObjectWithContext(storage.ctx, input)
+		checksum = ""
+		obj.Attempts++
+		start := time.Now()
+		result, err := storage.awsSvc.GetObjectWithContext(ctx, input, opts...)
+		obj.Timings.SourceTTFB = time.Since(start)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj content downloading request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
 		}
 
 		buf := bytes.NewBuffer(make([]byte, 0, aws.Int64Value(result.ContentLength)))
-		_, err = io.Copy(ratelimit.NewWriter(buf, storage.rlBucket), result.Body)
+		start = time.Now()
+		_, err = io.Copy(ratelimit.NewWriter(buf, timedBucket{storage.rlBucket, &obj.Timings.LimiterWait}), result.Body)
+		obj.Timings.Download = time.Since(start)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj content downloading failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj content downloading failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
 		}
 
 		data := buf.Bytes()
+		if !storage.noLengthCheck {
+			err = checkContentLength(*obj.Key, data, result.ContentLength)
+			if (err != nil) && (i < storage.retryCnt) {
+				Log.Warnf("S3 obj content validation failed with error: %s, retrying", err)
+				if err := sleepContext(ctx, storage.retryInterval); err != nil {
+					return err
+				}
+				continue
+			} else if (err != nil) && (i == storage.retryCnt) {
+				return err
+			}
+		}
+		if storage.crc32c && checksum != "" {
+			err = validateCRC32C(*obj.Key, data, checksum)
+			if (err != nil) && (i < storage.retryCnt) {
+				Log.Warnf("S3 obj content validation failed with error: %s, retrying", err)
+				if err := sleepContext(ctx, storage.retryInterval); err != nil {
+					return err
+				}
+				continue
+			} else if (err != nil) && (i == storage.retryCnt) {
+				return err
+			}
+		}
+
 		obj.Content = &data
 		obj.ContentType = result.ContentType
 		obj.ContentDisposition = result.ContentDisposition
@@ -201,6 +278,7 @@ func (storage *S3vStorage) GetObjectContent(obj *Object) error {
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
 		obj.Mtime = result.LastModified
+		obj.Size = result.ContentLength
 		obj.CacheControl = result.CacheControl
 		obj.StorageClass = result.StorageClass
 
@@ -209,18 +287,23 @@ func (storage *S3vStorage) GetObjectContent(obj *Object) error {
 }
 
 // GetObjectMeta update object metadata from S3.
-func (storage *S3vStorage) GetObjectMeta(obj *Object) error {
+func (storage *S3vStorage) GetObjectMeta(ctx context.Context, obj *Object) error {
 	input := &s3.HeadObjectInput{
 		Bucket:    storage.awsBucket,
 		Key:       obj.Key,
 		VersionId: obj.VersionId,
 	}
 
+	start := time.Now()
+	defer func() { obj.Timings.Meta += time.Since(start) }()
+
 	for i := uint(0); ; i++ {
-		result, err := storage.awsSvc.HeadObjectWithContext(storage.ctx, input)
+		result, err := storage.awsSvc.HeadObjectWithContext(ctx, input, archiveStatusHeader(&obj.ArchiveStatus))
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj meta downloading request failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj meta downloading request failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -233,6 +316,7 @@ func (storage *S3vStorage) GetObjectMeta(obj *Object) error {
 		obj.ETag = strongEtag(result.ETag)
 		obj.Metadata = result.Metadata
 		obj.Mtime = result.LastModified
+		obj.Size = result.ContentLength
 		obj.CacheControl = result.CacheControl
 		obj.StorageClass = result.StorageClass
 
@@ -240,8 +324,13 @@ func (storage *S3vStorage) GetObjectMeta(obj *Object) error {
 	}
 }
 
+// RelativeKey return listed key without the storage prefix, the reverse of PutObject key mapping.
+func (storage *S3vStorage) RelativeKey(key string) (string, bool) {
+	return relativeKey(storage.prefix, key)
+}
+
 // DeleteObject remove object from S3.
-func (storage *S3vStorage) DeleteObject(obj *Object) error {
+func (storage *S3vStorage) DeleteObject(ctx context.Context, obj *Object) error {
 	input := &s3.DeleteObjectInput{
 		Bucket:    storage.awsBucket,
 		Key:       obj.Key,
@@ -249,10 +338,12 @@ func (storage *S3vStorage) DeleteObject(obj *Object) error {
 	}
 
 	for i := uint(0); ; i++ {
-		_, err := storage.awsSvc.DeleteObjectWithContext(storage.ctx, input)
+		_, err := storage.awsSvc.DeleteObjectWithContext(ctx, input)
 		if (err != nil) && (i < storage.retryCnt) {
-			Log.Debugf("S3 obj removing failed with error: %s", err)
-			time.Sleep(storage.retryInterval)
+			Log.Warnf("S3 obj removing failed with error: %s, retrying", err)
+			if err := sleepContext(ctx, storage.retryInterval); err != nil {
+				return err
+			}
 			continue
 		} else if (err != nil) && (i == storage.retryCnt) {
 			return err
@@ -3,7 +3,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"github.com/larrabee/ratelimit"
 	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -18,6 +25,7 @@ const (
 	TypeS3 Type = iota + 1
 	TypeS3Versioned
 	TypeFS
+	TypeNull
 )
 
 // Object contain content and metadata of S3 object.
@@ -25,6 +33,7 @@ type Object struct {
 	Key                *string            `json:"-"`
 	ETag               *string            `json:"e_tag"`
 	Mtime              *time.Time         `json:"mtime"`
+	Size               *int64             `json:"-"`
 	Content            *[]byte            `json:"-"`
 	ContentType        *string            `json:"content_type"`
 	ContentDisposition *string            `json:"content_disposition"`
@@ -36,16 +45,303 @@ type Object struct {
 	VersionId          *string            `json:"version_id"`
 	IsLatest           *bool              `json:"-"`
 	StorageClass       *string            `json:"storage_class"`
+	ArchiveStatus      *string            `json:"-"`
+	Mode               *os.FileMode       `json:"-"`
+	Tags               map[string]string  `json:"-"`
+	Owner              *ObjectOwner       `json:"-"`
+	CopyOf             *string            `json:"-"`
+	Timings            ObjectTimings      `json:"-"`
+	Attempts           uint               `json:"-"`
+}
+
+// ObjectOwner is the owner of S3 object from the bucket listing.
+type ObjectOwner struct {
+	ID          string
+	DisplayName string
+}
+
+// ObjectTimings contain durations of object processing phases.
+type ObjectTimings struct {
+	Listed      time.Time
+	QueueWait   time.Duration
+	SourceTTFB  time.Duration
+	Download    time.Duration
+	Upload      time.Duration
+	Meta        time.Duration
+	LimiterWait time.Duration
+}
+
+// timedBucket wraps ratelimit.Bucket and accumulates time spent on waiting for tokens.
+type timedBucket struct {
+	ratelimit.Bucket
+	waited *time.Duration
+}
+
+func (b timedBucket) Wait(count int64) {
+	start := time.Now()
+	b.Bucket.Wait(count)
+	*b.waited += time.Since(start)
+}
+
+// AdjustableBucket is a ratelimit.Bucket which rate can be changed at runtime.
+// Only Wait method respects rate changes, other methods are served by the initial unlimited bucket.
+type AdjustableBucket struct {
+	ratelimit.Bucket
+	mu      sync.RWMutex
+	current ratelimit.Bucket
+	rate    float64
+}
+
+// NewAdjustableBucket return new unlimited AdjustableBucket.
+func NewAdjustableBucket() *AdjustableBucket {
+	return &AdjustableBucket{Bucket: ratelimit.NewFakeBucket(), current: ratelimit.NewFakeBucket()}
+}
+
+// SetRate change the bucket rate and capacity. Zero rate disables the limit.
+func (b *AdjustableBucket) SetRate(rate float64, capacity int64) error {
+	bucket := ratelimit.NewFakeBucket()
+	if rate > 0 {
+		var err error
+		if bucket, err = ratelimit.NewBucketWithRate(rate, capacity); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	b.current = bucket
+	b.rate = rate
+	b.mu.Unlock()
+	return nil
+}
+
+// Limit return current rate of the bucket, zero means no limit.
+func (b *AdjustableBucket) Limit() float64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.rate
+}
+
+// Wait takes count tokens from the current bucket, waiting until they are available.
+func (b *AdjustableBucket) Wait(count int64) {
+	b.mu.RLock()
+	bucket := b.current
+	b.mu.RUnlock()
+	bucket.Wait(count)
+}
+
+// keyDepth return the depth of key relative to the storage root, keys in the root have depth 1.
+func keyDepth(key string) uint {
+	return uint(strings.Count(strings.Trim(key, "/"), "/")) + 1
+}
+
+// ChecksumMismatchError raises when checksum of downloaded object content does not match the checksum returned by storage.
+type ChecksumMismatchError struct {
+	Key      string
+	Expected string
+	Actual   string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("object: %s checksum mismatch, expected: %s, got: %s", e.Key, e.Expected, e.Actual)
+}
+
+// ContentLengthError raises when the size of downloaded object content differs from Content-Length returned by storage,
+// like when the connection is closed before the whole content is read.
+type ContentLengthError struct {
+	Key      string
+	Expected int64
+	Actual   int64
+}
+
+func (e *ContentLengthError) Error() string {
+	return fmt.Sprintf("object: %s content length mismatch, expected: %d bytes, got: %d bytes", e.Key, e.Expected, e.Actual)
+}
+
+// checkContentLength return ContentLengthError if the size of data differs from expected length.
+// Unknown length is not checked.
+func checkContentLength(key string, data []byte, expected *int64) error {
+	if (expected == nil) || (*expected < 0) || (int64(len(data)) == *expected) {
+		return nil
+	}
+	return &ContentLengthError{Key: key, Expected: *expected, Actual: int64(len(data))}
+}
+
+// MissingChecksumError raises when storage requires object checksum, but the object has no stored checksum.
+type MissingChecksumError struct {
+	Key string
+}
+
+func (e *MissingChecksumError) Error() string {
+	return fmt.Sprintf("object: %s has no stored checksum", e.Key)
+}
+
+// SelectQuery contain parameters of server-side object content filtering with SQL expression.
+type SelectQuery struct {
+	Expression  string
+	InputFormat string
+	Compression string
+}
+
+// Copier is implemented by storages which support server-side copy of objects.
+type Copier interface {
+	CopyObject(ctx context.Context, obj *Object, dstKey string) error
+}
+
+// CopyPutter is implemented by storages which can write object with server-side copy of other object of the storage.
+type CopyPutter interface {
+	PutObjectCopy(ctx context.Context, obj *Object, srcKey string) error
+}
+
+// ACLSetter is implemented by storages which support changing ACL of existing objects.
+type ACLSetter interface {
+	PutObjectACL(ctx context.Context, obj *Object, acl string) error
+}
+
+// ObjectACL is access control list of object: its owner and grants.
+type ObjectACL struct {
+	Owner  *ObjectOwner
+	Grants []ObjectGrant
+}
+
+// ObjectGrant is grant of object ACL. Grantee is identified by ID, URI of the group or email depending on Type.
+type ObjectGrant struct {
+	Type        string
+	ID          string
+	DisplayName string
+	URI         string
+	Email       string
+	Permission  string
+}
+
+// ACLCopier is implemented by storages which support reading and setting full ACL of existing objects.
+type ACLCopier interface {
+	GetObjectGrants(ctx context.Context, obj *Object) (*ObjectACL, error)
+	PutObjectGrants(ctx context.Context, obj *Object, acl *ObjectACL) error
+}
+
+// Selecter is implemented by storages which support server-side filtering of object content.
+type Selecter interface {
+	SelectObjectContent(ctx context.Context, obj *Object, query SelectQuery) error
+}
+
+// TagGetter is implemented by storages which support object tags.
+type TagGetter interface {
+	GetObjectTags(ctx context.Context, obj *Object) error
+}
+
+// KeyMapper is implemented by storages which List objects with keys including the storage prefix, like S3 storages.
+// RelativeKey return the key of listed object as it is given to PutObject, ok is false if PutObject can't write
+// an object with this key.
+type KeyMapper interface {
+	RelativeKey(key string) (rel string, ok bool)
 }
 
 // Storage interface.
+// Operations are interrupted and return context error when ctx is done, including waiting between retries.
 type Storage interface {
-	WithContext(ctx context.Context)
 	WithRateLimit(limit int) error
-	List(ch chan<- *Object) error
-	PutObject(object *Object) error
-	GetObjectContent(obj *Object) error
-	GetObjectMeta(obj *Object) error
-	DeleteObject(obj *Object) error
+	WithRateLimitBucket(bucket ratelimit.Bucket)
+	List(ctx context.Context, ch chan<- *Object) error
+	PutObject(ctx context.Context, object *Object) error
+	GetObjectContent(ctx context.Context, obj *Object) error
+	GetObjectMeta(ctx context.Context, obj *Object) error
+	DeleteObject(ctx context.Context, obj *Object) error
 	GetStorageType() Type
 }
+
+// IsEmpty check if storage contain no objects, not existing FS storage is empty.
+// Listing is cancelled after the first object received.
+func IsEmpty(ctx context.Context, st Storage) (bool, error) {
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listChan := make(chan *Object)
+	listErrChan := make(chan error, 1)
+	go func() {
+		listErrChan <- st.List(listCtx, listChan)
+		close(listChan)
+	}()
+
+	_, found := <-listChan
+	cancel()
+	for range listChan {
+	}
+	err := <-listErrChan
+
+	if found {
+		return false, nil
+	}
+	if err != nil && os.IsNotExist(err) {
+		return true, nil
+	}
+	return true, err
+}
+
+// maxListRetryDelay limits backoff of List page request retries, unless the retry interval is longer.
+const maxListRetryDelay = time.Minute
+
+// listRetry retry failed List page requests with exponential backoff and jitter.
+// Failed attempts are counted per page, so the retry count limits failures in a row, not failures of the whole listing.
+type listRetry struct {
+	retryCnt      uint
+	retryInterval time.Duration
+	page          uint
+	attempt       uint
+}
+
+// wait log failure of List request of given page and sleep before the retry.
+// It return err if the page retries are exhausted or the context error if ctx is done while sleeping.
+func (r *listRetry) wait(ctx context.Context, what string, page uint, err error) error {
+	if page != r.page {
+		r.page, r.attempt = page, 0
+	}
+	if r.attempt >= r.retryCnt {
+		Log.Debugf("%s failed on page %d with error: %s", what, page, err)
+		return err
+	}
+	r.attempt++
+	delay := listRetryDelay(r.retryInterval, r.attempt)
+	Log.Warnf("%s failed on page %d with error: %s, retrying in %s (%d/%d)", what, page, err, delay, r.attempt, r.retryCnt)
+	return sleepContext(ctx, delay)
+}
+
+// listRetryDelay return delay before retry of List page request failed attempt times in a row:
+// the retry interval is doubled with every attempt up to maxListRetryDelay and randomized down to half of it,
+// so listings throttled at the same time don't retry in lockstep.
+func listRetryDelay(interval time.Duration, attempt uint) time.Duration {
+	d := interval
+	for i := uint(1); (i < attempt) && (d < maxListRetryDelay); i++ {
+		d *= 2
+	}
+	if (d > maxListRetryDelay) && (interval < maxListRetryDelay) {
+		d = maxListRetryDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleepContext pause the current goroutine for given duration, it returns context error if ctx is done earlier.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// contextReader is io.Reader which fails reads after the context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
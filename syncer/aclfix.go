@@ -0,0 +1,56 @@
+package syncer
+
+import (
+	"context"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+)
+
+// fixACL set ACL of all objects in the target path and return the number of updated objects.
+// Objects are listed and updated with separate pipeline, which has its own workers and rate limit.
+func (job *Job) fixACL(ctx context.Context) (uint64, error) {
+	opts := job.opts.ACLFix
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	group := pipeline.NewGroup()
+	group.WithContext(ctx)
+	group.WithObjectTimeout(job.opts.ObjectTimeout)
+	group.SetSource(job.target)
+	group.SetTarget(job.target)
+	group.AddPipeStep(pipeline.Step{
+		Name:     "ListTarget",
+		Fn:       collection.ListSourceStorage,
+		ChanSize: job.opts.ListBuffer,
+	})
+	if opts.RateLimit > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "RateLimit",
+			Fn:     collection.PipelineRateLimit,
+			Config: opts.RateLimit,
+		})
+	}
+	group.AddPipeStep(pipeline.Step{
+		Name:       "PutObjACL",
+		Fn:         collection.PutSourceObjectACL,
+		AddWorkers: opts.Workers - 1,
+		Config:     opts.ACL,
+	})
+	group.AddPipeStep(pipeline.Step{
+		Name: "Terminator",
+		Fn:   collection.Terminator,
+	})
+	group.Run()
+
+	err := <-group.ErrChan()
+	var fixed uint64
+	for _, step := range group.GetStepsInfo() {
+		if step.Name == "Terminator" {
+			fixed = step.Stats.Input
+		}
+	}
+	if err != nil {
+		return fixed, err.(*pipeline.PipelineError).Err
+	}
+	return fixed, nil
+}
@@ -0,0 +1,25 @@
+package syncer
+
+import (
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// checkDedup return error if S3Options.DedupChunkSize can't be used with other options.
+// Objects are deduplicated on S3 target of FS source and reassembled from S3 source to FS or null target.
+func checkDedup(opts Options) error {
+	size := opts.S3.DedupChunkSize
+	if (size < storage.DedupMinChunkSize) || (size > storage.DedupMaxChunkSize) || (size&(size-1) != 0) {
+		return fmt.Errorf("dedup chunk size should be a power of two between %d and %d bytes", storage.DedupMinChunkSize, storage.DedupMaxChunkSize)
+	}
+	if (opts.Source.Type == storage.TypeS3) == (opts.Target.Type == storage.TypeS3) {
+		return fmt.Errorf("dedup requires S3 target with FS source or S3 source with FS or null target")
+	}
+	if (opts.S3.StagingPrefix != "") || (opts.S3.Notification != "") || (opts.ACLFix.ACL != "") || (opts.S3.Select.Expression != "") {
+		return fmt.Errorf("dedup can't be used with staging, bucket notification, ACL fix and S3 Select")
+	}
+	if (opts.Events.QueueURL != "") || (opts.Inventory.Old != "") || (opts.S3.ListMaxPages > 0) || opts.Filters.CompareListing {
+		return fmt.Errorf("dedup can't be used with S3 events, inventory diff, listing pages limit and target listing comparison")
+	}
+	return nil
+}
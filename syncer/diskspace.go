@@ -0,0 +1,85 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"github.com/larrabee/s3sync/storage"
+	"time"
+)
+
+// diskSpaceCheckInterval is the interval of free space checks of FS target with FSOptions.MinFreeSpace.
+const diskSpaceCheckInterval = 5 * time.Second
+
+// ErrDiskSpace is returned by Run if FS target has not enough free space for source objects, see FSOptions.IgnoreDiskSpace.
+var ErrDiskSpace = errors.New("not enough free disk space on target")
+
+// checkDiskSpace list source objects and compare their total size with free space of FS target. Sizes of existing target
+// files are subtracted, since they are overwritten. Sources which listing has no object sizes are not checked.
+func (job *Job) checkDiskSpace(ctx context.Context, target *storage.FSStorage) error {
+	source, ok := job.source.(*storage.S3Storage)
+	if !ok || (job.opts.S3.ListMaxPages > 0) {
+		job.log.Debugf("Source listing has no object sizes, free disk space of target is not checked")
+		return nil
+	}
+	free, err := target.FreeSpace()
+	if err != nil {
+		return err
+	}
+
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	objects := make(chan *storage.Object, job.opts.ListBuffer)
+	listErr := make(chan error, 1)
+	go func() {
+		listErr <- source.List(listCtx, objects)
+		close(objects)
+	}()
+	var required uint64
+	for obj := range objects {
+		if obj.Size == nil {
+			continue
+		}
+		size := uint64(*obj.Size)
+		if used := target.UsedSpace(*obj.Key); used < size {
+			required += size - used
+		}
+	}
+	if err := <-listErr; err != nil {
+		return err
+	}
+
+	job.log.Infof("Disk space check: Required: %d; Reserved: %d; Free: %d", required, job.opts.FS.MinFreeSpace, free)
+	if required+job.opts.FS.MinFreeSpace > free {
+		return ErrDiskSpace
+	}
+	return nil
+}
+
+// watchDiskSpace check free space of FS target every diskSpaceCheckInterval until ctx is done. The job is paused
+// while free space is below FSOptions.MinFreeSpace and resumed when space is freed.
+func (job *Job) watchDiskSpace(ctx context.Context, target *storage.FSStorage) {
+	ticker := time.NewTicker(diskSpaceCheckInterval)
+	defer ticker.Stop()
+	defer job.setPaused(pauseDiskSpace, false)
+	paused := false
+	for {
+		free, err := target.FreeSpace()
+		if err != nil {
+			job.log.Warnf("Free disk space check of target failed with error: %s", err)
+		} else if !paused && (free < job.opts.FS.MinFreeSpace) {
+			job.log.Warnf("Free disk space of target is %d bytes, below %d, sync is paused until space is freed", free, job.opts.FS.MinFreeSpace)
+			job.setPaused(pauseDiskSpace, true)
+			paused = true
+		} else if paused && (free >= job.opts.FS.MinFreeSpace) {
+			job.log.Infof("Free disk space of target is %d bytes, sync is resumed", free)
+			job.setPaused(pauseDiskSpace, false)
+			paused = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
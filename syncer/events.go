@@ -0,0 +1,414 @@
+package syncer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/tracing"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultEventVisibilityTimeout is the default visibility timeout of received S3 event messages.
+const DefaultEventVisibilityTimeout = time.Minute
+
+const (
+	// eventMaxMessages is the max number of messages received with one request (SQS limit).
+	eventMaxMessages = 10
+	// eventWaitTime is the long polling time (sec) of receive requests (SQS limit).
+	eventWaitTime = 20
+	// eventRetryInterval is the interval between failed receive requests.
+	eventRetryInterval = 5 * time.Second
+	// eventSequencerKeys is the number of recent keys with remembered event sequencers.
+	eventSequencerKeys = 100000
+)
+
+// queueHostRe match host of AWS SQS queue URL, like sqs.us-east-1.amazonaws.com or us-east-1.queue.amazonaws.com.
+var queueHostRe = regexp.MustCompile(`^(?:sqs\.([a-z0-9-]+)|([a-z0-9-]+)\.queue)\.amazonaws\.com(?:\.cn)?$`)
+
+// EventOptions configure continuous sync driven by S3 event notifications instead of source listing.
+type EventOptions struct {
+	// QueueURL is the URL of SQS queue receiving event notifications of the source bucket, directly or through SNS topic.
+	// Empty value disables events.
+	QueueURL string
+	// VisibilityTimeout of received messages, it is extended while objects of the messages are synced.
+	// Messages of failed objects are received again after the timeout. DefaultEventVisibilityTimeout by default.
+	VisibilityTimeout time.Duration
+}
+
+// s3EventRecord is a record of S3 event notification.
+type s3EventRecord struct {
+	EventName string `json:"eventName"`
+	S3        struct {
+		Bucket struct {
+			Name string `json:"name"`
+		} `json:"bucket"`
+		Object struct {
+			Key       string `json:"key"`
+			Sequencer string `json:"sequencer"`
+		} `json:"object"`
+	} `json:"s3"`
+}
+
+// s3EventMessage is SQS message with S3 event notification.
+type s3EventMessage struct {
+	Records []s3EventRecord `json:"Records"`
+	// Type and Message are set if the notification is delivered through SNS topic.
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// parseEventMessage return records of S3 event notification, it return no records for test events.
+func parseEventMessage(body string) ([]s3EventRecord, error) {
+	var msg s3EventMessage
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		return nil, err
+	}
+	if msg.Type == "Notification" {
+		if err := json.Unmarshal([]byte(msg.Message), &msg); err != nil {
+			return nil, fmt.Errorf("SNS notification: %s", err)
+		}
+	}
+	return msg.Records, nil
+}
+
+// objectEvent is the latest event of object key.
+type objectEvent struct {
+	key       string
+	removed   bool
+	sequencer string
+}
+
+// compareSequencers compare sequencers of events of the same key, the later event has the greater sequencer.
+// Sequencers have different length, the shorter one is right padded with zeros as S3 documentation requires.
+// Empty sequencers are equal to any sequencer, so events without sequencer are applied in the order of delivery.
+func compareSequencers(a, b string) int {
+	if (a == "") || (b == "") {
+		return 0
+	}
+	for len(a) < len(b) {
+		a += "0"
+	}
+	for len(b) < len(a) {
+		b += "0"
+	}
+	return strings.Compare(strings.ToUpper(a), strings.ToUpper(b))
+}
+
+// eventSequencers remember sequencers of the latest applied events of recent keys,
+// so redelivered and out of order events are skipped. The oldest keys are forgotten first.
+type eventSequencers struct {
+	seq  map[string]string
+	keys []string
+	next int
+	size int
+}
+
+func newEventSequencers(size int) *eventSequencers {
+	return &eventSequencers{seq: make(map[string]string), size: size}
+}
+
+// applied check if event of the key with the same or later sequencer is already applied.
+func (s *eventSequencers) applied(ev objectEvent) bool {
+	seq, ok := s.seq[ev.key]
+	return ok && (ev.sequencer != "") && (seq != "") && (compareSequencers(seq, ev.sequencer) >= 0)
+}
+
+// add remember sequencer of applied event.
+func (s *eventSequencers) add(ev objectEvent) {
+	if _, ok := s.seq[ev.key]; !ok {
+		if len(s.keys) < s.size {
+			s.keys = append(s.keys, ev.key)
+		} else {
+			delete(s.seq, s.keys[s.next])
+			s.keys[s.next] = ev.key
+			s.next = (s.next + 1) % s.size
+		}
+	}
+	s.seq[ev.key] = ev.sequencer
+}
+
+// eventSource is source storage listing only given objects of S3 storage.
+type eventSource struct {
+	*storage.S3Storage
+	keys []string
+}
+
+// List send objects with given keys with metadata loaded from S3. Not existing objects are skipped,
+// they are removed after the event and its removal event follows.
+func (s *eventSource) List(ctx context.Context, output chan<- *storage.Object) error {
+	for _, key := range s.keys {
+		obj := &storage.Object{Key: aws.String(key), Timings: storage.ObjectTimings{Listed: time.Now()}}
+		if err := s.GetObjectMeta(ctx, obj); isNotFound(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		select {
+		case output <- obj:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// queueConfig return AWS config of SQS client of queue URL. Endpoint is taken from the URL, so queues of
+// SQS compatible services are supported too, region is detected from AWS queue URL or region is used.
+func queueConfig(queueURL, region string) (*aws.Config, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SQS queue URL: %s", err)
+	}
+	if ((u.Scheme != "http") && (u.Scheme != "https")) || (u.Host == "") {
+		return nil, fmt.Errorf("invalid SQS queue URL: expected format: https://sqs.<region>.amazonaws.com/<account>/<queue>")
+	}
+	if m := queueHostRe.FindStringSubmatch(u.Hostname()); m != nil {
+		region = m[1] + m[2]
+	}
+	return aws.NewConfig().WithEndpoint(u.Scheme + "://" + u.Host).WithRegion(region), nil
+}
+
+// replicate sync objects created in the source and delete objects removed from the source as S3 event notifications
+// are received from Options.Events queue, until ctx is done. Every received batch of messages is synced with its own
+// pipeline, a message is deleted from the queue only after all objects of the batch are synced.
+func (job *Job) replicate(ctx context.Context, span *tracing.Span, res *Result) error {
+	events := job.opts.Events
+	sess := job.source.(*storage.S3Storage).Session()
+	cfg, err := queueConfig(events.QueueURL, aws.StringValue(sess.Config.Region))
+	if err != nil {
+		return err
+	}
+	queue := sqs.New(sess, cfg)
+	seqs := newEventSequencers(eventSequencerKeys)
+	transferred := collection.NewThroughputStats()
+	startTime := time.Now()
+	defer func() {
+		res.Duration = time.Since(startTime)
+		res.Bytes = transferred.Bytes()
+	}()
+
+	job.log.Infof("Receiving S3 events from %s", events.QueueURL)
+	for {
+		out, err := queue.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(events.QueueURL),
+			MaxNumberOfMessages: aws.Int64(eventMaxMessages),
+			VisibilityTimeout:   aws.Int64(int64(events.VisibilityTimeout / time.Second)),
+			WaitTimeSeconds:     aws.Int64(eventWaitTime),
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			job.log.Errorf("Failed to receive S3 events: %s, retrying", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(eventRetryInterval):
+			}
+			continue
+		}
+		if len(out.Messages) == 0 {
+			continue
+		}
+		if err := job.syncEvents(ctx, queue, out.Messages, seqs, span, transferred, res); err != nil {
+			return err
+		}
+	}
+}
+
+// syncEvents sync objects of received messages and delete the messages if all objects are synced.
+// It return error only if the job should be terminated.
+func (job *Job) syncEvents(ctx context.Context, queue *sqs.SQS, msgs []*sqs.Message, seqs *eventSequencers, span *tracing.Span, transferred *collection.ThroughputStats, res *Result) error {
+	batchCtx, batchCancel := context.WithCancel(ctx)
+	defer batchCancel()
+	go job.extendVisibility(batchCtx, queue, msgs)
+
+	latest := make(map[string]objectEvent)
+	var order []string
+	processed := make([]*sqs.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		records, err := parseEventMessage(aws.StringValue(msg.Body))
+		if err != nil {
+			// The message is kept in the queue, so it can be moved to dead-letter queue.
+			job.log.Errorf("Invalid S3 event message %s: %s", aws.StringValue(msg.MessageId), err)
+			continue
+		}
+		processed = append(processed, msg)
+		for _, r := range records {
+			ev, ok := job.objectEvent(r)
+			if !ok || seqs.applied(ev) {
+				continue
+			}
+			if prev, ok := latest[ev.key]; !ok {
+				order = append(order, ev.key)
+			} else if compareSequencers(prev.sequencer, ev.sequencer) > 0 {
+				continue
+			}
+			latest[ev.key] = ev
+		}
+	}
+
+	var created, removed []string
+	for _, key := range order {
+		if ev := latest[key]; ev.removed {
+			removed = append(removed, key)
+		} else {
+			created = append(created, key)
+		}
+	}
+
+	var batch Result
+	if len(created) > 0 {
+		source := &eventSource{S3Storage: job.source.(*storage.S3Storage), keys: created}
+		if err := job.runPipeline(ctx, source, "", span, transferred, &batch); err != nil {
+			res.Synced += batch.Synced
+			res.Errors += batch.Errors
+			return err
+		}
+	}
+	if err := job.deleteTargets(ctx, removed, &batch); err != nil {
+		res.Synced += batch.Synced
+		res.Errors += batch.Errors
+		return err
+	}
+	res.Synced += batch.Synced
+	res.Errors += batch.Errors
+	res.Deleted += batch.Deleted
+
+	if batch.Errors > 0 {
+		job.log.Warnf("S3 events of %d messages synced with %d errors, the messages will be received again", len(msgs), batch.Errors)
+		return nil
+	}
+	for _, key := range order {
+		seqs.add(latest[key])
+	}
+	job.log.Debugf("S3 events of %d messages synced: Objects: %d; Deleted: %d", len(msgs), batch.Synced, batch.Deleted)
+	job.deleteMessages(queue, processed)
+	return nil
+}
+
+// objectEvent return event of the record, it return false if the record is not an event of the source
+// or the event is not object creation or removal.
+func (job *Job) objectEvent(r s3EventRecord) (objectEvent, bool) {
+	ev := objectEvent{sequencer: r.S3.Object.Sequencer}
+	switch {
+	case strings.HasPrefix(r.EventName, "ObjectCreated:"):
+	case strings.HasPrefix(r.EventName, "ObjectRemoved:"):
+		ev.removed = true
+	default:
+		return ev, false
+	}
+	if r.S3.Bucket.Name != job.opts.Source.Bucket {
+		return ev, false
+	}
+	// Keys of S3 events are URL-encoded, like keys of listing with url encoding type.
+	key, err := url.QueryUnescape(r.S3.Object.Key)
+	if err != nil || !strings.HasPrefix(key, job.opts.Source.Path) {
+		return ev, false
+	}
+	ev.key = key
+	return ev, true
+}
+
+// ErrDeleteNotConfirmed is returned by Run if Options.ConfirmDelete doesn't confirm deletion of target objects.
+var ErrDeleteNotConfirmed = errors.New("deletion of target objects is not confirmed")
+
+// deleteTargets delete target objects of source keys, deleted and failed objects are counted to res.
+// It return error only if the job should be terminated.
+func (job *Job) deleteTargets(ctx context.Context, keys []string, res *Result) error {
+	for _, key := range keys {
+		if err := job.deleteTarget(ctx, key); err != nil {
+			res.Errors++
+			if job.opts.OnError != nil {
+				job.opts.OnError(err)
+			}
+			if job.opts.OnFail == OnFailFatal {
+				job.log.Errorf("Failed to delete %s: %s, terminating", key, err)
+				return err
+			}
+			job.log.Errorf("Failed to delete %s: %s", key, err)
+			continue
+		}
+		res.Deleted++
+		if job.opts.SyncLog {
+			job.log.Infof("Deleted %s", key)
+		}
+	}
+	return nil
+}
+
+// deleteTarget delete target object of source key. Not existing objects are not an error.
+func (job *Job) deleteTarget(ctx context.Context, key string) error {
+	// S3 storage doesn't add its prefix to keys of deleted objects, unlike uploaded ones.
+	if job.opts.Target.Type == storage.TypeS3 {
+		key = filepath.Join(job.opts.Target.Path, key)
+	}
+	err := job.target.DeleteObject(ctx, &storage.Object{Key: aws.String(key)})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// extendVisibility extend visibility timeout of messages every half of the timeout until ctx is done.
+func (job *Job) extendVisibility(ctx context.Context, queue *sqs.SQS, msgs []*sqs.Message) {
+	timeout := job.opts.Events.VisibilityTimeout
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+	entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, 0, len(msgs))
+	for i, msg := range msgs {
+		entries = append(entries, &sqs.ChangeMessageVisibilityBatchRequestEntry{
+			Id:                aws.String(strconv.Itoa(i)),
+			ReceiptHandle:     msg.ReceiptHandle,
+			VisibilityTimeout: aws.Int64(int64(timeout / time.Second)),
+		})
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			out, err := queue.ChangeMessageVisibilityBatchWithContext(ctx, &sqs.ChangeMessageVisibilityBatchInput{
+				QueueUrl: aws.String(job.opts.Events.QueueURL),
+				Entries:  entries,
+			})
+			if (err != nil) && (ctx.Err() == nil) {
+				job.log.Warnf("Failed to extend visibility timeout of S3 event messages: %s", err)
+			} else if (err == nil) && (len(out.Failed) > 0) {
+				job.log.Warnf("Failed to extend visibility timeout of %d S3 event messages: %s", len(out.Failed), aws.StringValue(out.Failed[0].Message))
+			}
+		}
+	}
+}
+
+// deleteMessages delete synced messages from the queue. Messages failed to delete are received and synced again.
+func (job *Job) deleteMessages(queue *sqs.SQS, msgs []*sqs.Message) {
+	if len(msgs) == 0 {
+		return
+	}
+	entries := make([]*sqs.DeleteMessageBatchRequestEntry, 0, len(msgs))
+	for i, msg := range msgs {
+		entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: msg.ReceiptHandle})
+	}
+	// Messages are deleted even if the job is cancelled, since its objects are synced.
+	out, err := queue.DeleteMessageBatchWithContext(context.Background(), &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(job.opts.Events.QueueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		job.log.Warnf("Failed to delete S3 event messages: %s, they will be received again", err)
+	} else if len(out.Failed) > 0 {
+		job.log.Warnf("Failed to delete %d S3 event messages: %s, they will be received again", len(out.Failed), aws.StringValue(out.Failed[0].Message))
+	}
+}
@@ -0,0 +1,81 @@
+package syncer
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"testing"
+)
+
+const testEventBody = `{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"src"},"object":{"key":"logs/a+b.txt","sequencer":"0055AED6DCD90281E5"}}}]}`
+
+func TestParseEventMessage(t *testing.T) {
+	sns, _ := json.Marshal(map[string]string{"Type": "Notification", "Message": testEventBody})
+	for _, body := range []string{testEventBody, string(sns)} {
+		records, err := parseEventMessage(body)
+		if err != nil {
+			t.Fatalf("parseEventMessage(%s) failed: %s", body, err)
+		}
+		if (len(records) != 1) || (records[0].S3.Object.Key != "logs/a+b.txt") {
+			t.Errorf("parseEventMessage(%s) = %+v, expected one record", body, records)
+		}
+	}
+	if records, err := parseEventMessage(`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"src"}`); (err != nil) || (len(records) != 0) {
+		t.Errorf("test event parsed to %+v, %v, expected no records", records, err)
+	}
+
+	job := &Job{opts: Options{Source: Connection{Bucket: "src", Path: "logs/"}}}
+	records, err := parseEventMessage(testEventBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev, ok := job.objectEvent(records[0])
+	if !ok || (ev.key != "logs/a b.txt") || ev.removed {
+		t.Errorf("objectEvent = %+v, %t, expected creation of \"logs/a b.txt\"", ev, ok)
+	}
+}
+
+func TestEventSequencers(t *testing.T) {
+	if compareSequencers("0055AED6DCD90281E5", "0055AED6DCD90281E6") >= 0 {
+		t.Errorf("sequencer ...E5 should be less than ...E6")
+	}
+	if compareSequencers("0055AED6DCD90281E5", "0055AED6DCD90281E500") != 0 {
+		t.Errorf("sequencers should be compared with right padding")
+	}
+
+	seqs := newEventSequencers(2)
+	seqs.add(objectEvent{key: "a", sequencer: "10"})
+	if !seqs.applied(objectEvent{key: "a", sequencer: "0F"}) || !seqs.applied(objectEvent{key: "a", sequencer: "10"}) {
+		t.Errorf("redelivered and older events should be applied")
+	}
+	if seqs.applied(objectEvent{key: "a", sequencer: "11"}) || seqs.applied(objectEvent{key: "a"}) {
+		t.Errorf("later events and events without sequencer should not be applied")
+	}
+	seqs.add(objectEvent{key: "b", sequencer: "10"})
+	seqs.add(objectEvent{key: "c", sequencer: "10"})
+	if seqs.applied(objectEvent{key: "a", sequencer: "10"}) || !seqs.applied(objectEvent{key: "c", sequencer: "10"}) {
+		t.Errorf("the oldest key should be forgotten")
+	}
+}
+
+func TestQueueConfig(t *testing.T) {
+	tests := []struct {
+		url, endpoint, region string
+	}{
+		{"https://sqs.eu-west-1.amazonaws.com/123456789012/events", "https://sqs.eu-west-1.amazonaws.com", "eu-west-1"},
+		{"https://us-west-2.queue.amazonaws.com/123456789012/events", "https://us-west-2.queue.amazonaws.com", "us-west-2"},
+		{"http://localhost:9324/queue/events", "http://localhost:9324", "us-east-1"},
+	}
+	for _, tt := range tests {
+		cfg, err := queueConfig(tt.url, "us-east-1")
+		if err != nil {
+			t.Errorf("queueConfig(%q) failed: %s", tt.url, err)
+			continue
+		}
+		if (aws.StringValue(cfg.Endpoint) != tt.endpoint) || (aws.StringValue(cfg.Region) != tt.region) {
+			t.Errorf("queueConfig(%q) = %s %s, expected %s %s", tt.url, aws.StringValue(cfg.Endpoint), aws.StringValue(cfg.Region), tt.endpoint, tt.region)
+		}
+	}
+	if _, err := queueConfig("sqs/events", "us-east-1"); err == nil {
+		t.Errorf("queueConfig of URL without scheme should fail")
+	}
+}
@@ -0,0 +1,81 @@
+package syncer_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func Example() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	job, err := syncer.New(syncer.Options{
+		Source: syncer.Connection{
+			Type:   storage.TypeS3,
+			Bucket: "shared",
+			Path:   "photos/",
+			Region: "eu-west-1",
+		},
+		Target: syncer.Connection{
+			Type: storage.TypeFS,
+			Path: "/opt/backups/photos/",
+		},
+		Filters: syncer.Filters{
+			Ext:      []string{".jpg", ".png"},
+			Modified: true,
+		},
+		Workers: 64,
+		OnFail:  syncer.OnFailSkipMissing,
+		OnError: func(err error) {
+			log.Printf("object failed: %s", err)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	res, err := job.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("synced %d objects in %s\n", res.Synced, res.Duration)
+}
+
+func ExampleJob_Run_progress() {
+	job, err := syncer.New(syncer.Options{
+		Source: syncer.Connection{Type: storage.TypeFS, Path: "/var/www/static/"},
+		Target: syncer.Connection{Type: storage.TypeS3, Bucket: "static", Path: "www/"},
+		OnObject: func(obj *storage.Object) {
+			fmt.Println("uploaded", *obj.Key)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	go func() {
+		for range ticker.C {
+			for _, step := range job.Stats() {
+				log.Printf("%s: %d objects", step.Name, step.Stats.Output)
+			}
+		}
+	}()
+	_, err = job.Run(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,272 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/larrabee/s3sync/storage"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InventoryOptions configure incremental sync of the difference between two S3 inventory reports of the source bucket
+// instead of source listing: objects added or changed in the newer report are synced and objects removed from it
+// are deleted from the target. Only CSV reports are supported.
+// Alternatively File replaces source listing with pre-generated object list.
+type InventoryOptions struct {
+	// Old and New are locations of manifest.json of the older and the newer report, s3://bucket/key or local paths.
+	// Data files of S3 manifests are read from the report destination bucket with the source credentials,
+	// data files of local manifests are read from the manifest directory by file name.
+	// Empty Old disables inventory diff.
+	Old string
+	New string
+	// File is local object list file of S3 source used instead of source listing, like S3 inventory data file
+	// or output of aws s3api list-objects. Format is detected by extension: .csv, .json or .jsonl (.ndjson),
+	// optionally gzipped with .gz suffix. Keys are full S3 keys, objects outside of source path are skipped.
+	// Size, ETag and modification time of objects are taken from the file, they are compared with the target
+	// by Filters.Modified and Filters.CompareListing instead of listed values.
+	File string
+	// Columns are the columns of CSV File without header row, like fileSchema of S3 inventory manifest
+	// "Bucket, Key, Size, LastModifiedDate, ETag, StorageClass", or local path of manifest.json of the report
+	// with the schema. Default columns of S3 inventory report are used if it is empty.
+	Columns string
+}
+
+// inventoryManifest is manifest.json of S3 inventory report.
+type inventoryManifest struct {
+	SourceBucket      string `json:"sourceBucket"`
+	DestinationBucket string `json:"destinationBucket"`
+	FileFormat        string `json:"fileFormat"`
+	FileSchema        string `json:"fileSchema"`
+	Files             []struct {
+		Key string `json:"key"`
+	} `json:"files"`
+
+	location string
+	columns  map[string]int
+}
+
+// inventoryColumnsChanged are the columns of inventory report compared to detect changed objects.
+var inventoryColumnsChanged = []string{"ETag", "Size", "LastModifiedDate"}
+
+// inventorySource is source storage listing objects added or changed in the newer inventory report,
+// keys of removed objects are collected to removed.
+type inventorySource struct {
+	*storage.S3Storage
+	svc            *s3.S3
+	old, new       *inventoryManifest
+	prefix         string
+	added, changed uint64
+	removed        []string
+}
+
+// newInventorySource read and validate manifests of Options.Inventory.
+func (job *Job) newInventorySource(ctx context.Context) (*inventorySource, error) {
+	src := &inventorySource{S3Storage: job.source.(*storage.S3Storage), prefix: job.opts.Source.Path}
+	src.svc = s3.New(src.Session())
+	var err error
+	if src.old, err = src.readManifest(ctx, job.opts.Inventory.Old); err != nil {
+		return nil, fmt.Errorf("old inventory: %s", err)
+	}
+	if src.new, err = src.readManifest(ctx, job.opts.Inventory.New); err != nil {
+		return nil, fmt.Errorf("new inventory: %s", err)
+	}
+	if (src.old.SourceBucket != job.opts.Source.Bucket) || (src.new.SourceBucket != job.opts.Source.Bucket) {
+		return nil, fmt.Errorf("inventory reports of buckets %s and %s don't match source bucket %s", src.old.SourceBucket, src.new.SourceBucket, job.opts.Source.Bucket)
+	}
+	if strings.Join(inventoryColumns(src.old.FileSchema), ",") != strings.Join(inventoryColumns(src.new.FileSchema), ",") {
+		return nil, fmt.Errorf("inventory schemas don't match: %q and %q", src.old.FileSchema, src.new.FileSchema)
+	}
+	return src, nil
+}
+
+// inventoryColumns return column names of inventory file schema, like "Bucket, Key, Size".
+func inventoryColumns(schema string) []string {
+	columns := strings.Split(schema, ",")
+	for i := range columns {
+		columns[i] = strings.TrimSpace(columns[i])
+	}
+	return columns
+}
+
+// readManifest read and validate inventory manifest at given location.
+func (s *inventorySource) readManifest(ctx context.Context, location string) (*inventoryManifest, error) {
+	r, err := s.open(ctx, location)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	m := &inventoryManifest{location: location}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %s", location, err)
+	}
+	if m.FileFormat != "CSV" {
+		return nil, fmt.Errorf("unsupported inventory format %q, only CSV is supported", m.FileFormat)
+	}
+	m.columns = make(map[string]int)
+	for i, name := range inventoryColumns(m.FileSchema) {
+		m.columns[name] = i
+	}
+	if _, ok := m.columns["Key"]; !ok {
+		return nil, fmt.Errorf("inventory schema %q has no Key column", m.FileSchema)
+	}
+	for _, name := range inventoryColumnsChanged {
+		if _, ok := m.columns[name]; ok {
+			return m, nil
+		}
+	}
+	return nil, fmt.Errorf("inventory schema %q has none of %s columns", m.FileSchema, strings.Join(inventoryColumnsChanged, ", "))
+}
+
+// open return reader of file at given location, s3://bucket/key or local path.
+func (s *inventorySource) open(ctx context.Context, location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "s3://") {
+		return os.Open(location)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(location, "s3://"), "/", 2)
+	if (len(parts) != 2) || (parts[0] == "") || (parts[1] == "") {
+		return nil, fmt.Errorf("invalid S3 location %s, expected format: s3://bucket/key", location)
+	}
+	out, err := s.svc.GetObjectWithContext(ctx, &s3.GetObjectInput{Bucket: aws.String(parts[0]), Key: aws.String(parts[1])})
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// scan call fn for every current object of the report under source path, with the object and its fingerprint
+// of compared columns. Noncurrent versions and delete markers of versioned reports are skipped.
+func (s *inventorySource) scan(ctx context.Context, m *inventoryManifest, fn func(obj *storage.Object, fingerprint string) error) error {
+	for _, file := range m.Files {
+		location := "s3://" + strings.TrimPrefix(m.DestinationBucket, "arn:aws:s3:::") + "/" + file.Key
+		if !strings.HasPrefix(m.location, "s3://") {
+			location = filepath.Join(filepath.Dir(m.location), path.Base(file.Key))
+		}
+		if err := s.scanFile(ctx, m, location, fn); err != nil {
+			return fmt.Errorf("inventory file %s: %s", location, err)
+		}
+	}
+	return nil
+}
+
+// scanFile call fn for every current object of gzipped CSV inventory file at location, see scan.
+func (s *inventorySource) scanFile(ctx context.Context, m *inventoryManifest, location string, fn func(obj *storage.Object, fingerprint string) error) error {
+	f, err := s.open(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	r := csv.NewReader(gz)
+	r.FieldsPerRecord = len(m.columns)
+	column := func(row []string, name string) (string, bool) {
+		i, ok := m.columns[name]
+		if !ok {
+			return "", false
+		}
+		return row[i], true
+	}
+
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if latest, ok := column(row, "IsLatest"); ok && (latest != "true") {
+			continue
+		}
+		if marker, ok := column(row, "IsDeleteMarker"); ok && (marker == "true") {
+			continue
+		}
+		// Keys of inventory reports are URL-encoded, like keys of listing with url encoding type.
+		rawKey, _ := column(row, "Key")
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			return fmt.Errorf("invalid key %q: %s", rawKey, err)
+		}
+		if !strings.HasPrefix(key, s.prefix) {
+			continue
+		}
+
+		obj := &storage.Object{Key: aws.String(key), Timings: storage.ObjectTimings{Listed: time.Now()}}
+		fingerprint := make([]string, 0, len(inventoryColumnsChanged))
+		for _, name := range inventoryColumnsChanged {
+			val, _ := column(row, name)
+			fingerprint = append(fingerprint, val)
+		}
+		if etag, ok := column(row, "ETag"); ok && (etag != "") {
+			obj.ETag = aws.String(`"` + etag + `"`)
+		}
+		if size, ok := column(row, "Size"); ok {
+			if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+				obj.Size = aws.Int64(n)
+			}
+		}
+		if mtime, ok := column(row, "LastModifiedDate"); ok {
+			if t, err := time.Parse(time.RFC3339Nano, mtime); err == nil {
+				obj.Mtime = &t
+			}
+		}
+		if class, ok := column(row, "StorageClass"); ok && (class != "") {
+			obj.StorageClass = aws.String(class)
+		}
+		if err := fn(obj, strings.Join(fingerprint, "|")); err != nil {
+			return err
+		}
+	}
+}
+
+// List send objects added or changed in the newer report, keys of objects removed from it are collected to s.removed.
+// Objects of the older report are kept in memory.
+func (s *inventorySource) List(ctx context.Context, output chan<- *storage.Object) error {
+	old := make(map[string]string)
+	err := s.scan(ctx, s.old, func(obj *storage.Object, fingerprint string) error {
+		old[*obj.Key] = fingerprint
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = s.scan(ctx, s.new, func(obj *storage.Object, fingerprint string) error {
+		prev, ok := old[*obj.Key]
+		delete(old, *obj.Key)
+		switch {
+		case !ok:
+			s.added++
+		case prev != fingerprint:
+			s.changed++
+		default:
+			return nil
+		}
+		select {
+		case output <- obj:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	s.removed = make([]string, 0, len(old))
+	for key := range old {
+		s.removed = append(s.removed, key)
+	}
+	return nil
+}
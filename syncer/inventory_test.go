@@ -0,0 +1,108 @@
+package syncer
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/larrabee/s3sync/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const testInventorySchema = "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag"
+
+// writeTestInventory write local inventory report with given CSV rows and return the manifest path.
+func writeTestInventory(t *testing.T, dir, schema string, rows ...string) string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "data.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	if _, err := gz.Write([]byte(strings.Join(rows, "\n") + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	manifest := `{"sourceBucket":"src","destinationBucket":"arn:aws:s3:::inv","fileFormat":"CSV","fileSchema":"` + schema +
+		`","files":[{"key":"inv/src/daily/data/data.csv.gz"}]}`
+	path := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestInventoryDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	oldPath := writeTestInventory(t, filepath.Join(dir, "old"), testInventorySchema,
+		`"src","data/same.txt","","true","false","1","2020-01-01T00:00:00.000Z","aaa"`,
+		`"src","data/changed.txt","","true","false","1","2020-01-01T00:00:00.000Z","bbb"`,
+		`"src","data/removed+file.txt","","true","false","1","2020-01-01T00:00:00.000Z","ccc"`,
+		`"src","data/deleted.txt","","true","false","1","2020-01-01T00:00:00.000Z","ddd"`,
+		`"src","other/skipped.txt","","true","false","1","2020-01-01T00:00:00.000Z","eee"`,
+	)
+	newPath := writeTestInventory(t, filepath.Join(dir, "new"), testInventorySchema,
+		`"src","data/same.txt","","true","false","1","2020-01-01T00:00:00.000Z","aaa"`,
+		`"src","data/changed.txt","","true","false","2","2020-01-02T00:00:00.000Z","fff"`,
+		`"src","data/added.txt","","true","false","3","2020-01-02T00:00:00.000Z","ggg"`,
+		`"src","data/deleted.txt","v2","true","true","","",""`,
+		`"src","data/deleted.txt","v1","false","false","1","2020-01-01T00:00:00.000Z","ddd"`,
+	)
+
+	src := &inventorySource{prefix: "data/"}
+	if src.old, err = src.readManifest(context.Background(), oldPath); err != nil {
+		t.Fatal(err)
+	}
+	if src.new, err = src.readManifest(context.Background(), newPath); err != nil {
+		t.Fatal(err)
+	}
+	output := make(chan *storage.Object, 10)
+	if err := src.List(context.Background(), output); err != nil {
+		t.Fatal(err)
+	}
+	close(output)
+
+	var listed []string
+	for obj := range output {
+		listed = append(listed, *obj.Key)
+	}
+	sort.Strings(listed)
+	sort.Strings(src.removed)
+	if (strings.Join(listed, ",") != "data/added.txt,data/changed.txt") || (src.added != 1) || (src.changed != 1) {
+		t.Errorf("listed %v, expected added and changed objects", listed)
+	}
+	if strings.Join(src.removed, ",") != "data/deleted.txt,data/removed file.txt" {
+		t.Errorf("removed %v, expected removed objects and objects with delete markers", src.removed)
+	}
+}
+
+func TestInventoryManifestValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-inventory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := &inventorySource{}
+	path := writeTestInventory(t, dir, "Bucket, Size")
+	if _, err := src.readManifest(context.Background(), path); err == nil {
+		t.Errorf("manifest without Key column is accepted")
+	}
+	path = writeTestInventory(t, dir, "Bucket, Key")
+	if _, err := src.readManifest(context.Background(), path); err == nil {
+		t.Errorf("manifest without compared columns is accepted")
+	}
+}
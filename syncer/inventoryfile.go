@@ -0,0 +1,325 @@
+package syncer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/larrabee/s3sync/storage"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object list file formats of InventoryOptions.File, they are detected by file extension.
+const (
+	inventoryFileCSV   = "csv"
+	inventoryFileJSON  = "json"
+	inventoryFileJSONL = "jsonl"
+)
+
+// inventoryFileColumns are the columns of CSV object list without header, the default columns of S3 inventory report.
+var inventoryFileColumns = map[string]int{"bucket": 0, "key": 1, "size": 2, "lastmodifieddate": 3, "etag": 4}
+
+// inventoryFileSchema return columns of CSV object list without header from InventoryOptions.Columns,
+// a schema like "Bucket, Key, Size" or local path of S3 inventory manifest.json with the schema.
+func inventoryFileSchema(columns string) (map[string]int, error) {
+	if columns == "" {
+		return inventoryFileColumns, nil
+	}
+	schema := columns
+	if strings.EqualFold(filepath.Ext(columns), ".json") {
+		f, err := os.Open(columns)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		m := &inventoryManifest{}
+		if err := json.NewDecoder(f).Decode(m); err != nil {
+			return nil, fmt.Errorf("invalid manifest %s: %s", columns, err)
+		}
+		if m.FileFormat != "CSV" {
+			return nil, fmt.Errorf("unsupported inventory format %q of manifest %s, only CSV is supported", m.FileFormat, columns)
+		}
+		schema = m.FileSchema
+	}
+	res := make(map[string]int)
+	for i, name := range inventoryColumns(schema) {
+		res[strings.ToLower(name)] = i
+	}
+	if _, ok := res["key"]; !ok {
+		return nil, fmt.Errorf("object list columns %q have no Key column", schema)
+	}
+	return res, nil
+}
+
+// inventoryFileFormat return format of object list file by its extension, ".gz" suffix is ignored.
+func inventoryFileFormat(name string) (string, error) {
+	switch strings.ToLower(filepath.Ext(strings.TrimSuffix(name, ".gz"))) {
+	case ".csv":
+		return inventoryFileCSV, nil
+	case ".json":
+		return inventoryFileJSON, nil
+	case ".jsonl", ".ndjson":
+		return inventoryFileJSONL, nil
+	default:
+		return "", fmt.Errorf("unknown format of object list file %s, expected extensions: .csv, .json, .jsonl, .ndjson, optionally with .gz", name)
+	}
+}
+
+// inventoryFileObject is the object of JSON object list, like item of Contents of aws s3api list-objects output.
+// Field names are matched case-insensitively.
+type inventoryFileObject struct {
+	Key          string
+	Size         *int64
+	ETag         string
+	LastModified string
+	StorageClass string
+}
+
+// inventoryFileSource is source storage listing objects of object list file instead of S3 listing,
+// objects are read from the S3 source storage.
+type inventoryFileSource struct {
+	*storage.S3Storage
+	file    string
+	prefix  string
+	columns map[string]int
+}
+
+// newInventoryFileSource return source listing objects of Options.Inventory.File.
+func (job *Job) newInventoryFileSource() (*inventoryFileSource, error) {
+	columns, err := inventoryFileSchema(job.opts.Inventory.Columns)
+	if err != nil {
+		return nil, err
+	}
+	return &inventoryFileSource{S3Storage: job.source.(*storage.S3Storage), file: job.opts.Inventory.File, prefix: job.opts.Source.Path, columns: columns}, nil
+}
+
+// List send objects of the file under source path. Objects have size, ETag and modification time from the file
+// if it has them.
+func (s *inventoryFileSource) List(ctx context.Context, output chan<- *storage.Object) error {
+	format, err := inventoryFileFormat(s.file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	if strings.HasSuffix(s.file, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("object list file %s: %s", s.file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	send := func(item inventoryFileObject) error {
+		obj, err := s.object(item)
+		if (err != nil) || (obj == nil) {
+			return err
+		}
+		select {
+		case output <- obj:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	switch format {
+	case inventoryFileCSV:
+		columns := s.columns
+		if columns == nil {
+			columns = inventoryFileColumns
+		}
+		err = scanInventoryCSV(r, columns, send)
+	case inventoryFileJSON:
+		err = scanInventoryJSON(r, send)
+	default:
+		err = scanInventoryJSONL(r, send)
+	}
+	if err != nil {
+		return fmt.Errorf("object list file %s: %s", s.file, err)
+	}
+	return nil
+}
+
+// object return listed object of file item or nil if it is not under source path.
+func (s *inventoryFileSource) object(item inventoryFileObject) (*storage.Object, error) {
+	if (item.Key == "") || !strings.HasPrefix(item.Key, s.prefix) {
+		return nil, nil
+	}
+	obj := &storage.Object{Key: aws.String(item.Key), Size: item.Size, Timings: storage.ObjectTimings{Listed: time.Now()}}
+	if etag := strings.Trim(item.ETag, `"`); etag != "" {
+		obj.ETag = aws.String(`"` + etag + `"`)
+	}
+	if item.LastModified != "" {
+		t, err := time.Parse(time.RFC3339Nano, item.LastModified)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modification time %q of key %s: %s", item.LastModified, item.Key, err)
+		}
+		obj.Mtime = &t
+	}
+	if item.StorageClass != "" {
+		obj.StorageClass = aws.String(item.StorageClass)
+	}
+	return obj, nil
+}
+
+// scanInventoryCSV call fn for every object of CSV file. If the first row has Key column, it is the header
+// and columns are matched by names (Key, Size, ETag, LastModifiedDate or LastModified, StorageClass), noncurrent
+// versions and delete markers are skipped by IsLatest and IsDeleteMarker columns. Otherwise rows are S3 inventory
+// report rows with given columns, see inventoryFileSchema, and URL-encoded keys.
+func scanInventoryCSV(r io.Reader, columns map[string]int, fn func(item inventoryFileObject) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	encoded := true
+	row, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range row {
+		if strings.EqualFold(strings.TrimSpace(name), "key") {
+			columns = make(map[string]int, len(row))
+			for i, name := range row {
+				columns[strings.ToLower(strings.TrimSpace(name))] = i
+			}
+			if _, ok := columns["lastmodifieddate"]; !ok {
+				if i, ok := columns["lastmodified"]; ok {
+					columns["lastmodifieddate"] = i
+				}
+			}
+			encoded = false
+			break
+		}
+	}
+	column := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && (i < len(row)) {
+			return row[i]
+		}
+		return ""
+	}
+	handle := func(row []string) error {
+		if (column(row, "islatest") == "false") || (column(row, "isdeletemarker") == "true") {
+			return nil
+		}
+		item := inventoryFileObject{
+			Key:          column(row, "key"),
+			ETag:         column(row, "etag"),
+			LastModified: column(row, "lastmodifieddate"),
+			StorageClass: column(row, "storageclass"),
+		}
+		if encoded {
+			var err error
+			if item.Key, err = url.QueryUnescape(item.Key); err != nil {
+				return fmt.Errorf("invalid key %q: %s", column(row, "key"), err)
+			}
+		}
+		if size := column(row, "size"); size != "" {
+			n, err := strconv.ParseInt(size, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid size %q of key %s", size, item.Key)
+			}
+			item.Size = &n
+		}
+		return fn(item)
+	}
+
+	if encoded {
+		if err := handle(row); err != nil {
+			return err
+		}
+	}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := handle(row); err != nil {
+			return err
+		}
+	}
+}
+
+// scanInventoryJSON call fn for every object of JSON file, an array of objects or an object with such array
+// in Contents field, like output of aws s3api list-objects. Objects are decoded one by one, so the whole file
+// is never kept in memory.
+func scanInventoryJSON(r io.Reader, fn func(item inventoryFileObject) error) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == json.Delim('[') {
+		return scanInventoryJSONArray(dec, fn)
+	} else if tok != json.Delim('{') {
+		return fmt.Errorf("expected array or object, got: %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if name, _ := tok.(string); name != "Contents" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		if tok, err = dec.Token(); err != nil {
+			return err
+		} else if tok != json.Delim('[') {
+			return fmt.Errorf("expected array in Contents, got: %v", tok)
+		}
+		if err := scanInventoryJSONArray(dec, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanInventoryJSONArray call fn for every object of JSON array, the opening bracket is already read.
+func scanInventoryJSONArray(dec *json.Decoder, fn func(item inventoryFileObject) error) error {
+	for dec.More() {
+		var item inventoryFileObject
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+	_, err := dec.Token()
+	return err
+}
+
+// scanInventoryJSONL call fn for every object of JSON lines file, one object per line.
+func scanInventoryJSONL(r io.Reader, fn func(item inventoryFileObject) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var item inventoryFileObject
+		if err := dec.Decode(&item); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(item); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package syncer
+
+import (
+	"context"
+	"github.com/larrabee/s3sync/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInventoryFileFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-inventory-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"inventory.csv": `"src","data/a+file.txt","1","2020-01-01T00:00:00.000Z","aaa"` + "\n" +
+			`"src","other/skipped.txt","2","2020-01-01T00:00:00.000Z","bbb"` + "\n" +
+			`"src","data/b.txt","3","2020-01-02T00:00:00.000Z","ccc"` + "\n",
+		"header.csv": "Key,ETag,Size,LastModified,IsLatest\n" +
+			"data/a file.txt,aaa,1,2020-01-01T00:00:00.000Z,true\n" +
+			"data/old.txt,ddd,5,2019-01-01T00:00:00.000Z,false\n" +
+			"data/b.txt,ccc,3,2020-01-02T00:00:00.000Z,true\n",
+		"list.json": `{"Contents": [` +
+			`{"Key": "data/a file.txt", "LastModified": "2020-01-01T00:00:00+00:00", "ETag": "\"aaa\"", "Size": 1, "StorageClass": "STANDARD"},` +
+			`{"Key": "data/b.txt", "LastModified": "2020-01-02T00:00:00+00:00", "ETag": "\"ccc\"", "Size": 3, "StorageClass": "STANDARD"}` +
+			`], "RequestCharged": null}`,
+		"array.json": `[{"Key": "data/a file.txt", "LastModified": "2020-01-01T00:00:00Z", "ETag": "\"aaa\"", "Size": 1},` +
+			`{"Key": "data/b.txt", "LastModified": "2020-01-02T00:00:00Z", "ETag": "\"ccc\"", "Size": 3}]`,
+		"list.jsonl": `{"key": "data/a file.txt", "size": 1, "etag": "aaa", "lastmodified": "2020-01-01T00:00:00Z"}` + "\n" +
+			`{"key": "data/b.txt", "size": 3, "etag": "ccc", "lastmodified": "2020-01-02T00:00:00Z"}` + "\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		src := &inventoryFileSource{file: path, prefix: "data/"}
+		output := make(chan *storage.Object, 10)
+		if err := src.List(context.Background(), output); err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		close(output)
+
+		var listed []string
+		for obj := range output {
+			listed = append(listed, *obj.Key+" "+*obj.ETag+" "+obj.Mtime.UTC().Format("2006-01-02"))
+			if (obj.Size == nil) || (*obj.Size == 0) {
+				t.Errorf("%s: object %s has no size", name, *obj.Key)
+			}
+		}
+		expected := `data/a file.txt "aaa" 2020-01-01,data/b.txt "ccc" 2020-01-02`
+		if strings.Join(listed, ",") != expected {
+			t.Errorf("%s: listed %v, expected %s", name, listed, expected)
+		}
+	}
+}
+
+func TestInventoryFileErrors(t *testing.T) {
+	if _, err := inventoryFileFormat("objects.txt"); err == nil {
+		t.Errorf("unknown extension is accepted")
+	}
+	if format, err := inventoryFileFormat("objects.JSONL.gz"); (err != nil) || (format != inventoryFileJSONL) {
+		t.Errorf("inventoryFileFormat(objects.JSONL.gz) = %s, %v", format, err)
+	}
+
+	dir, err := ioutil.TempDir("", "s3sync-inventory-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bad.csv")
+	if err := ioutil.WriteFile(path, []byte(`"src","data/a.txt","big","2020-01-01T00:00:00.000Z","aaa"`+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := &inventoryFileSource{file: path, prefix: "data/"}
+	if err := src.List(context.Background(), make(chan *storage.Object, 10)); err == nil {
+		t.Errorf("invalid size is accepted")
+	}
+}
+
+func TestInventoryFileColumns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-inventory-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schema := "Bucket, Key, VersionId, IsLatest, IsDeleteMarker, Size, LastModifiedDate, ETag"
+	manifest := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(manifest, []byte(`{"fileFormat": "CSV", "fileSchema": "`+schema+`"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "versions.csv")
+	data := `"src","data/a+file.txt","v2","true","false","1","2020-01-01T00:00:00.000Z","aaa"` + "\n" +
+		`"src","data/a+file.txt","v1","false","false","4","2019-01-01T00:00:00.000Z","ddd"` + "\n" +
+		`"src","data/gone.txt","v3","true","true","","2020-01-03T00:00:00.000Z",""` + "\n" +
+		`"src","data/b.txt","v4","true","false","3","2020-01-02T00:00:00.000Z","ccc"` + "\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, columns := range []string{schema, manifest} {
+		cols, err := inventoryFileSchema(columns)
+		if err != nil {
+			t.Errorf("%s: %s", columns, err)
+			continue
+		}
+		src := &inventoryFileSource{file: path, prefix: "data/", columns: cols}
+		output := make(chan *storage.Object, 10)
+		if err := src.List(context.Background(), output); err != nil {
+			t.Errorf("%s: %s", columns, err)
+			continue
+		}
+		close(output)
+		var listed []string
+		for obj := range output {
+			listed = append(listed, *obj.Key+" "+*obj.ETag)
+		}
+		if expected := `data/a file.txt "aaa",data/b.txt "ccc"`; strings.Join(listed, ",") != expected {
+			t.Errorf("%s: listed %v, expected %s", columns, listed, expected)
+		}
+	}
+
+	if _, err := inventoryFileSchema("Bucket, Size"); err == nil {
+		t.Errorf("columns without Key are accepted")
+	}
+}
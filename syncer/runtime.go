@@ -0,0 +1,50 @@
+package syncer
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultMaxRuntimeGrace is the default time to wait for objects in flight when Options.MaxRuntime is reached.
+const DefaultMaxRuntimeGrace = time.Minute
+
+// ErrMaxRuntime is returned by Run if the sync is stopped by Options.MaxRuntime.
+var ErrMaxRuntime = errors.New("max runtime is reached")
+
+// maxRuntimeCheckInterval is the interval of checks of objects in flight after Options.MaxRuntime is reached.
+const maxRuntimeCheckInterval = 100 * time.Millisecond
+
+// stopAtDeadline stop admitting new objects at deadline and wait for objects in flight up to Options.MaxRuntimeGrace,
+// then stopped is closed and stop is called. It returns without stopping if ctx is done first.
+func (job *Job) stopAtDeadline(ctx context.Context, deadline time.Time, stop context.CancelFunc, stopped chan<- struct{}) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	job.log.Warnf("Max runtime %s is reached, new objects are not started, waiting up to %s for %d objects in flight",
+		job.opts.MaxRuntime, job.opts.MaxRuntimeGrace, job.InFlight())
+	job.setPaused(pauseMaxRuntime, true)
+	grace := time.NewTimer(job.opts.MaxRuntimeGrace)
+	defer grace.Stop()
+	ticker := time.NewTicker(maxRuntimeCheckInterval)
+	defer ticker.Stop()
+	for inFlight := job.InFlight(); inFlight > 0; inFlight = job.InFlight() {
+		select {
+		case <-ctx.Done():
+			return
+		case <-grace.C:
+			job.log.Warnf("Max runtime grace period is over, %d objects in flight are aborted", inFlight)
+			close(stopped)
+			stop()
+			return
+		case <-ticker.C:
+		}
+	}
+	close(stopped)
+	stop()
+}
@@ -0,0 +1,57 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMaxRuntimeStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "source")
+	if err := os.Mkdir(source, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5000; i++ {
+		if err := ioutil.WriteFile(filepath.Join(source, fmt.Sprintf("%d.txt", i)), []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	goroutines := runtime.NumGoroutine()
+	job, err := New(Options{
+		Source:          Connection{Type: storage.TypeFS, Path: source + "/"},
+		Target:          Connection{Type: storage.TypeFS, Path: filepath.Join(dir, "target") + "/"},
+		Workers:         2,
+		MaxRuntime:      time.Millisecond,
+		MaxRuntimeGrace: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := job.Run(context.Background()); err != ErrMaxRuntime {
+		t.Fatalf("expected ErrMaxRuntime, got %v", err)
+	}
+	if job.pauses != 0 {
+		t.Errorf("job is paused after the run: %b", job.pauses)
+	}
+
+	// Goroutines of the pipeline exit shortly after the run.
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 50 {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines left after the run, %d before:\n%s", runtime.NumGoroutine(), goroutines, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
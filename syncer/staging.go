@@ -0,0 +1,67 @@
+package syncer
+
+import (
+	"context"
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/storage"
+	"path"
+	"strings"
+)
+
+// stagingPath return the key prefix of staged objects in target bucket.
+func stagingPath(opts Options) string {
+	return strings.TrimSuffix(path.Join(opts.S3.StagingPrefix, opts.Target.Path), "/") + "/"
+}
+
+// commitStaging move all staged objects to target path.
+func commitStaging(ctx context.Context, opts Options, st storage.Storage) error {
+	return runStagingStep(ctx, opts, st, pipeline.Step{
+		Name:       "CommitStagedObj",
+		Fn:         collection.CommitStagedObject,
+		AddWorkers: opts.Workers,
+		Config: collection.StagingConfig{
+			StagingPath:  stagingPath(opts),
+			TargetPath:   opts.Target.Path,
+			ACL:          opts.S3.ACL,
+			StorageClass: opts.S3.StorageClass,
+		},
+	})
+}
+
+// cleanStaging remove all staged objects.
+func cleanStaging(ctx context.Context, opts Options, st storage.Storage) error {
+	return runStagingStep(ctx, opts, st, pipeline.Step{
+		Name:       "DeleteStagedObj",
+		Fn:         collection.DeleteSourceObject,
+		AddWorkers: opts.Workers,
+	})
+}
+
+// runStagingStep apply given step to all staged objects and return first error.
+func runStagingStep(ctx context.Context, opts Options, st storage.Storage, step pipeline.Step) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	group := pipeline.NewGroup()
+	group.WithContext(ctx)
+	group.WithObjectTimeout(opts.ObjectTimeout)
+	group.SetSource(st)
+	group.SetTarget(st)
+	group.AddPipeStep(pipeline.Step{
+		Name:     "ListStaging",
+		Fn:       collection.ListSourceStorage,
+		ChanSize: opts.ListBuffer,
+	})
+	group.AddPipeStep(step)
+	group.AddPipeStep(pipeline.Step{
+		Name: "Terminator",
+		Fn:   collection.Terminator,
+	})
+	group.Run()
+
+	if err := <-group.ErrChan(); err != nil {
+		return err.(*pipeline.PipelineError).Err
+	}
+	return nil
+}
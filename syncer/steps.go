@@ -0,0 +1,445 @@
+package syncer
+
+import (
+	"github.com/larrabee/s3sync/pipeline"
+	"github.com/larrabee/s3sync/pipeline/collection"
+	"github.com/larrabee/s3sync/storage"
+)
+
+// addSteps add pipeline steps configured by job options to group.
+// Synced objects are counted to transferred, other stats collected by steps are stored to res.
+func (job *Job) addSteps(group *pipeline.Group, spillDir string, transferred *collection.ThroughputStats, res *Result) {
+	opts := job.opts
+	filters := opts.Filters
+
+	group.AddPipeStep(pipeline.Step{
+		Name:     "ListSource",
+		Fn:       collection.ListSourceStorage,
+		ChanSize: opts.ListBuffer,
+	})
+
+	if opts.FS.RenameConflict != "" {
+		res.Renames = collection.NewRenameLog()
+		group.AddPipeStep(pipeline.Step{
+			Name: "ResolveKeyConflicts",
+			Fn:   collection.ResolveKeyConflicts,
+			Config: collection.RenameConflictConfig{
+				Policy:         opts.FS.RenameConflict,
+				Renames:        res.Renames,
+				SpillDir:       spillDir,
+				SpillCacheSize: filters.SpillCacheSize,
+			},
+		})
+	}
+
+	if filters.KeyGlob != "" {
+		globCfg := collection.KeyGlobConfig{Pattern: filters.KeyGlob}
+		if opts.Source.Type == storage.TypeS3 {
+			globCfg.Prefix = opts.Source.Path
+		}
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByKeyGlob",
+			Fn:     collection.FilterObjectsByKeyGlob,
+			Config: globCfg,
+		})
+	}
+
+	if filters.Completed != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjCompleted",
+			Fn:     collection.FilterObjectsCompleted,
+			Config: filters.Completed,
+		})
+	}
+
+	if len(filters.Ext) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByExt",
+			Fn:     collection.FilterObjectsByExt,
+			Config: filters.Ext,
+		})
+	}
+
+	if len(filters.ExtNot) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByExtNot",
+			Fn:     collection.FilterObjectsByExtNot,
+			Config: filters.ExtNot,
+		})
+	}
+
+	loadObjMetaStep := pipeline.Step{
+		Name:       "LoadObjMeta",
+		Fn:         collection.LoadObjectMeta,
+		AddWorkers: opts.Workers,
+	}
+	if (opts.Source.Type == storage.TypeFS) && ((filters.MtimeAfter > 0) || (filters.MtimeBefore > 0) || filters.Modified || filters.CompareListing) {
+		group.AddPipeStep(loadObjMetaStep)
+	} else if (len(filters.CT) > 0) || (len(filters.CTNot) > 0) {
+		group.AddPipeStep(loadObjMetaStep)
+	} else if (len(filters.SkipIfMeta) > 0) && !filters.SkipIfMetaNoHead {
+		group.AddPipeStep(loadObjMetaStep)
+	} else if len(filters.TieringStatus) > 0 {
+		group.AddPipeStep(loadObjMetaStep)
+	}
+
+	if filters.MtimeAfter > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjectsByMtimeAfter",
+			Fn:     collection.FilterObjectsByMtimeAfter,
+			Config: filters.MtimeAfter,
+		})
+	}
+
+	if filters.MtimeBefore > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjectsByMtimeBefore",
+			Fn:     collection.FilterObjectsByMtimeBefore,
+			Config: filters.MtimeBefore,
+		})
+	}
+
+	if len(filters.CT) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByCT",
+			Fn:     collection.FilterObjectsByCT,
+			Config: filters.CT,
+		})
+	}
+
+	if len(filters.CTNot) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByCTNot",
+			Fn:     collection.FilterObjectsByCTNot,
+			Config: filters.CTNot,
+		})
+	}
+
+	if len(filters.TieringStatus) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByTieringStatus",
+			Fn:     collection.FilterObjectsByTieringStatus,
+			Config: filters.TieringStatus,
+		})
+	}
+
+	if (len(filters.Tag) > 0) || (len(filters.TagNot) > 0) {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "LoadObjTags",
+			Fn:         collection.LoadObjectTags,
+			AddWorkers: opts.Workers,
+		})
+	}
+
+	if len(filters.Tag) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByTag",
+			Fn:     collection.FilterObjectsByTag,
+			Config: filters.Tag,
+		})
+	}
+
+	if len(filters.TagNot) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByTagNot",
+			Fn:     collection.FilterObjectsByTagNot,
+			Config: filters.TagNot,
+		})
+	}
+
+	skipIfMetaStep := pipeline.Step{
+		Name:   "FilterObjByMetaNot",
+		Fn:     collection.FilterObjectsByMetaNot,
+		Config: filters.SkipIfMeta,
+	}
+	if (len(filters.SkipIfMeta) > 0) && !filters.SkipIfMetaNoHead {
+		group.AddPipeStep(skipIfMetaStep)
+	}
+
+	modifiedCfg := collection.ModifiedConfig{ETagCompat: filters.ETagCompat, SpillDir: spillDir, SpillThreshold: filters.SpillThreshold, SpillCacheSize: filters.SpillCacheSize}
+	if filters.CompareListing {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjectsModifiedByListing",
+			Fn:     collection.FilterObjectsModifiedByListing,
+			Config: modifiedCfg,
+		})
+	} else if filters.Modified {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjectsModified",
+			Fn:     collection.FilterObjectsModified,
+			Config: modifiedCfg,
+		})
+	}
+
+	if filters.SampleRate > 0 {
+		job.log.Infof("Syncing sample of %g%% of objects, seed: %d", filters.SampleRate*100, filters.SampleSeed)
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjBySample",
+			Fn:     collection.FilterObjectsBySample,
+			Config: collection.SampleConfig{Rate: filters.SampleRate, Seed: filters.SampleSeed},
+		})
+	}
+
+	if opts.ByteBudget != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FilterObjByByteBudget",
+			Fn:     collection.FilterObjectsByByteBudget,
+			Config: opts.ByteBudget,
+		})
+	}
+
+	if opts.Hooks.PreObject != "" {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "PreObjectHook",
+			Fn:         collection.ObjectHook,
+			AddWorkers: opts.Hooks.Workers - 1,
+			Config:     job.hookConfig(collection.HookPreObject, opts.Hooks.PreObject),
+		})
+	}
+
+	transferWorkers := opts.Workers
+	if opts.WorkersAuto {
+		transferWorkers = opts.WorkersMax
+	}
+
+	if opts.S3.SyncACLOnly {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "SyncObjACL",
+			Fn:         collection.SyncObjectACL,
+			AddWorkers: opts.Workers,
+			Config:     filters.ETagCompat,
+		})
+	} else if opts.S3.Select.Expression != "" {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "SelectObjData",
+			Fn:         collection.SelectObjectData,
+			AddWorkers: transferWorkers,
+			Config: collection.SelectConfig{
+				Query: opts.S3.Select,
+				Gate:  job.downloadGate,
+			},
+		})
+	} else {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "LoadObjData",
+			Fn:         collection.LoadObjectData,
+			AddWorkers: transferWorkers,
+			Config:     job.downloadGate,
+		})
+	}
+
+	if (len(filters.SkipIfMeta) > 0) && filters.SkipIfMetaNoHead {
+		group.AddPipeStep(skipIfMetaStep)
+	}
+
+	if opts.KeyHashShard > 0 {
+		shardCfg := collection.KeyShardConfig{Shards: opts.KeyHashShard, Reverse: opts.KeyHashShardReverse}
+		if opts.Source.Type == storage.TypeS3 {
+			shardCfg.Prefix = opts.Source.Path
+		}
+		group.AddPipeStep(pipeline.Step{
+			Name:   "KeyHashShard",
+			Fn:     collection.KeyHashShard,
+			Config: shardCfg,
+		})
+	}
+
+	if res.Renames != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ApplyKeyRenames",
+			Fn:     collection.ApplyKeyRenames,
+			Config: res.Renames,
+		})
+	}
+
+	if job.keyTemplate != nil {
+		templateCfg := collection.KeyTemplateConfig{Template: job.keyTemplate, SpillDir: spillDir, SpillCacheSize: filters.SpillCacheSize}
+		if opts.Source.Type == storage.TypeS3 {
+			templateCfg.Prefix = opts.Source.Path
+		}
+		group.AddPipeStep(pipeline.Step{
+			Name:   "RenderTargetKey",
+			Fn:     collection.RenderTargetKey,
+			Config: templateCfg,
+		})
+	}
+
+	if opts.Flatten != "" {
+		res.FlattenCollisions = collection.NewRenameLog()
+		flattenCfg := collection.FlattenConfig{Policy: opts.Flatten, Collisions: res.FlattenCollisions, SpillDir: spillDir, SpillCacheSize: filters.SpillCacheSize}
+		if opts.Source.Type == storage.TypeS3 {
+			flattenCfg.Prefix = opts.Source.Path
+		}
+		group.AddPipeStep(pipeline.Step{
+			Name:   "FlattenKeys",
+			Fn:     collection.FlattenKeys,
+			Config: flattenCfg,
+		})
+	}
+
+	if (opts.FlattenSeparator != "") || (opts.UnflattenSeparator != "") {
+		name := "FlattenKeys"
+		flattenCfg := collection.FlattenConfig{Policy: collection.FlattenCollisionError, Separator: opts.FlattenSeparator, SpillDir: spillDir, SpillCacheSize: filters.SpillCacheSize}
+		if opts.UnflattenSeparator != "" {
+			name = "UnflattenKeys"
+			flattenCfg.Separator, flattenCfg.Reverse = opts.UnflattenSeparator, true
+		}
+		if opts.Source.Type == storage.TypeS3 {
+			flattenCfg.Prefix = opts.Source.Path
+		}
+		group.AddPipeStep(pipeline.Step{
+			Name:   name,
+			Fn:     collection.FlattenKeys,
+			Config: flattenCfg,
+		})
+	}
+
+	if opts.ContentHashRename {
+		group.AddPipeStep(pipeline.Step{
+			Name: "ContentHashKey",
+			Fn:   collection.ContentHashKey,
+		})
+	}
+
+	if len(opts.ContentTypeMap) > 0 {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ContentTypeUpdater",
+			Fn:     collection.ContentTypeUpdater,
+			Config: opts.ContentTypeMap,
+		})
+	}
+
+	if (opts.Target.Type == storage.TypeS3) && (opts.S3.ACL != "") {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ACLUpdater",
+			Fn:     collection.ACLUpdater,
+			Config: opts.S3.ACL,
+		})
+	}
+
+	if (opts.Target.Type == storage.TypeS3) && (opts.S3.StorageClass != "") {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "StorageClassUpdater",
+			Fn:     collection.StorageClassUpdater,
+			Config: opts.S3.StorageClass,
+		})
+	}
+
+	if opts.S3.DedupByContent {
+		res.ContentDedup = collection.NewContentIndex(spillDir, filters.SpillCacheSize)
+		group.AddPipeStep(pipeline.Step{
+			Name:       "UploadObjDedup",
+			Fn:         collection.UploadObjectDedup,
+			AddWorkers: transferWorkers,
+			Config:     collection.ContentDedupConfig{Index: res.ContentDedup, Gate: job.uploadGate},
+		})
+	} else if !opts.S3.SyncACLOnly {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "UploadObj",
+			Fn:         collection.UploadObjectData,
+			AddWorkers: transferWorkers,
+			Config:     job.uploadGate,
+		})
+	}
+
+	if opts.ByteBudget != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ChargeByteBudget",
+			Fn:     collection.ChargeByteBudget,
+			Config: opts.ByteBudget,
+		})
+	}
+
+	if opts.Hooks.PostObject != "" {
+		group.AddPipeStep(pipeline.Step{
+			Name:       "PostObjectHook",
+			Fn:         collection.ObjectHook,
+			AddWorkers: opts.Hooks.Workers - 1,
+			Config:     job.hookConfig(collection.HookPostObject, opts.Hooks.PostObject),
+		})
+	}
+
+	if opts.Timing {
+		res.Timing = collection.NewTimingStats()
+		group.AddPipeStep(pipeline.Step{
+			Name:   "TimingLogger",
+			Fn:     collection.TimingLogger,
+			Config: res.Timing,
+		})
+	}
+
+	if opts.Latency {
+		res.Latency = collection.NewLatencyStats(opts.LatencyLog)
+		group.AddPipeStep(pipeline.Step{
+			Name:   "LatencyTracker",
+			Fn:     collection.LatencyTracker,
+			Config: res.Latency,
+		})
+	}
+
+	if opts.CountByPrefix > 0 {
+		res.Prefixes = collection.NewPrefixStats(opts.CountByPrefix)
+		group.AddPipeStep(pipeline.Step{
+			Name:   "PrefixCounter",
+			Fn:     collection.PrefixCounter,
+			Config: res.Prefixes,
+		})
+	}
+
+	if opts.Target.Type == storage.TypeNull {
+		res.Throughput = collection.NewThroughputStats()
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ThroughputLogger",
+			Fn:     collection.ThroughputLogger,
+			Config: res.Throughput,
+		})
+	}
+
+	if opts.SyncLog {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "Logger",
+			Fn:     collection.Logger,
+			Config: job.log,
+		})
+	}
+
+	if opts.RateLimits.Objects != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "RateLimit",
+			Fn:     collection.PipelineRateLimit,
+			Config: opts.RateLimits.Objects,
+		})
+	}
+
+	if opts.Tracer != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name: "TraceObjects",
+			Fn:   collection.TraceObjects,
+		})
+	}
+
+	if opts.OnObject != nil {
+		group.AddPipeStep(pipeline.Step{
+			Name:   "ObjectCallback",
+			Fn:     collection.ObjectCallback,
+			Config: opts.OnObject,
+		})
+	}
+
+	group.AddPipeStep(pipeline.Step{
+		Name:   "Terminator",
+		Fn:     collection.Terminator,
+		Config: transferred,
+	})
+}
+
+// hookConfig return configuration of ObjectHook step running given command.
+func (job *Job) hookConfig(hook, command string) collection.HookConfig {
+	return collection.HookConfig{
+		Hook:       hook,
+		Command:    command,
+		Timeout:    job.opts.Hooks.Timeout,
+		FailObject: job.opts.Hooks.FailObject,
+		TargetURL:  job.opts.Target.objectURL,
+	}
+}
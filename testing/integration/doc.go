@@ -0,0 +1,9 @@
+// Package integration contains integration tests of s3sync against MinIO started with testcontainers-go.
+//
+// The tests require Docker and are gated by "integration" build tag, so they don't run with plain go test.
+// They are a separate module depending on s3sync from the parent dir, run them from the module dir:
+//
+//	cd testing/integration && go test -tags integration .
+//
+// MinIO image can be overridden with S3SYNC_TEST_MINIO_IMAGE environment variable.
+package integration
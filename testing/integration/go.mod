@@ -0,0 +1,11 @@
+module github.com/larrabee/s3sync/testing/integration
+
+go 1.13
+
+require (
+	github.com/aws/aws-sdk-go v1.20.6
+	github.com/larrabee/s3sync v0.0.0-00010101000000-000000000000
+	github.com/testcontainers/testcontainers-go v0.14.0
+)
+
+replace github.com/larrabee/s3sync => ../..
@@ -0,0 +1,208 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	defaultMinioImage = "minio/minio:RELEASE.2022-06-25T15-50-16Z"
+	minioUser         = "s3sync"
+	minioPassword     = "s3sync-password"
+	minioRegion       = "us-east-1"
+	// syncTimeout limits every sync run of tests.
+	syncTimeout = time.Minute
+)
+
+// minio is MinIO server shared by all tests, it is started by TestMain.
+var minio struct {
+	endpoint string
+	client   *s3.S3
+}
+
+// bucketSeq makes bucket names unique, every test uses its own buckets.
+var bucketSeq uint64
+
+func TestMain(m *testing.M) {
+	ctx := context.Background()
+	container, err := startMinio(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to start MinIO container: %s\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if err := container.Terminate(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to terminate MinIO container: %s\n", err)
+	}
+	os.Exit(code)
+}
+
+// startMinio start MinIO container and set minio endpoint and client.
+func startMinio(ctx context.Context) (testcontainers.Container, error) {
+	image := os.Getenv("S3SYNC_TEST_MINIO_IMAGE")
+	if image == "" {
+		image = defaultMinioImage
+	}
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        image,
+			Cmd:          []string{"server", "/data"},
+			Env:          map[string]string{"MINIO_ROOT_USER": minioUser, "MINIO_ROOT_PASSWORD": minioPassword},
+			ExposedPorts: []string{"9000/tcp"},
+			WaitingFor:   wait.ForHTTP("/minio/health/live").WithPort("9000/tcp").WithStartupTimeout(time.Minute),
+		},
+		Started: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	host, err := container.Host(ctx)
+	if err != nil {
+		_ = container.Terminate(ctx)
+		return nil, err
+	}
+	port, err := container.MappedPort(ctx, "9000/tcp")
+	if err != nil {
+		_ = container.Terminate(ctx)
+		return nil, err
+	}
+	minio.endpoint = fmt.Sprintf("http://%s:%s", host, port.Port())
+	minio.client = newS3Client(minio.endpoint)
+	return container, nil
+}
+
+func newS3Client(endpoint string) *s3.S3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Credentials:      credentials.NewStaticCredentials(minioUser, minioPassword, ""),
+		Endpoint:         aws.String(endpoint),
+		Region:           aws.String(minioRegion),
+		S3ForcePathStyle: aws.Bool(true),
+	}))
+	return s3.New(sess)
+}
+
+// newBucket create empty bucket with unique name.
+func newBucket(t *testing.T) string {
+	t.Helper()
+	bucket := fmt.Sprintf("test-%d-%d", time.Now().Unix(), atomic.AddUint64(&bucketSeq, 1))
+	if _, err := minio.client.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)}); err != nil {
+		t.Fatalf("failed to create bucket %s: %s", bucket, err)
+	}
+	return bucket
+}
+
+// putObjects upload objects to the bucket, objects map keys to content.
+func putObjects(t *testing.T, bucket string, objects map[string]string) {
+	t.Helper()
+	for key, content := range objects {
+		_, err := minio.client.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(content)),
+		})
+		if err != nil {
+			t.Fatalf("failed to put object %s/%s: %s", bucket, key, err)
+		}
+	}
+}
+
+// readObjects return content of all objects in the bucket under prefix, keys are relative to the prefix.
+func readObjects(t *testing.T, bucket, prefix string) map[string]string {
+	t.Helper()
+	res := make(map[string]string)
+	err := minio.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{Bucket: aws.String(bucket), Prefix: aws.String(prefix)},
+		func(page *s3.ListObjectsV2Output, last bool) bool {
+			for _, obj := range page.Contents {
+				out, err := minio.client.GetObject(&s3.GetObjectInput{Bucket: aws.String(bucket), Key: obj.Key})
+				if err != nil {
+					t.Fatalf("failed to get object %s/%s: %s", bucket, *obj.Key, err)
+				}
+				data, err := ioutil.ReadAll(out.Body)
+				_ = out.Body.Close()
+				if err != nil {
+					t.Fatalf("failed to read object %s/%s: %s", bucket, *obj.Key, err)
+				}
+				res[(*obj.Key)[len(prefix):]] = string(data)
+			}
+			return true
+		})
+	if err != nil {
+		t.Fatalf("failed to list bucket %s: %s", bucket, err)
+	}
+	return res
+}
+
+// writeFiles create files in dir, files map relative paths to content.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// s3Conn return connection to MinIO bucket with given endpoint, empty endpoint means MinIO endpoint.
+func s3Conn(endpoint, bucket, path string) syncer.Connection {
+	if endpoint == "" {
+		endpoint = minio.endpoint
+	}
+	return syncer.Connection{
+		Type:     storage.TypeS3,
+		Bucket:   bucket,
+		Path:     path,
+		Key:      minioUser,
+		Secret:   minioPassword,
+		Region:   minioRegion,
+		Endpoint: endpoint,
+	}
+}
+
+// runSync run sync job with given options.
+func runSync(t *testing.T, opts syncer.Options) (syncer.Result, error) {
+	t.Helper()
+	job, err := syncer.New(opts)
+	if err != nil {
+		t.Fatalf("invalid sync options: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	return job.Run(ctx)
+}
+
+// assertObjects check that got objects are exactly the expected ones.
+func assertObjects(t *testing.T, got, expected map[string]string) {
+	t.Helper()
+	for key, content := range expected {
+		if got[key] != content {
+			t.Errorf("object %s: expected content %q, got %q", key, content, got[key])
+		}
+	}
+	for key := range got {
+		if _, ok := expected[key]; !ok {
+			t.Errorf("unexpected object %s", key)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+//go:build integration
+// +build integration
+
+package integration
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/larrabee/s3sync/storage"
+	"github.com/larrabee/s3sync/syncer"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testObjects = map[string]string{
+	"index.html":         "<html></html>",
+	"img/cat.jpg":        "cat",
+	"img/dog.jpg":        "dog",
+	"img/2020/bird.png":  "bird",
+	"docs/readme.txt":    "readme",
+	"docs/deep/a/b/c.md": "deep",
+}
+
+func TestFSToS3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-integration-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, testObjects)
+	bucket := newBucket(t)
+
+	res, err := runSync(t, syncer.Options{
+		Source: syncer.Connection{Type: storage.TypeFS, Path: dir + "/"},
+		Target: s3Conn("", bucket, "backup/"),
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	if res.Synced != uint64(len(testObjects)) {
+		t.Errorf("expected %d synced objects, got %d", len(testObjects), res.Synced)
+	}
+	assertObjects(t, readObjects(t, bucket, "backup/"), testObjects)
+}
+
+func TestS3ToS3(t *testing.T) {
+	source, target := newBucket(t), newBucket(t)
+	putObjects(t, source, testObjects)
+
+	res, err := runSync(t, syncer.Options{
+		Source: s3Conn("", source, ""),
+		Target: s3Conn("", target, "copy/"),
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	if res.Synced != uint64(len(testObjects)) {
+		t.Errorf("expected %d synced objects, got %d", len(testObjects), res.Synced)
+	}
+	assertObjects(t, readObjects(t, target, "copy/"), testObjects)
+}
+
+func TestFilterExt(t *testing.T) {
+	source, target := newBucket(t), newBucket(t)
+	putObjects(t, source, testObjects)
+
+	_, err := runSync(t, syncer.Options{
+		Source:  s3Conn("", source, ""),
+		Target:  s3Conn("", target, ""),
+		Filters: syncer.Filters{Ext: []string{".jpg", ".png"}},
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	assertObjects(t, readObjects(t, target, ""), map[string]string{
+		"img/cat.jpg":       "cat",
+		"img/dog.jpg":       "dog",
+		"img/2020/bird.png": "bird",
+	})
+}
+
+func TestFilterModified(t *testing.T) {
+	source, target := newBucket(t), newBucket(t)
+	putObjects(t, source, testObjects)
+	opts := syncer.Options{
+		Source:  s3Conn("", source, ""),
+		Target:  s3Conn("", target, ""),
+		Filters: syncer.Filters{Modified: true},
+	}
+	if _, err := runSync(t, opts); err != nil {
+		t.Fatalf("first sync failed: %s", err)
+	}
+
+	putObjects(t, source, map[string]string{"img/cat.jpg": "black cat", "new.txt": "new"})
+	res, err := runSync(t, opts)
+	if err != nil {
+		t.Fatalf("second sync failed: %s", err)
+	}
+	if res.Synced != 2 {
+		t.Errorf("expected 2 synced objects (modified and new), got %d", res.Synced)
+	}
+	expected := make(map[string]string)
+	for key, content := range testObjects {
+		expected[key] = content
+	}
+	expected["img/cat.jpg"] = "black cat"
+	expected["new.txt"] = "new"
+	assertObjects(t, readObjects(t, target, ""), expected)
+}
+
+// TestSizeMismatch check that target objects changed outside of s3sync are synced again.
+func TestSizeMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		filters syncer.Filters
+	}{
+		{name: "modified", filters: syncer.Filters{Modified: true}},
+		{name: "compare-listing", filters: syncer.Filters{CompareListing: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source, target := newBucket(t), newBucket(t)
+			putObjects(t, source, testObjects)
+			opts := syncer.Options{
+				Source:  s3Conn("", source, ""),
+				Target:  s3Conn("", target, ""),
+				Filters: tc.filters,
+			}
+			if _, err := runSync(t, opts); err != nil {
+				t.Fatalf("first sync failed: %s", err)
+			}
+
+			putObjects(t, target, map[string]string{"docs/readme.txt": "truncated", "img/dog.jpg": ""})
+			res, err := runSync(t, opts)
+			if err != nil {
+				t.Fatalf("second sync failed: %s", err)
+			}
+			if res.Synced != 2 {
+				t.Errorf("expected 2 synced objects, got %d", res.Synced)
+			}
+			assertObjects(t, readObjects(t, target, ""), testObjects)
+		})
+	}
+}
+
+// flakyProxy is reverse proxy to MinIO, which fails first failures upload requests with 503 status.
+type flakyProxy struct {
+	proxy    *httputil.ReverseProxy
+	failures int64
+	failed   int64
+}
+
+func (p *flakyProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if (r.Method == http.MethodPut) && (atomic.AddInt64(&p.failed, 1) <= p.failures) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	p.proxy.ServeHTTP(w, r)
+}
+
+func newFlakyProxy(t *testing.T, failures int64) *httptest.Server {
+	t.Helper()
+	u, err := url.Parse(minio.endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return httptest.NewServer(&flakyProxy{proxy: httputil.NewSingleHostReverseProxy(u), failures: failures})
+}
+
+// TestRetry check s3sync retries of failed uploads. The SDK makes 4 attempts of every request itself,
+// so 6 failures are not recovered by the SDK and require one s3sync retry.
+func TestRetry(t *testing.T) {
+	objects := map[string]string{"retry.txt": "retry"}
+	for _, tc := range []struct {
+		name  string
+		retry uint
+		fail  bool
+	}{
+		{name: "no-retry", retry: 0, fail: true},
+		{name: "retry", retry: 2, fail: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			source, target := newBucket(t), newBucket(t)
+			putObjects(t, source, objects)
+			proxy := newFlakyProxy(t, 6)
+			defer proxy.Close()
+
+			_, err := runSync(t, syncer.Options{
+				Source: s3Conn("", source, ""),
+				Target: s3Conn(proxy.URL, target, ""),
+				S3:     syncer.S3Options{Retry: tc.retry, RetryInterval: 100 * time.Millisecond},
+			})
+			if tc.fail {
+				if err == nil {
+					t.Fatalf("expected sync error without retries")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("sync failed: %s", err)
+			}
+			assertObjects(t, readObjects(t, target, ""), objects)
+		})
+	}
+}
+
+// writeInventory write local CSV inventory report of bucket with given keys and return the manifest path.
+func writeInventory(t *testing.T, dir, bucket string, keys ...string) string {
+	t.Helper()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "data.csv.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	for _, key := range keys {
+		fmt.Fprintf(gz, "%q,%q,%q,%q\n", bucket, key, fmt.Sprint(len(testObjects[key])), "2020-01-01T00:00:00.000Z")
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	manifest := fmt.Sprintf(`{"sourceBucket":%q,"destinationBucket":"arn:aws:s3:::inventory","fileFormat":"CSV",`+
+		`"fileSchema":"Bucket, Key, Size, LastModifiedDate","files":[{"key":"inventory/data.csv.gz"}]}`, bucket)
+	path := filepath.Join(dir, "manifest.json")
+	if err := ioutil.WriteFile(path, []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestInventoryDelete check that objects added to the newer inventory report are synced and objects removed
+// from it are deleted from the target, only under the target path.
+func TestInventoryDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "s3sync-integration-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source, target := newBucket(t), newBucket(t)
+	putObjects(t, source, testObjects)
+	putObjects(t, target, map[string]string{"copy/removed.txt": "removed", "other/removed.txt": "kept"})
+
+	var keys []string
+	for key := range testObjects {
+		keys = append(keys, key)
+	}
+	res, err := runSync(t, syncer.Options{
+		Source: s3Conn("", source, ""),
+		Target: s3Conn("", target, "copy/"),
+		Inventory: syncer.InventoryOptions{
+			Old: writeInventory(t, filepath.Join(dir, "old"), source, "removed.txt", "img/gone.jpg"),
+			New: writeInventory(t, filepath.Join(dir, "new"), source, keys...),
+		},
+	})
+	if err != nil {
+		t.Fatalf("sync failed: %s", err)
+	}
+	if res.Synced != uint64(len(testObjects)) {
+		t.Errorf("expected %d synced objects, got %d", len(testObjects), res.Synced)
+	}
+	// Deleting img/gone.jpg, which is absent in the target, is not an error.
+	if (res.Deleted != 2) || (res.Errors != 0) {
+		t.Errorf("expected 2 deleted objects and no errors, got %d deleted, %d errors", res.Deleted, res.Errors)
+	}
+	assertObjects(t, readObjects(t, target, "copy/"), testObjects)
+	if got := readObjects(t, target, "other/"); got["removed.txt"] != "kept" {
+		t.Errorf("object outside of the target path is deleted: %v", got)
+	}
+}
@@ -0,0 +1,212 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Log implement Logrus logger for debug logging.
+var Log = logrus.New()
+
+const (
+	exportBatchSize      = 512
+	exportQueueSize      = 8192
+	exportInterval       = 5 * time.Second
+	exportTimeout        = 10 * time.Second
+	otlpStatusCodeError  = 2
+	otlpSpanKindInternal = 1
+)
+
+// exporter sends finished spans with OTLP/HTTP JSON protocol in batches.
+// If the queue is full, spans are dropped to avoid slowing down the sync.
+type exporter struct {
+	endpoint    string
+	headers     map[string]string
+	serviceName string
+	client      *http.Client
+	queue       chan *Span
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	closed      bool
+	dropped     uint64
+}
+
+func newExporter(endpoint string, headers map[string]string, serviceName string) *exporter {
+	e := &exporter{
+		endpoint:    endpoint,
+		headers:     headers,
+		serviceName: serviceName,
+		client:      &http.Client{Timeout: exportTimeout},
+		queue:       make(chan *Span, exportQueueSize),
+	}
+	e.wg.Add(1)
+	go e.run()
+	return e
+}
+
+func (e *exporter) add(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		e.dropped++
+	}
+}
+
+func (e *exporter) run() {
+	defer e.wg.Done()
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, exportBatchSize)
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.export(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= exportBatchSize {
+				e.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			e.export(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+func (e *exporter) shutdown() {
+	e.mu.Lock()
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+	e.wg.Wait()
+	if e.dropped > 0 {
+		Log.Warnf("Tracing: %d spans dropped due to full export queue", e.dropped)
+	}
+}
+
+func (e *exporter) export(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	data, err := json.Marshal(e.encode(batch))
+	if err != nil {
+		Log.Errorf("Tracing: failed to encode spans: %s", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(data))
+	if err != nil {
+		Log.Errorf("Tracing: failed to create export request: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		Log.Errorf("Tracing: failed to export %d spans: %s", len(batch), err)
+		return
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		Log.Errorf("Tracing: failed to export %d spans: collector returned %s", len(batch), resp.Status)
+		return
+	}
+	Log.Debugf("Tracing: exported %d spans", len(batch))
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string                 `json:"traceId"`
+	SpanID            string                 `json:"spanId"`
+	ParentSpanID      string                 `json:"parentSpanId,omitempty"`
+	Name              string                 `json:"name"`
+	Kind              int                    `json:"kind"`
+	StartTimeUnixNano string                 `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string                 `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue         `json:"attributes,omitempty"`
+	Status            map[string]interface{} `json:"status,omitempty"`
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch val := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case uint:
+		return map[string]interface{}{"intValue": strconv.FormatUint(uint64(val), 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": val}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(val)}
+	}
+}
+
+func (e *exporter) encode(batch []*Span) map[string]interface{} {
+	spans := make([]otlpSpan, 0, len(batch))
+	var zeroID [8]byte
+	for _, s := range batch {
+		s.mu.Lock()
+		out := otlpSpan{
+			TraceID:           hex.EncodeToString(s.tracer.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != zeroID {
+			out.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for k, v := range s.attrs {
+			out.Attributes = append(out.Attributes, otlpKeyValue{Key: k, Value: otlpValue(v)})
+		}
+		if s.err != nil {
+			out.Status = map[string]interface{}{"code": otlpStatusCodeError, "message": s.err.Error()}
+		}
+		s.mu.Unlock()
+		spans = append(spans, out)
+	}
+
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpValue(e.serviceName)}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "github.com/larrabee/s3sync"},
+						"spans": spans,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,154 @@
+// Package tracing provides minimal OpenTelemetry compatible tracing with OTLP/HTTP JSON export.
+//
+// All methods are safe to call on nil Tracer and nil Span, so disabled tracing has no overhead.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer creates spans and sends finished spans to exporter.
+type Tracer struct {
+	exporter    *exporter
+	sampleRatio float64
+	traceID     [16]byte
+	parentID    [8]byte
+	randMu      sync.Mutex
+	rand        *mrand.Rand
+}
+
+// Config of Tracer.
+type Config struct {
+	// Endpoint is the full OTLP/HTTP traces URL, like http://localhost:4318/v1/traces.
+	Endpoint string
+	// Headers are sent with every export request.
+	Headers map[string]string
+	// ServiceName is used as service.name resource attribute.
+	ServiceName string
+	// SampleRatio is the fraction of sampled object spans. Failed object spans are always sampled.
+	SampleRatio float64
+	// TraceParent is optional W3C traceparent of the caller, root span will be its child.
+	TraceParent string
+}
+
+// Span is a single traced operation.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	spanID   [8]byte
+	parentID [8]byte
+	start    time.Time
+	end      time.Time
+	attrs    map[string]interface{}
+	err      error
+	sampled  bool
+	mu       sync.Mutex
+}
+
+// NewTracer return new configured Tracer.
+func NewTracer(cfg Config) *Tracer {
+	t := &Tracer{
+		exporter:    newExporter(cfg.Endpoint, cfg.Headers, cfg.ServiceName),
+		sampleRatio: cfg.SampleRatio,
+		rand:        mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}
+	if !parseTraceParent(cfg.TraceParent, &t.traceID, &t.parentID) {
+		_, _ = rand.Read(t.traceID[:])
+	}
+	return t
+}
+
+// parseTraceParent parse W3C traceparent header value: "00-<trace-id>-<parent-id>-<flags>".
+func parseTraceParent(s string, traceID *[16]byte, parentID *[8]byte) bool {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return false
+	}
+	tid, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	pid, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	copy(traceID[:], tid)
+	copy(parentID[:], pid)
+	return true
+}
+
+// Start return new started span. Root span should have nil parent.
+// Spans started with Start are always sampled.
+func (t *Tracer) Start(name string, parent *Span) *Span {
+	return t.StartAt(name, parent, time.Now(), true)
+}
+
+// StartSampled return new started span, which is exported with configured sample ratio or on error.
+func (t *Tracer) StartSampled(name string, parent *Span, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	t.randMu.Lock()
+	sampled := t.rand.Float64() < t.sampleRatio
+	t.randMu.Unlock()
+	return t.StartAt(name, parent, start, sampled)
+}
+
+// StartAt return new span with given start time.
+func (t *Tracer) StartAt(name string, parent *Span, start time.Time, sampled bool) *Span {
+	if t == nil {
+		return nil
+	}
+	span := &Span{
+		tracer:  t,
+		name:    name,
+		start:   start,
+		attrs:   make(map[string]interface{}),
+		sampled: sampled,
+	}
+	_, _ = rand.Read(span.spanID[:])
+	if parent != nil {
+		span.parentID = parent.spanID
+	} else {
+		span.parentID = t.parentID
+	}
+	return span
+}
+
+// Shutdown export all finished spans and stop the exporter.
+func (t *Tracer) Shutdown() {
+	if t == nil {
+		return
+	}
+	t.exporter.shutdown()
+}
+
+// SetAttr set span attribute. Supported value types: string, bool, int, int64, uint, uint64, float64.
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs[key] = value
+	s.mu.Unlock()
+}
+
+// End finish the span with given error and send it to exporter.
+// Span with non-nil error is always exported.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.err = err
+	s.mu.Unlock()
+	if s.sampled || err != nil {
+		s.tracer.exporter.add(s)
+	}
+}